@@ -1,7 +1,7 @@
 /*
 Healthcheck is a simple program that sends an HTTP request to the local host (self) to a configured port number.
 It's used in environment where you need a simple probe for health checks (e.g., an empty container in docker).
-The probe URL is http://localhost:3000/liveness . Only the port can be changed.
+The probe URL is http://localhost:3000/liveness by default: port and path can be changed, e.g. to probe the readiness.
 
 Usage:
 
@@ -12,35 +12,58 @@ The flags are:
 	-port <1-65535>
 		Change the port where the request is sent.
 
+	-path <path>
+		Change the path of the request (default /liveness).
+
+	-readiness
+		Probe the readiness (/readiness) instead of the liveness, overriding -path.
+
+	-timeout <duration>
+		Fail if there is no response within the duration (default 5s).
+
 Return values (exit codes):
 
 	0
 		The request was successful (HTTP 200 or HTTP 204)
 
 	> 0
-		The request was not successful (connection error or unexpected HTTP status code)
+		The request was not successful (connection error, timeout or unexpected HTTP status code)
 */
 package main
 
 import (
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"time"
 )
 
 func main() {
 	var port = flag.Int("port", 3000, "HTTP port for healthcheck")
+	var path = flag.String("path", "/liveness", "HTTP path for healthcheck")
+	var readiness = flag.Bool("readiness", false, "check the readiness (/readiness) instead of the path")
+	var timeout = flag.Duration("timeout", 5*time.Second, "timeout of the request")
 
 	flag.Parse()
 
-	res, err := http.Get(fmt.Sprintf("http://localhost:%d/liveness", *port))
+	if *readiness {
+		*path = "/readiness"
+	}
+
+	client := http.Client{Timeout: *timeout}
+
+	res, err := client.Get(fmt.Sprintf("http://localhost:%d%s", *port, *path))
 	if err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	} else if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		// the readiness reports which check failed
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
 		_ = res.Body.Close()
 		_, _ = fmt.Fprintln(os.Stderr, "Healthcheck request not OK: ", res.Status)
+		_, _ = fmt.Fprintln(os.Stderr, string(body))
 		os.Exit(1)
 	}
 	_ = res.Body.Close()
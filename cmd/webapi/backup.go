@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/backup"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"github.com/sirupsen/logrus"
+)
+
+// runBackup implements `webapi backup <archive>`: it writes a backup of the database and of the photos to the file
+// `archive`. The server may be running.
+func runBackup(cfg WebAPIConfiguration, logger *logrus.Logger) error {
+	path := cfg.Args.Num(1)
+	if path == "" {
+		return errors.New("usage: webapi backup <archive>")
+	}
+	if cfg.DB.Driver != string(database.SQLite) {
+		return fmt.Errorf("backups are supported for sqlite3 only, use the tools of %s", cfg.DB.Driver)
+	}
+
+	db, err := sql.Open("sqlite3", cfg.sqliteDSN(true))
+	if err != nil {
+		return fmt.Errorf("opening SQLite: %w", err)
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	manifest, err := writeBackup(context.Background(), db, path)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("backup of %d files written to %s", len(manifest.Files), path)
+	return nil
+}
+
+// runRestore implements `webapi restore <archive>`: it replaces the database and the photos with those in the backup
+// `archive`, after verifying it. The server must be stopped.
+func runRestore(cfg WebAPIConfiguration, logger *logrus.Logger) error {
+	path := cfg.Args.Num(1)
+	if path == "" {
+		return errors.New("usage: webapi restore <archive>")
+	}
+	if cfg.DB.Driver != string(database.SQLite) {
+		return fmt.Errorf("backups are supported for sqlite3 only, use the tools of %s", cfg.DB.Driver)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening backup: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	manifest, err := backup.Restore(f, cfg.DB.Filename, database.PhotoDir)
+	if err != nil {
+		return fmt.Errorf("restoring %s: %w", path, err)
+	}
+
+	logger.Infof("restored %d files from the backup of %s", len(manifest.Files), manifest.CreatedAt.Format(time.RFC3339))
+	return nil
+}
+
+// writeBackup writes a backup of `db` and of the photos to `path`, through a temporary file so that `path` is never
+// left incomplete.
+func writeBackup(ctx context.Context, db *sql.DB, path string) (*backup.Manifest, error) {
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, fmt.Errorf("creating backup: %w", err)
+	}
+
+	manifest, err := backup.Create(ctx, db, database.PhotoDir, f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return nil, fmt.Errorf("writing backup: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// backupPattern matches the names of the scheduled backups, which sort by creation time
+const backupPattern = "decaf-*.tar"
+
+// scheduleBackups writes a backup of `db` into cfg.Backup.Dir every cfg.Backup.Interval, keeping the latest
+// cfg.Backup.Keep ones, until `ctx` is done.
+func scheduleBackups(ctx context.Context, logger logrus.FieldLogger, db *sql.DB, cfg WebAPIConfiguration) {
+	ticker := time.NewTicker(cfg.Backup.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		name := "decaf-" + time.Now().UTC().Format("20060102T150405Z") + ".tar"
+
+		manifest, err := writeBackup(ctx, db, filepath.Join(cfg.Backup.Dir, name))
+		if err != nil {
+			logger.WithError(err).Error("scheduled backup failed")
+			continue
+		}
+		logger.Infof("backup of %d files written to %s", len(manifest.Files), name)
+
+		err = pruneBackups(cfg.Backup.Dir, cfg.Backup.Keep)
+		if err != nil {
+			logger.WithError(err).Error("error removing old backups")
+		}
+	}
+}
+
+// pruneBackups removes the scheduled backups in `dir` but the latest `keep` ones.
+func pruneBackups(dir string, keep int) error {
+	names, err := filepath.Glob(filepath.Join(dir, backupPattern))
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(names)
+
+	for len(names) > keep {
+		err = os.Remove(names[0])
+		if err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+
+	return nil
+}
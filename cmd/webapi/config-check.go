@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ardanlabs/conf"
+	"gopkg.in/yaml.v2"
+)
+
+// runConfig runs the `config` command. `config check` validates the configuration, and prints every field with its
+// value and its source, the last of default, env, flag and file that sets it (see loadConfiguration).
+func runConfig(cfg WebAPIConfiguration) error {
+	if cfg.Args.Num(1) != "check" {
+		return fmt.Errorf("unknown config command %q, only check is available", cfg.Args.Num(1))
+	}
+
+	fields, err := describeConfiguration(cfg, os.Args[1:])
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "FIELD\tSOURCE\tVALUE")
+	for _, f := range fields {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", f.name, f.source, f.value)
+	}
+	_ = tw.Flush()
+
+	err = cfg.validate()
+	var problems configErrors
+	if errors.As(err, &problems) {
+		_, _ = fmt.Fprintln(os.Stderr, "\nthe configuration is invalid:")
+		for _, p := range problems {
+			_, _ = fmt.Fprintln(os.Stderr, "  -", p)
+		}
+		return errors.New("invalid configuration")
+	} else if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintln(os.Stdout, "\nthe configuration is valid")
+	return nil
+}
+
+// configField is a field of the configuration, as printed by `config check`
+type configField struct {
+	name   string
+	value  string
+	source string
+}
+
+// describeConfiguration returns the fields of `cfg`, loaded with the command line `args`, with their sources. Masked
+// fields have their value hidden.
+func describeConfiguration(cfg WebAPIConfiguration, args []string) ([]configField, error) {
+	// conf gives each field of a configuration, with its keys, to the sources: one that records them and gives nothing
+	// else, on a scratch configuration, lists them
+	var scratch WebAPIConfiguration
+	recorder := fieldRecorder{}
+	err := conf.Parse(args, envPrefix, &scratch, recorder)
+	if err != nil {
+		return nil, fmt.Errorf("parsing config: %w", err)
+	}
+
+	var doc map[interface{}]interface{}
+	yamlFile, err := os.ReadFile(cfg.Config.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("can't read the config file, while it exists: %w", err)
+	} else if err == nil {
+		err = yaml.Unmarshal(yamlFile, &doc)
+		if err != nil {
+			return nil, fmt.Errorf("can't unmarshal config file: %w", err)
+		}
+	}
+
+	d := describer{recorder: recorder, env: envVariables(), flags: flagNames(args), doc: doc}
+	d.walk("", nil, reflect.ValueOf(&scratch).Elem(), reflect.ValueOf(cfg))
+	return d.fields, nil
+}
+
+// fieldRecorder is a conf.Sourcer that provides no values, but records the fields asked for, by address
+type fieldRecorder map[uintptr]conf.Field
+
+func (fr fieldRecorder) Source(fld conf.Field) (string, bool) {
+	fr[fld.Field.Addr().Pointer()] = fld
+	return "", false
+}
+
+type describer struct {
+	recorder fieldRecorder
+	env      map[string]bool
+	flags    map[string]bool
+	doc      map[interface{}]interface{}
+
+	fields []configField
+}
+
+// walk appends the fields of the struct `cfg`, at `prefix` in the configuration and at `yamlPath` in the YAML
+// document. `scratch` is the same struct in the scratch configuration, whose addresses identify the fields.
+func (d *describer) walk(prefix string, yamlPath []string, scratch reflect.Value, cfg reflect.Value) {
+	for i := 0; i < cfg.NumField(); i++ {
+		field := cfg.Type().Field(i)
+		if field.Type == reflect.TypeOf(conf.Args{}) {
+			continue
+		}
+
+		name := prefix + field.Name
+		path := append(append([]string{}, yamlPath...), strings.ToLower(field.Name))
+
+		if field.Type.Kind() == reflect.Struct {
+			d.walk(name+".", path, scratch.Field(i), cfg.Field(i))
+			continue
+		}
+
+		f := configField{name: name, value: formatValue(cfg.Field(i)), source: "default"}
+		if recorded, ok := d.recorder[scratch.Field(i).Addr().Pointer()]; ok {
+			if d.env[strings.ToUpper(strings.Join(recorded.EnvKey, "_"))] {
+				f.source = "env"
+			}
+			if d.flags[strings.ToLower(strings.Join(recorded.FlagKey, "-"))] {
+				f.source = "flag"
+			}
+			if recorded.Options.Mask && f.value != "" {
+				f.value = "xxxxxx"
+			}
+		}
+		if inYAML(d.doc, path) {
+			f.source = "file"
+		}
+		d.fields = append(d.fields, f)
+	}
+}
+
+func formatValue(v reflect.Value) string {
+	if list, ok := v.Interface().([]string); ok {
+		return strings.Join(list, ";")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// inYAML returns whether the key at `path` is in the YAML document `doc`.
+func inYAML(doc map[interface{}]interface{}, path []string) bool {
+	var node interface{} = doc
+	for _, key := range path {
+		m, ok := node.(map[interface{}]interface{})
+		if !ok {
+			return false
+		}
+		node, ok = m[key]
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// envVariables returns the names of the environment variables of the configuration, without prefix, as conf reads
+// them.
+func envVariables() map[string]bool {
+	names := map[string]bool{}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envPrefix+"_") {
+			continue
+		}
+		name := strings.SplitN(kv, "=", 2)[0]
+		names[strings.ToUpper(strings.TrimPrefix(name, envPrefix+"_"))] = true
+	}
+	return names
+}
+
+// flagNames returns the names of the flags in `args`, parsed as conf does: up to the first argument that is not a
+// flag, or `--`; a flag without `=` takes the next argument as value, unless it is a flag too.
+func flagNames(args []string) map[string]bool {
+	names := map[string]bool{}
+	for len(args) > 0 {
+		arg := args[0]
+		if len(arg) < 2 || arg[0] != '-' || arg == "--" {
+			break
+		}
+		args = args[1:]
+
+		name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if eq := strings.Index(name, "="); eq > 0 {
+			name = name[:eq]
+		} else if len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+			args = args[1:]
+		}
+		names[name] = true
+	}
+	return names
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// writeConfigFile writes `content` to a configuration file in a temporary directory, and returns its path.
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	err := os.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatalf("writing the config file: %v", err)
+	}
+	return path
+}
+
+// describedField returns the field `name` of `fields`, as described by describeConfiguration.
+func describedField(t *testing.T, fields []configField, name string) configField {
+	t.Helper()
+
+	for _, f := range fields {
+		if f.name == name {
+			return f
+		}
+	}
+	t.Fatalf("no field %s in the description", name)
+	return configField{}
+}
+
+func TestConfigurationPrecedence(t *testing.T) {
+	tests := []struct {
+		name   string
+		field  string
+		env    map[string]string
+		flags  []string
+		file   string
+		value  string
+		source string
+	}{
+		{"default", "Log.Level", nil, nil, "", "info", "default"},
+		{"env over default", "Log.Level",
+			map[string]string{"CFG_LOG_LEVEL": "warn"}, nil, "", "warn", "env"},
+		{"flag over env", "Log.Level",
+			map[string]string{"CFG_LOG_LEVEL": "warn"}, []string{"--log-level=error"}, "", "error", "flag"},
+		{"flag with a separate value", "Log.Level",
+			nil, []string{"--log-level", "error"}, "", "error", "flag"},
+		{"file over flag and env", "Log.Level",
+			map[string]string{"CFG_LOG_LEVEL": "warn"}, []string{"--log-level=error"}, "log:\n  level: debug\n",
+			"debug", "file"},
+		{"file without the field", "Log.Level",
+			map[string]string{"CFG_LOG_LEVEL": "warn"}, nil, "log:\n  json: true\n", "warn", "env"},
+		{"nested section", "Web.TLS.ReloadInterval",
+			nil, []string{"--web-tls-reload-interval=5m"}, "", "5m0s", "flag"},
+		{"nested section in the file", "Web.TLS.ReloadInterval",
+			nil, nil, "web:\n  tls:\n    reloadinterval: 30s\n", "30s", "file"},
+		{"list", "CORS.AllowedOrigins",
+			map[string]string{"CFG_CORS_ALLOWED_ORIGINS": "https://a.example;https://b.example"}, nil, "",
+			"https://a.example;https://b.example", "env"},
+		{"empty flag", "RateLimit.Routes", nil, []string{"--rate-limit-routes="}, "", "", "flag"},
+		{"masked", "DB.DSN", map[string]string{"CFG_DB_DSN": "postgres://secret"}, nil, "", "xxxxxx", "env"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			path := filepath.Join(t.TempDir(), "missing.yml")
+			if tt.file != "" {
+				path = writeConfigFile(t, tt.file)
+			}
+			args := append([]string{"--config-path=" + path}, tt.flags...)
+
+			cfg, err := parseConfiguration(args)
+			if err != nil {
+				t.Fatalf("loading the configuration: %v", err)
+			}
+			fields, err := describeConfiguration(cfg, args)
+			if err != nil {
+				t.Fatalf("describing the configuration: %v", err)
+			}
+
+			f := describedField(t, fields, tt.field)
+			if f.value != tt.value || f.source != tt.source {
+				t.Errorf("%s is %q from %s, want %q from %s", tt.field, f.value, f.source, tt.value, tt.source)
+			}
+		})
+	}
+}
+
+func TestConfigurationSources(t *testing.T) {
+	// every field has a source, and only those set somewhere have another than default
+	t.Setenv("CFG_DEBUG", "true")
+	path := writeConfigFile(t, "log:\n  level: debug\n")
+	args := []string{"--config-path", path, "--web-api-host=127.0.0.1:3000"}
+
+	cfg, err := parseConfiguration(args)
+	if err != nil {
+		t.Fatalf("loading the configuration: %v", err)
+	}
+	fields, err := describeConfiguration(cfg, args)
+	if err != nil {
+		t.Fatalf("describing the configuration: %v", err)
+	}
+
+	want := map[string]string{"Debug": "env", "Config.Path": "flag", "Web.APIHost": "flag", "Log.Level": "file"}
+	for _, f := range fields {
+		source, ok := want[f.name]
+		if !ok {
+			source = "default"
+		}
+		if f.source != source {
+			t.Errorf("%s from %s, want %s", f.name, f.source, source)
+		}
+	}
+}
+
+func TestFlagNames(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"none", nil, nil},
+		{"with values", []string{"--log-level=debug", "--db-filename", "/tmp/x.db"}, []string{"db-filename", "log-level"}},
+		{"single dash", []string{"-debug", "-log-level=debug"}, []string{"debug", "log-level"}},
+		{"boolean before a flag", []string{"--debug", "--log-json"}, []string{"debug", "log-json"}},
+		{"empty value", []string{"--rate-limit-routes="}, []string{"rate-limit-routes"}},
+		{"up to the command", []string{"--debug=true", "config", "check", "--log-level=debug"}, []string{"debug"}},
+		{"up to --", []string{"--debug=true", "--", "--log-level=debug"}, []string{"debug"}},
+		{"value taken as such", []string{"--config-path", "check"}, []string{"config-path"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for name := range flagNames(tt.args) {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("flagNames(%q) = %v, want %v", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		// err is part of the error, empty if the document is fine
+		err string
+	}{
+		{"empty", "", ""},
+		{"known keys", "log:\n  level: debug\nweb:\n  tls:\n    certfile: cert.pem\n", ""},
+		{"duration with a unit", "web:\n  readtimeout: 5s\n", ""},
+		{"zero duration", "web:\n  hstsmaxage: 0\n", ""},
+		{"list", "cors:\n  allowedorigins: [https://a.example]\n", ""},
+		{"unknown section", "logs:\n  level: debug\n", "unknown key logs"},
+		{"unknown key", "log:\n  levle: debug\n", "unknown key log.levle"},
+		{"unknown nested key", "web:\n  tls:\n    cert: cert.pem\n", "unknown key web.tls.cert"},
+		{"duration without unit", "web:\n  readtimeout: 5\n", "web.readtimeout: duration 5 has no unit"},
+		{"nested duration without unit", "web:\n  tls:\n    reloadinterval: 60\n", "web.tls.reloadinterval"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc map[interface{}]interface{}
+			err := yaml.Unmarshal([]byte(tt.doc), &doc)
+			if err != nil {
+				t.Fatalf("parsing the document: %v", err)
+			}
+
+			err = checkYAML(doc, reflect.TypeOf(WebAPIConfiguration{}), "")
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && err == nil:
+				t.Errorf("no error, want %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Errorf("error %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestInvalidConfigurationFile(t *testing.T) {
+	path := writeConfigFile(t, "log:\n  levle: debug\n")
+
+	_, err := parseConfiguration([]string{"--config-path=" + path})
+	if err == nil || !strings.Contains(err.Error(), "log.levle") {
+		t.Errorf("loading a misspelled key: %v", err)
+	}
+}
+
+func TestDemoConfiguration(t *testing.T) {
+	cfg, err := parseConfiguration([]string{"--config-path=../../demo/config.yml"})
+	if err != nil {
+		t.Fatalf("loading demo/config.yml: %v", err)
+	}
+	if cfg.Log.Level != "debug" {
+		t.Errorf("Log.Level %q, demo/config.yml sets debug", cfg.Log.Level)
+	}
+
+	// the commented keys are the configuration too, with example values: `#key:` or `#  key:`, while the comments
+	// proper are `# Text`
+	commented, err := os.ReadFile("../../demo/config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(commented), "\n") {
+		if strings.HasPrefix(line, "#") && (!strings.HasPrefix(line, "# ") || strings.HasPrefix(line, "#  ")) {
+			line = line[1:]
+		}
+		lines = append(lines, line)
+	}
+	path := writeConfigFile(t, strings.Join(lines, "\n"))
+
+	_, err = parseConfiguration([]string{"--config-path=" + path})
+	if err != nil {
+		t.Errorf("loading demo/config.yml uncommented: %v", err)
+	}
+}
@@ -1,23 +1,57 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/gorilla/handlers"
 )
 
-// applyCORSHandler applies a CORS policy to the router. CORS stands for Cross-Origin Resource Sharing: it's a security
-// feature present in web browsers that blocks JavaScript requests going across different domains if not specified in a
-// policy. This function sends the policy of this API server.
-func applyCORSHandler(h http.Handler) http.Handler {
-	// Some of the allowed headers are legacy.
-	return handlers.CORS(
-		handlers.AllowedHeaders([]string{
-			"x-example-header",
-		}),
-		handlers.AllowedMethods([]string{"GET", "POST", "OPTIONS", "DELETE", "PUT"}),
-		handlers.AllowedOrigins([]string{"*"}),
-		handlers.ExposedHeaders([]string{"Authorization"}),
-		handlers.AllowedHeaders([]string{"Authorization", "Content-Type", "searcher_id",
-			"identifier", "requester_ID", "user_id", "user_name", "commenter_name"}))(h)
+// applyCORSHandler applies the CORS policy of the configuration to the router. CORS stands for Cross-Origin Resource
+// Sharing: it's a security feature present in web browsers that blocks JavaScript requests going across different
+// domains if not specified in a policy. This function sends the policy of this API server.
+//
+// Without allowed origins (CORS.AllowedOrigins is `none`, or empty in a build with the web UI, see webUIOrigins) no
+// policy is sent at all, so that browsers block every cross-origin request.
+func applyCORSHandler(h http.Handler, cfg WebAPIConfiguration) (http.Handler, error) {
+	origins := cfg.CORS.AllowedOrigins
+	if len(origins) == 0 {
+		origins = webUIOrigins
+	}
+
+	var allowed []string
+	for _, origin := range origins {
+		switch {
+		case origin == "" || origin == "none":
+			continue
+		case origin == "*":
+			if cfg.CORS.AllowCredentials {
+				return nil, fmt.Errorf("CORS origin * cannot be allowed together with credentials")
+			}
+		default:
+			u, err := url.Parse(origin)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+				return nil, fmt.Errorf("invalid CORS origin %q, it must be <scheme>://<host>[:<port>]", origin)
+			}
+		}
+		allowed = append(allowed, origin)
+	}
+
+	if len(allowed) == 0 {
+		return h, nil
+	}
+
+	options := []handlers.CORSOption{
+		handlers.AllowedOrigins(allowed),
+		handlers.AllowedMethods(cfg.CORS.AllowedMethods),
+		handlers.AllowedHeaders(cfg.CORS.AllowedHeaders),
+		handlers.ExposedHeaders(cfg.CORS.ExposedHeaders),
+		handlers.MaxAge(int(cfg.CORS.MaxAge.Seconds())),
+	}
+	if cfg.CORS.AllowCredentials {
+		options = append(options, handlers.AllowCredentials())
+	}
+
+	return handlers.CORS(options...)(h), nil
 }
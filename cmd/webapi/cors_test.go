@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ardanlabs/conf"
+)
+
+// defaultConfiguration returns the configuration with its default values only, whatever the environment.
+func defaultConfiguration(t *testing.T) WebAPIConfiguration {
+	t.Helper()
+
+	var cfg WebAPIConfiguration
+	err := conf.Parse(nil, "WEBAPI_TEST_UNSET", &cfg)
+	if err != nil {
+		t.Fatalf("parsing the defaults: %v", err)
+	}
+	return cfg
+}
+
+// preflight sends a preflight request from `origin` to the API behind the CORS policy of `cfg`.
+func preflight(t *testing.T, cfg WebAPIConfiguration, origin string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	h, err := applyCORSHandler(http.NotFoundHandler(), cfg)
+	if err != nil {
+		t.Fatalf("applying the CORS policy: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodOptions, "/users", nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", http.MethodPut)
+	r.Header.Set("Access-Control-Request-Headers", "Authorization")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCORSPreflight(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		origin  string
+		allowed bool
+	}{
+		{"default, Vite", nil, "http://localhost:5173", true},
+		{"default, frontend container", nil, "http://localhost:8080", true},
+		{"default, other origin", nil, "https://evil.example", false},
+		{"configured origin", []string{"https://wasa.example"}, "https://wasa.example", true},
+		{"not a configured origin", []string{"https://wasa.example"}, "http://localhost:5173", false},
+		{"any origin", []string{"*"}, "https://any.example", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfiguration(t)
+			cfg.CORS.AllowedOrigins = tt.origins
+
+			w := preflight(t, cfg, tt.origin)
+
+			allowOrigin := w.Header().Get("Access-Control-Allow-Origin")
+			if tt.allowed && allowOrigin != tt.origin && allowOrigin != "*" {
+				t.Errorf("origin %s not allowed (status %d, Access-Control-Allow-Origin %q)", tt.origin, w.Code, allowOrigin)
+			}
+			if !tt.allowed && allowOrigin != "" {
+				t.Errorf("origin %s allowed (Access-Control-Allow-Origin %q)", tt.origin, allowOrigin)
+			}
+		})
+	}
+}
+
+func TestCORSNoOrigins(t *testing.T) {
+	cfg := defaultConfiguration(t)
+	cfg.CORS.AllowedOrigins = []string{"none"}
+
+	w := preflight(t, cfg, "http://localhost:5173")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin %q with no allowed origins", got)
+	}
+}
+
+func TestCORSInvalidPolicies(t *testing.T) {
+	tests := []struct {
+		name        string
+		origins     []string
+		credentials bool
+	}{
+		{"any origin with credentials", []string{"*"}, true},
+		{"origin with a path", []string{"https://wasa.example/app"}, false},
+		{"origin without scheme", []string{"wasa.example"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfiguration(t)
+			cfg.CORS.AllowedOrigins = tt.origins
+			cfg.CORS.AllowCredentials = tt.credentials
+
+			_, err := applyCORSHandler(http.NotFoundHandler(), cfg)
+			if err == nil {
+				t.Error("policy accepted")
+			}
+		})
+	}
+}
+
+func TestCORSMaxAge(t *testing.T) {
+	tests := []struct {
+		maxAge time.Duration
+		want   string
+	}{
+		{5 * time.Minute, "300"},
+		{10 * time.Minute, "600"},
+		// browsers ignore more than 10 minutes, or cap it lower
+		{time.Hour, "600"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.maxAge.String(), func(t *testing.T) {
+			cfg := defaultConfiguration(t)
+			cfg.CORS.MaxAge = tt.maxAge
+
+			w := preflight(t, cfg, "http://localhost:5173")
+			if got := w.Header().Get("Access-Control-Max-Age"); got != tt.want {
+				t.Errorf("Access-Control-Max-Age %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
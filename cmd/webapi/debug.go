@@ -0,0 +1,45 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/metrics"
+	"github.com/ardanlabs/conf"
+	"github.com/sirupsen/logrus"
+)
+
+// debugHandler returns the handler of the debug server, which must not be reachable from the outside:
+//
+//	/debug/pprof/	the profiler (see net/http/pprof)
+//	/debug/vars	the expvar variables, i.e. the counters of the API ("api") and of the database ("database")
+//	/debug/config	the configuration in use, with the secrets (fields tagged `mask`) redacted
+//	/metrics	the metrics of requests, database, photo store and runtime, in the Prometheus format
+func debugHandler(config func() WebAPIConfiguration, logger *logrus.Logger) http.Handler {
+	mux := http.NewServeMux()
+
+	// Registered one by one, as net/http/pprof and expvar would register on http.DefaultServeMux
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	mux.HandleFunc("/debug/config", func(w http.ResponseWriter, r *http.Request) {
+		cfg := config()
+		dump, err := conf.String(&cfg)
+		if err != nil {
+			logger.WithError(err).Error("error dumping the configuration")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(dump + "\n"))
+	})
+
+	mux.Handle("/metrics", metrics.Handler())
+
+	return mux
+}
@@ -4,13 +4,24 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/api"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/ratelimit"
+
 	"github.com/ardanlabs/conf"
 	"gopkg.in/yaml.v2"
 )
 
+// envPrefix is the prefix of the environment variables of the configuration, e.g. CFG_LOG_LEVEL
+const envPrefix = "CFG"
+
 // WebAPIConfiguration describes the web API configuration. This structure is automatically parsed by
 // loadConfiguration and values from flags, environment variable or configuration file will be loaded.
 type WebAPIConfiguration struct {
@@ -23,11 +34,99 @@ type WebAPIConfiguration struct {
 		ReadTimeout     time.Duration `conf:"default:5s"`
 		WriteTimeout    time.Duration `conf:"default:5s"`
 		ShutdownTimeout time.Duration `conf:"default:5s"`
+		// TLS serves the API over HTTPS (and HTTP/2) when both CertFile and KeyFile (PEM) are set. They are loaded again
+		// on SIGHUP, and when they change (checked every ReloadInterval). RedirectHost, if set, is the address of a plain
+		// HTTP listener that redirects to HTTPS.
+		TLS struct {
+			CertFile       string
+			KeyFile        string
+			ReloadInterval time.Duration `conf:"default:1m"`
+			RedirectHost   string
+		}
+		// HSTSMaxAge is how long browsers must use HTTPS only, sent over TLS only, 0 to disable
+		HSTSMaxAge time.Duration `conf:"default:8760h"`
 	}
 	Debug bool
-	DB    struct {
-		Filename string `conf:"default:/tmp/decaf.db"`
+	Log   struct {
+		Level string `conf:"default:info"`
+		// JSON writes one JSON object per line, instead of text
+		JSON bool
+		// MethodName adds the function, file and line of the caller
+		MethodName bool `conf:"default:true"`
+		// Destination is stdout, stderr or file
+		Destination string `conf:"default:stdout"`
+		// File, MaxSize (in bytes), MaxAge and MaxBackups describe the log file and its rotation, if the destination
+		// is file. CombinedToStdout copies the log to stdout too.
+		File             string        `conf:"default:/tmp/webapi.log"`
+		MaxSize          int64         `conf:"default:104857600"`
+		MaxAge           time.Duration `conf:"default:168h"`
+		MaxBackups       int           `conf:"default:7"`
+		CombinedToStdout bool
+		// AccessLog writes a line for each request
+		AccessLog bool `conf:"default:true"`
+	}
+	CORS struct {
+		// AllowedOrigins are the origins (<scheme>://<host>[:<port>]) of the pages allowed to call the API, * for any,
+		// none for no one; if empty, those of the web UI (see webUIOrigins)
+		AllowedOrigins   []string
+		AllowedMethods   []string `conf:"default:GET;POST;PUT;PATCH;DELETE"`
+		AllowedHeaders   []string `conf:"default:Authorization;Content-Type;user_name;commenter_name;X-Request-ID;traceparent"`
+		ExposedHeaders   []string `conf:"default:X-Request-ID;Retry-After;Content-Disposition"`
+		AllowCredentials bool
+		// MaxAge is how long browsers may cache a preflight response, at most 10 minutes
+		MaxAge time.Duration `conf:"default:10m"`
+	}
+	RateLimit struct {
+		// Default is the budget (<requests>/<period>) of each client on every route not in Routes, none to disable
+		Default string `conf:"default:300/1m"`
+		// Routes are budgets of specific routes, as <method> <pattern>=<requests>/<period>. The images have their own,
+		// as a page of the stream loads many at once; /liveness and /readiness are never limited.
+		Routes []string `conf:"default:GET /resources/photos/:UUID=1200/1m;PUT /session=10/1m;PUT /users/:user_name/profile/photos/:photo_id=30/1h;PUT /users/:user_name/profile/photos/:photo_id/comments/:comment_id=30/1m"`
+		// TrustedProxies are the addresses or networks (CIDR) of the reverse proxies, whose X-Forwarded-For is honoured
+		TrustedProxies []string
+	}
+	Tracing struct {
+		// Exporter is where the spans go: none, or stdout (as JSON lines, for local testing)
+		Exporter string `conf:"default:none"`
+	}
+	DB struct {
+		Driver       string        `conf:"default:sqlite3"`
+		Filename     string        `conf:"default:/tmp/decaf.db"`
+		DSN          string        `conf:"mask"`
+		ReadTimeout  time.Duration `conf:"default:3s"`
+		WriteTimeout time.Duration `conf:"default:4s"`
+		JournalMode  string        `conf:"default:WAL"`
+		Synchronous  string        `conf:"default:NORMAL"`
+		BusyTimeout  time.Duration `conf:"default:5s"`
+		CacheSize    int           `conf:"default:-16000"`
+		Readers      int           `conf:"default:4"`
 	}
+	Janitor struct {
+		DeletedRetention time.Duration `conf:"default:720h"`
+		AccountRetention time.Duration `conf:"default:336h"`
+		Interval         time.Duration `conf:"default:1h"`
+	}
+	Export struct {
+		Retention time.Duration `conf:"default:72h"`
+	}
+	Readiness struct {
+		MinFreeSpace uint64 `conf:"default:104857600"`
+	}
+	Backup struct {
+		Dir      string
+		Interval time.Duration `conf:"default:24h"`
+		Keep     int           `conf:"default:7"`
+	}
+	Seed struct {
+		Users      int   `conf:"default:100"`
+		Photos     int   `conf:"default:3"`
+		Follows    int   `conf:"default:10"`
+		Comments   int   `conf:"default:2"`
+		Likes      int   `conf:"default:5"`
+		RandomSeed int64 `conf:"default:1"`
+	}
+	// Args holds the command (e.g., `backup`) and its arguments
+	Args conf.Args
 }
 
 // loadConfiguration creates a WebAPIConfiguration starting from flags, environment variables and configuration file.
@@ -36,12 +135,17 @@ type WebAPIConfiguration struct {
 // So, CLI parameters will override the environment, and configuration file will override everything.
 // Note that the configuration file can be specified only via CLI or environment variable.
 func loadConfiguration() (WebAPIConfiguration, error) {
+	return parseConfiguration(os.Args[1:])
+}
+
+// parseConfiguration is loadConfiguration, with the command line arguments (without the program name) in `args`.
+func parseConfiguration(args []string) (WebAPIConfiguration, error) {
 	var cfg WebAPIConfiguration
 
 	// Try to load configuration from environment variables and command line switches
-	if err := conf.Parse(os.Args[1:], "CFG", &cfg); err != nil {
+	if err := conf.Parse(args, envPrefix, &cfg); err != nil {
 		if errors.Is(err, conf.ErrHelpWanted) {
-			usage, err := conf.Usage("CFG", &cfg)
+			usage, err := conf.Usage(envPrefix, &cfg)
 			if err != nil {
 				return cfg, fmt.Errorf("generating config usage: %w", err)
 			}
@@ -56,16 +160,128 @@ func loadConfiguration() (WebAPIConfiguration, error) {
 	if err != nil && !os.IsNotExist(err) {
 		return cfg, fmt.Errorf("can't read the config file, while it exists: %w", err)
 	} else if err == nil {
+		defer func() {
+			_ = fp.Close()
+		}()
+
 		yamlFile, err := io.ReadAll(fp)
 		if err != nil {
 			return cfg, fmt.Errorf("can't read config file: %w", err)
 		}
-		err = yaml.Unmarshal(yamlFile, &cfg)
+		// Unknown (e.g., misspelled) and repeated keys are errors, rather than silently ignored
+		var doc map[interface{}]interface{}
+		err = yaml.Unmarshal(yamlFile, &doc)
+		if err != nil {
+			return cfg, fmt.Errorf("can't unmarshal config file: %w", err)
+		}
+		err = checkYAML(doc, reflect.TypeOf(cfg), "")
+		if err != nil {
+			return cfg, fmt.Errorf("invalid config file: %w", err)
+		}
+		err = yaml.UnmarshalStrict(yamlFile, &cfg)
 		if err != nil {
 			return cfg, fmt.Errorf("can't unmarshal config file: %w", err)
 		}
-		_ = fp.Close()
 	}
 
 	return cfg, nil
 }
+
+// checkYAML checks `node`, the YAML document of a struct of type `t`, for the mistakes that the YAML decoder would let
+// through, or report in terms of Go types: unknown keys, and durations written as bare numbers, which it takes as
+// nanoseconds (zero is fine).
+func checkYAML(node map[interface{}]interface{}, t reflect.Type, prefix string) error {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		fields[strings.ToLower(t.Field(i).Name)] = t.Field(i)
+	}
+
+	for key, value := range node {
+		field, found := fields[fmt.Sprint(key)]
+		if !found {
+			return fmt.Errorf("unknown key %s%v", prefix, key)
+		}
+
+		switch {
+		case field.Type == reflect.TypeOf(time.Duration(0)):
+			if _, isString := value.(string); !isString && value != 0 {
+				return fmt.Errorf("%s%v: duration %v has no unit (e.g., 5s)", prefix, key, value)
+			}
+		case field.Type.Kind() == reflect.Struct:
+			if section, ok := value.(map[interface{}]interface{}); ok {
+				err := checkYAML(section, field.Type, fmt.Sprintf("%s%v.", prefix, key))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sqliteDSN returns the data source name of the SQLite database in DB.Filename, tuned as configured in DB (see the
+// `mattn/go-sqlite3` documentation for the parameters). Foreign keys are always enforced, on every connection.
+// `readOnly` is for the pool of readers: it opens the file read-only, and leaves the journal mode alone (changing it
+// needs a write); the writer begins its transactions as IMMEDIATE, taking the write lock upfront.
+func (cfg WebAPIConfiguration) sqliteDSN(readOnly bool) string {
+	params := url.Values{}
+	params.Set("_foreign_keys", "1")
+	params.Set("_busy_timeout", strconv.FormatInt(cfg.DB.BusyTimeout.Milliseconds(), 10))
+	params.Set("_synchronous", cfg.DB.Synchronous)
+	params.Set("_cache_size", strconv.Itoa(cfg.DB.CacheSize))
+
+	if readOnly {
+		params.Set("mode", "ro")
+	} else {
+		params.Set("_journal_mode", cfg.DB.JournalMode)
+		params.Set("_txlock", "immediate")
+	}
+
+	return "file:" + cfg.DB.Filename + "?" + params.Encode()
+}
+
+// rateLimits returns the budgets in RateLimit, parsed.
+func (cfg WebAPIConfiguration) rateLimits() (api.RateLimits, error) {
+	limits := api.RateLimits{Routes: map[string]ratelimit.Budget{}}
+
+	if cfg.RateLimit.Default != "none" {
+		budget, err := ratelimit.ParseBudget(cfg.RateLimit.Default)
+		if err != nil {
+			return limits, err
+		}
+		limits.Default = budget
+	}
+
+	for _, route := range cfg.RateLimit.Routes {
+		if strings.TrimSpace(route) == "" {
+			continue
+		}
+		eq := strings.LastIndex(route, "=")
+		if eq < 0 || len(strings.Fields(route[:eq])) != 2 {
+			return limits, fmt.Errorf("invalid route budget %q, it must be <method> <pattern>=<requests>/<period>", route)
+		}
+		budget, err := ratelimit.ParseBudget(route[eq+1:])
+		if err != nil {
+			return limits, err
+		}
+		limits.Routes[strings.Join(strings.Fields(route[:eq]), " ")] = budget
+	}
+
+	for _, proxy := range cfg.RateLimit.TrustedProxies {
+		if !strings.Contains(proxy, "/") {
+			// a single address
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return limits, fmt.Errorf("invalid trusted proxy: %w", err)
+		}
+		limits.TrustedProxies = append(limits.TrustedProxies, network)
+	}
+
+	return limits, nil
+}
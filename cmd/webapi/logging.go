@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/logfile"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+// newLogger returns the logger configured in Log, and the file it writes to (nil if none), to be closed on exit. The
+// standard logger of logrus, used by the packages that have no logger of their own, is configured the same way.
+func newLogger(cfg WebAPIConfiguration) (*logrus.Logger, *logfile.File, error) {
+	level, err := logLevel(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var out io.Writer
+	var file *logfile.File
+	switch cfg.Log.Destination {
+	case "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	case "file":
+		file, err = logfile.Open(logfile.Config{
+			Path:       cfg.Log.File,
+			MaxSize:    cfg.Log.MaxSize,
+			MaxAge:     cfg.Log.MaxAge,
+			MaxBackups: cfg.Log.MaxBackups,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		out = file
+		if cfg.Log.CombinedToStdout {
+			out = io.MultiWriter(file, os.Stdout)
+		}
+	default:
+		return nil, nil, fmt.Errorf("invalid log destination %q (stdout, stderr or file)", cfg.Log.Destination)
+	}
+
+	var formatter logrus.Formatter = &logrus.TextFormatter{}
+	if cfg.Log.JSON {
+		formatter = &logrus.JSONFormatter{}
+	}
+
+	logger := logrus.New()
+	for _, l := range []*logrus.Logger{logger, logrus.StandardLogger()} {
+		l.SetOutput(out)
+		l.SetFormatter(formatter)
+		l.SetLevel(level)
+		l.SetReportCaller(cfg.Log.MethodName) // add function, file and line number to log
+	}
+
+	return logger, file, nil
+}
+
+// logLevel returns the level in Log.Level, or debug if Debug is set.
+func logLevel(cfg WebAPIConfiguration) (logrus.Level, error) {
+	if cfg.Debug {
+		return logrus.DebugLevel, nil
+	}
+	level, err := logrus.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		return level, fmt.Errorf("invalid log level: %w", err)
+	}
+	return level, nil
+}
+
+// newTracer returns the tracer of the exporter in Tracing.Exporter.
+func newTracer(cfg WebAPIConfiguration) (tracing.Tracer, error) {
+	switch cfg.Tracing.Exporter {
+	case "none":
+		return nil, nil
+	case "stdout":
+		return tracing.NewWriterTracer(os.Stdout), nil
+	default:
+		return nil, fmt.Errorf("invalid tracing exporter %q (none or stdout)", cfg.Tracing.Exporter)
+	}
+}
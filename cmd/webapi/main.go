@@ -2,14 +2,36 @@
 Webapi is the executable for the main web server.
 It builds a web server around APIs from `service/api`.
 Webapi connects to external resources needed (database) and starts two web servers: the API web server, and the debug.
-Everything is served via the API web server, except debug variables (/debug/vars) and profiler infos (pprof).
+Everything is served via the API web server, except debug variables (/debug/vars), profiler infos (pprof), the
+configuration in use (/debug/config) and the metrics in the Prometheus format (/metrics), served by the debug server on Web.DebugHost (empty to disable it).
 
 Usage:
 
 	webapi [flags]
+	webapi [flags] backup <archive>
+	webapi [flags] restore <archive>
+	webapi [flags] seed [description]
+	webapi [flags] config check
 
 Flags and configurations are handled automatically by the code in `load-configuration.go`.
 
+The `backup` command writes the SQLite database and the photos to a tar archive, and can run alongside the server (which
+can also take backups by itself, see the Backup configuration). The `restore` command replaces database and photos with
+those of an archive, once verified, and must run while the server is stopped.
+
+The API server serves HTTPS (with HTTP/2) when Web.TLS has a certificate and a key: they are reloaded on SIGHUP, and
+when their files change. Web.TLS.RedirectHost optionally listens for plain HTTP, redirecting to HTTPS.
+
+On SIGHUP, the configuration is loaded again: the log level, the access log, the rate limits, the CORS policy and the
+tracing exporter are applied, while the changes to the other fields are reported in the log, as they need a restart.
+
+The `seed` command fills an empty database with users, follows, photos, comments and likes, read from a JSON or YAML
+description or, without one, generated as set in the Seed configuration (see `service/seed`).
+
+The configuration is validated before anything starts: unknown keys in the configuration file are errors, as are
+invalid addresses, durations and values. The `config check` command validates it, and prints every field with its
+value and where it comes from (default, env, flag or file).
+
 Return values (exit codes):
 
 	0
@@ -37,9 +59,10 @@ import (
 	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/api"
 	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
 	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/globaltime"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/tracing"
 	"github.com/ardanlabs/conf"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/sirupsen/logrus"
 )
 
 // main is the program entry point. The only purpose of this function is to call run() and set the exit code if there is
@@ -70,35 +93,115 @@ func run() error {
 		return err
 	}
 
+	// The config command checks the configuration, and reports on it, valid or not
+	if cfg.Args.Num(0) == "config" {
+		return runConfig(cfg)
+	}
+	err = cfg.validate()
+	if err != nil {
+		return err
+	}
+
 	// Init logging
-	logger := logrus.New()
-	logger.SetOutput(os.Stdout)
-	logger.SetReportCaller(true) // add file and line number to log
-	if cfg.Debug {
-		logger.SetLevel(logrus.DebugLevel)
-	} else {
-		logger.SetLevel(logrus.InfoLevel)
+	logger, logFile, err := newLogger(cfg)
+	if err != nil {
+		return fmt.Errorf("configuring the logger: %w", err)
+	}
+	if logFile != nil {
+		defer func() {
+			_ = logFile.Close()
+		}()
+	}
+
+	// Init tracing
+	tracer, err := newTracer(cfg)
+	if err != nil {
+		return err
+	}
+	tracing.SetTracer(tracer)
+
+	switch cfg.Args.Num(0) {
+	case "", "seed":
+	case "backup":
+		return runBackup(cfg, logger)
+	case "restore":
+		return runRestore(cfg, logger)
+	default:
+		return fmt.Errorf("unknown command %q", cfg.Args.Num(0))
 	}
 
 	logger.Infof("application initializing")
 
 	// Start Database
 	logger.Println("initializing database support")
-	dbconn, err := sql.Open("sqlite3", cfg.DB.Filename)
+	dialect := database.Dialect(cfg.DB.Driver)
+	if !dialect.Valid() {
+		return fmt.Errorf("unsupported database driver %q", cfg.DB.Driver)
+	}
+	dsn := cfg.DB.DSN
+	if dialect == database.SQLite {
+		dsn = cfg.sqliteDSN(false)
+	}
+	dbconn, err := sql.Open(cfg.DB.Driver, dsn)
 	if err != nil {
-		logger.WithError(err).Error("error opening SQLite DB")
-		return fmt.Errorf("opening SQLite: %w", err)
+		logger.WithError(err).Error("error opening DB")
+		return fmt.Errorf("opening %s: %w", cfg.DB.Driver, err)
 	}
 	defer func() {
 		logger.Debug("database stopping")
 		_ = dbconn.Close()
 	}()
-	db, err := database.New(dbconn)
+	// SQLite allows a single writer at a time: writes queue up on one connection, reads run on a separate pool of
+	// read-only connections, which WAL mode lets proceed alongside the writer
+	var readers *sql.DB
+	if dialect == database.SQLite {
+		dbconn.SetMaxOpenConns(1)
+		readers, err = sql.Open(cfg.DB.Driver, cfg.sqliteDSN(true))
+		if err != nil {
+			logger.WithError(err).Error("error opening DB readers")
+			return fmt.Errorf("opening %s readers: %w", cfg.DB.Driver, err)
+		}
+		readers.SetMaxOpenConns(cfg.DB.Readers)
+		defer func() {
+			_ = readers.Close()
+		}()
+	}
+	db, err := database.New(database.Config{
+		DB:      dbconn,
+		Readers: readers,
+		Dialect: dialect,
+		Timeouts: database.Timeouts{
+			Read:  cfg.DB.ReadTimeout,
+			Write: cfg.DB.WriteTimeout,
+		},
+	})
 	if err != nil {
 		logger.WithError(err).Error("error creating AppDatabase")
 		return fmt.Errorf("creating AppDatabase: %w", err)
 	}
 
+	// Trace and record the duration of every operation, see /metrics on the debug server
+	db = database.Instrument(db)
+
+	// The seed command needs the database, migrated, but not the servers
+	if cfg.Args.Num(0) == "seed" {
+		return runSeed(cfg, logger, db)
+	}
+
+	// Start the scheduled backups, if enabled
+	if cfg.Backup.Dir != "" {
+		backupCtx, stopBackups := context.WithCancel(context.Background())
+		backupsDone := make(chan struct{})
+		go func() {
+			scheduleBackups(backupCtx, logger, readers, cfg)
+			close(backupsDone)
+		}()
+		defer func() {
+			stopBackups()
+			<-backupsDone
+		}()
+	}
+
 	// Start (main) API server
 	logger.Info("initializing API server")
 
@@ -107,14 +210,30 @@ func run() error {
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
-	// Make a channel to listen for errors coming from the listener. Use a
-	// buffered channel so the goroutine can exit if we don't collect this error.
-	serverErrors := make(chan error, 1)
+	// SIGHUP reloads what can be changed without a restart (see reloader)
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	// Make a channel to listen for errors coming from the listeners (API, HTTPS redirect and debug servers). Use a
+	// buffered channel, with room for all of them, so the goroutines can exit if we don't collect their errors.
+	serverErrors := make(chan error, 3)
+
+	rateLimits, err := cfg.rateLimits()
+	if err != nil {
+		return fmt.Errorf("parsing the rate limits: %w", err)
+	}
 
 	// Create the API router
 	apirouter, err := api.New(api.Config{
-		Logger:   logger,
-		Database: db,
+		Logger:           logger,
+		Database:         db,
+		DeletedRetention: cfg.Janitor.DeletedRetention,
+		JanitorInterval:  cfg.Janitor.Interval,
+		AccountRetention: cfg.Janitor.AccountRetention,
+		ExportRetention:  cfg.Export.Retention,
+		MinFreeSpace:     cfg.Readiness.MinFreeSpace,
+		AccessLog:        cfg.Log.AccessLog,
+		RateLimits:       rateLimits,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
@@ -128,8 +247,23 @@ func run() error {
 		return fmt.Errorf("registering web UI handler: %w", err)
 	}
 
-	// Apply CORS policy
-	router = applyCORSHandler(router)
+	// Apply CORS policy, replaced when the configuration is reloaded
+	corsHandler, err := applyCORSHandler(router, cfg)
+	if err != nil {
+		logger.WithError(err).Error("error applying the CORS policy")
+		return fmt.Errorf("applying the CORS policy: %w", err)
+	}
+	reloads := &reloader{
+		logger:    logger,
+		apirouter: apirouter,
+		cors:      newSwapHandler(corsHandler),
+		uncors:    router,
+		cfg:       cfg,
+	}
+	router = reloads.cors
+
+	// Add the security headers
+	router = applySecurityHeaders(router, cfg)
 
 	// Create the API server
 	apiserver := http.Server{
@@ -140,47 +274,126 @@ func run() error {
 		WriteTimeout:      cfg.Web.WriteTimeout,
 	}
 
+	// Serve over TLS, if configured: the certificate is reloaded on SIGHUP, or when its files change
+	var certs *certReloader
+	if cfg.Web.TLS.CertFile != "" || cfg.Web.TLS.KeyFile != "" {
+		if cfg.Web.TLS.CertFile == "" || cfg.Web.TLS.KeyFile == "" {
+			return errors.New("TLS needs both a certificate and a key file")
+		}
+		certs, err = newCertReloader(cfg.Web.TLS.CertFile, cfg.Web.TLS.KeyFile, logger)
+		if err != nil {
+			logger.WithError(err).Error("error loading the TLS certificate")
+			return err
+		}
+		apiserver.TLSConfig = certs.tlsConfig()
+		reloads.certs = certs
+
+		if cfg.Web.TLS.ReloadInterval > 0 {
+			watchCtx, stopWatching := context.WithCancel(context.Background())
+			defer stopWatching()
+			go certs.watch(watchCtx, cfg.Web.TLS.ReloadInterval)
+		}
+	}
+
 	// Start the service listening for requests in a separate goroutine
 	go func() {
-		logger.Infof("API listening on %s", apiserver.Addr)
-		serverErrors <- apiserver.ListenAndServe()
+		if certs != nil {
+			logger.Infof("API listening on %s (TLS)", apiserver.Addr)
+			// The certificate comes from TLSConfig. HTTP/2 is enabled along with TLS.
+			serverErrors <- apiserver.ListenAndServeTLS("", "")
+		} else {
+			logger.Infof("API listening on %s", apiserver.Addr)
+			serverErrors <- apiserver.ListenAndServe()
+		}
 		logger.Infof("stopping API server")
 	}()
 
+	// Start the HTTP to HTTPS redirect server, if enabled
+	var redirectserver *http.Server
+	if cfg.Web.TLS.RedirectHost != "" {
+		if certs == nil {
+			return errors.New("the HTTPS redirect needs TLS to be configured")
+		}
+		redirectserver = &http.Server{
+			Addr:              cfg.Web.TLS.RedirectHost,
+			Handler:           redirectHandler(cfg.Web.APIHost),
+			ReadHeaderTimeout: cfg.Web.ReadTimeout,
+		}
+		go func() {
+			logger.Infof("HTTPS redirect listening on %s", redirectserver.Addr)
+			serverErrors <- redirectserver.ListenAndServe()
+			logger.Infof("stopping HTTPS redirect server")
+		}()
+	}
+
+	// Start the debug server, if enabled, on its own address: unlike the API, it must stay private
+	var debugserver *http.Server
+	if cfg.Web.DebugHost != "" {
+		debugserver = &http.Server{
+			Addr:              cfg.Web.DebugHost,
+			Handler:           debugHandler(reloads.config, logger),
+			ReadHeaderTimeout: cfg.Web.ReadTimeout,
+		}
+		go func() {
+			logger.Infof("debug server listening on %s", debugserver.Addr)
+			serverErrors <- debugserver.ListenAndServe()
+			logger.Infof("stopping debug server")
+		}()
+	}
+
 	// Waiting for shutdown signal or POSIX signals
-	select {
-	case err := <-serverErrors:
-		// Non-recoverable server error
-		return fmt.Errorf("server error: %w", err)
+	for {
+		select {
+		case <-hangup:
+			logger.Info("SIGHUP received, reloading the configuration")
+			reloads.reload()
 
-	case sig := <-shutdown:
-		logger.Infof("signal %v received, start shutdown", sig)
+		case err := <-serverErrors:
+			// Non-recoverable server error
+			return fmt.Errorf("server error: %w", err)
 
-		// Asking API server to shut down and load shed.
-		err := apirouter.Close()
-		if err != nil {
-			logger.WithError(err).Warning("graceful shutdown of apirouter error")
-		}
+		case sig := <-shutdown:
+			logger.Infof("signal %v received, start shutdown", sig)
 
-		// Give outstanding requests a deadline for completion.
-		ctx, cancel := context.WithTimeout(context.Background(), cfg.Web.ShutdownTimeout)
-		defer cancel()
+			// Asking API server to shut down and load shed.
+			err := apirouter.Close()
+			if err != nil {
+				logger.WithError(err).Warning("graceful shutdown of apirouter error")
+			}
 
-		// Asking listener to shut down and load shed.
-		err = apiserver.Shutdown(ctx)
-		if err != nil {
-			logger.WithError(err).Warning("error during graceful shutdown of HTTP server")
-			err = apiserver.Close()
-		}
+			// Give outstanding requests a deadline for completion.
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Web.ShutdownTimeout)
+			defer cancel()
+
+			// Asking listener to shut down and load shed.
+			err = apiserver.Shutdown(ctx)
+			if err != nil {
+				logger.WithError(err).Warning("error during graceful shutdown of HTTP server")
+				err = apiserver.Close()
+			}
+
+			// The debug and redirect servers have no requests worth waiting for (e.g., a profile), so they are simply
+			// closed
+			if debugserver != nil {
+				_ = debugserver.Close()
+			}
+			if redirectserver != nil {
+				_ = redirectserver.Close()
+			}
 
-		// Log the status of this shutdown.
-		switch {
-		case sig == syscall.Signal(0x13):
-			return errors.New("integrity issue caused shutdown")
-		case err != nil:
-			return fmt.Errorf("could not stop server gracefully: %w", err)
+			// The requests are over, so are their spans
+			if e := tracing.Shutdown(ctx, tracing.SetTracer(nil)); e != nil {
+				logger.WithError(e).Warning("error shutting down the tracer")
+			}
+
+			// Log the status of this shutdown.
+			switch {
+			case sig == syscall.Signal(0x13):
+				return errors.New("integrity issue caused shutdown")
+			case err != nil:
+				return fmt.Errorf("could not stop server gracefully: %w", err)
+			}
+			return nil
 		}
 	}
-
-	return nil
 }
@@ -6,6 +6,10 @@ import (
 	"net/http"
 )
 
+// webUIOrigins are the origins allowed by default to call the API: without the bundled web UI, it is served by the
+// development server of Vite (`npm run dev`, or `npm run preview`), or by the frontend container (startfrontend.sh).
+var webUIOrigins = []string{"http://localhost:5173", "http://localhost:4173", "http://localhost:8080"}
+
 // registerWebUI is an empty stub because `webui` tag has not been specified.
 func registerWebUI(hdl http.Handler) (http.Handler, error) {
 	return hdl, nil
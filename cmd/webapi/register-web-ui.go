@@ -10,6 +10,10 @@ import (
 	"strings"
 )
 
+// webUIOrigins are the origins allowed by default to call the API: none, as the bundled web UI is served by the API
+// server itself, on its same origin.
+var webUIOrigins []string
+
 func registerWebUI(hdl http.Handler) (http.Handler, error) {
 	distDirectory, err := fs.Sub(webui.Dist, "dist")
 	if err != nil {
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/api"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+// reloadableFields are the fields of the configuration (or whole sections) applied on SIGHUP. Any other change needs a
+// restart.
+var reloadableFields = []string{"Debug", "Log.Level", "Log.AccessLog", "RateLimit", "CORS", "Tracing"}
+
+// reloader applies the configuration again on SIGHUP: it is loaded as at startup (so the file at Config.Path is read
+// again), validated, and its reloadable fields (see reloadableFields) replace those in use. If anything is invalid,
+// nothing changes. The TLS certificate, if any, is reloaded too.
+type reloader struct {
+	logger    *logrus.Logger
+	apirouter api.Router
+	certs     *certReloader
+
+	// cors serves the requests through the CORS policy in use, wrapping the rest of the chain, uncors
+	cors   *swapHandler
+	uncors http.Handler
+
+	mu  sync.RWMutex
+	cfg WebAPIConfiguration
+}
+
+// config returns the configuration in use.
+func (rl *reloader) config() WebAPIConfiguration {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	return rl.cfg
+}
+
+// reload loads the configuration, and applies it.
+func (rl *reloader) reload() {
+	if rl.certs != nil {
+		err := rl.certs.reload()
+		if err != nil {
+			rl.logger.WithError(err).Warning("error reloading the TLS certificate, keeping the previous one")
+		} else {
+			rl.logger.Info("TLS certificate reloaded")
+		}
+	}
+
+	next, err := loadConfiguration()
+	if err != nil {
+		rl.logger.WithError(err).Error("error reloading the configuration, keeping the previous one")
+		return
+	}
+
+	err = rl.apply(next)
+	if err != nil {
+		rl.logger.WithError(err).Error("invalid configuration, keeping the previous one")
+	}
+}
+
+// apply validates `next`, and replaces the reloadable fields in use with those of `next`. The changes to the other
+// fields are logged, as they need a restart.
+func (rl *reloader) apply(next WebAPIConfiguration) error {
+	err := next.validate()
+	if err != nil {
+		return err
+	}
+
+	// Everything is built before anything is replaced, so that an invalid configuration changes nothing
+	level, err := logLevel(next)
+	if err != nil {
+		return err
+	}
+	rateLimits, err := next.rateLimits()
+	if err != nil {
+		return fmt.Errorf("parsing the rate limits: %w", err)
+	}
+	corsHandler, err := applyCORSHandler(rl.uncors, next)
+	if err != nil {
+		return fmt.Errorf("applying the CORS policy: %w", err)
+	}
+	tracer, err := newTracer(next)
+	if err != nil {
+		return err
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	var applied, pending []string
+	for _, field := range changedFields(rl.cfg, next) {
+		if isReloadable(field) {
+			applied = append(applied, field)
+		} else {
+			pending = append(pending, field)
+		}
+	}
+
+	rl.logger.SetLevel(level)
+	logrus.SetLevel(level)
+	rl.apirouter.Reconfigure(api.Settings{AccessLog: next.Log.AccessLog, RateLimits: rateLimits})
+	rl.cors.swap(corsHandler)
+	if !reflect.DeepEqual(rl.cfg.Tracing, next.Tracing) {
+		tracer = tracing.SetTracer(tracer)
+	}
+	// tracer is now the one out of use: the previous one, or the new one if the tracing did not change. Its spans still
+	// running are dropped.
+	ctx, cancel := context.WithTimeout(context.Background(), rl.cfg.Web.ShutdownTimeout)
+	defer cancel()
+	err = tracing.Shutdown(ctx, tracer)
+	if err != nil {
+		rl.logger.WithError(err).Warning("error shutting down the previous tracer")
+	}
+
+	// The other fields keep their values, as they are still in use, and are reported again until the restart
+	rl.cfg.Debug = next.Debug
+	rl.cfg.Log.Level = next.Log.Level
+	rl.cfg.Log.AccessLog = next.Log.AccessLog
+	rl.cfg.RateLimit = next.RateLimit
+	rl.cfg.CORS = next.CORS
+	rl.cfg.Tracing = next.Tracing
+
+	logger := rl.logger.WithField("applied", strings.Join(applied, ","))
+	if len(pending) > 0 {
+		logger.WithField("restart-required", strings.Join(pending, ",")).
+			Warning("configuration reloaded, some changes need a restart")
+	} else {
+		logger.Info("configuration reloaded")
+	}
+	return nil
+}
+
+func isReloadable(field string) bool {
+	for _, r := range reloadableFields {
+		if field == r || strings.HasPrefix(field, r+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// changedFields returns the names of the fields of `a` and `b` (configurations) whose values differ, as
+// `Section.Field`. The command arguments (Args) are not compared.
+func changedFields(a, b WebAPIConfiguration) []string {
+	return appendChanged(nil, "", reflect.ValueOf(a), reflect.ValueOf(b))
+}
+
+func appendChanged(changed []string, prefix string, a, b reflect.Value) []string {
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+		name := prefix + field.Name
+		if name == "Args" {
+			continue
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			changed = appendChanged(changed, name+".", a.Field(i), b.Field(i))
+		} else if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// swapHandler serves the requests with an http.Handler that can be replaced while serving
+type swapHandler struct {
+	current atomic.Value
+}
+
+// handlerBox lets atomic.Value hold handlers of different types
+type handlerBox struct {
+	http.Handler
+}
+
+func newSwapHandler(h http.Handler) *swapHandler {
+	sh := &swapHandler{}
+	sh.swap(h)
+	return sh
+}
+
+// swap replaces the handler of the requests that come next.
+func (sh *swapHandler) swap(h http.Handler) {
+	sh.current.Store(handlerBox{h})
+}
+
+func (sh *swapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sh.current.Load().(handlerBox).ServeHTTP(w, r)
+}
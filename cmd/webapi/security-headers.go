@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// webUIContentSecurityPolicy is the Content-Security-Policy of the pages of the web UI: everything comes from the API
+// server itself, except the icons of bootstrap-icons (jsDelivr), and the photos, shown as data URLs. Vue sets inline
+// styles, so they are allowed.
+const webUIContentSecurityPolicy = "default-src 'self'; " +
+	"img-src 'self' data: blob:; " +
+	"style-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net; " +
+	"font-src 'self' https://cdn.jsdelivr.net; " +
+	"object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// applySecurityHeaders adds the security headers to every response: X-Content-Type-Options, so that browsers trust the
+// Content-Type (e.g., of the photos); Strict-Transport-Security, over TLS, unless Web.HSTSMaxAge is 0; and the
+// Content-Security-Policy of the web UI, to its pages (under /dashboard/).
+func applySecurityHeaders(h http.Handler, cfg WebAPIConfiguration) http.Handler {
+	var hsts string
+	if cfg.Web.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.FormatInt(int64(cfg.Web.HSTSMaxAge.Seconds()), 10) + "; includeSubDomains"
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "no-referrer")
+		if r.TLS != nil && hsts != "" {
+			header.Set("Strict-Transport-Security", hsts)
+		}
+		if strings.HasPrefix(r.URL.Path, "/dashboard/") {
+			header.Set("Content-Security-Policy", webUIContentSecurityPolicy)
+			header.Set("X-Frame-Options", "DENY")
+		}
+		h.ServeHTTP(w, r)
+	})
+}
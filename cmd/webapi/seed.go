@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/seed"
+	"github.com/sirupsen/logrus"
+)
+
+// runSeed implements `webapi seed [description]`: it imports the dataset in the file `description` or, without one,
+// a dataset generated from the Seed configuration.
+func runSeed(cfg WebAPIConfiguration, logger *logrus.Logger, db database.AppDatabase) error {
+	var ds seed.Dataset
+	var err error
+	if path := cfg.Args.Num(1); path != "" {
+		ds, err = seed.Load(path)
+	} else {
+		logger.Infof("generating %d users with seed %d", cfg.Seed.Users, cfg.Seed.RandomSeed)
+		ds, err = seed.Generate(seed.Options{
+			Seed:     cfg.Seed.RandomSeed,
+			Users:    cfg.Seed.Users,
+			Photos:   cfg.Seed.Photos,
+			Follows:  cfg.Seed.Follows,
+			Comments: cfg.Seed.Comments,
+			Likes:    cfg.Seed.Likes,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	stats, err := seed.Import(context.Background(), db, ds, logger)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("seeded %d users, %d photos, %d follows, %d likes and %d comments",
+		stats.Users, stats.Photos, stats.Follows, stats.Likes, stats.Comments)
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// certReloader holds the TLS certificate of the API server, and loads it again from its files on request (e.g., on
+// SIGHUP) or when they change, so that a renewed certificate is used without a restart. If loading fails, the previous
+// certificate stays in use.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   logrus.FieldLogger
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+// newCertReloader loads the certificate in `certFile` and `keyFile` (PEM).
+func newCertReloader(certFile string, keyFile string, logger logrus.FieldLogger) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	err := cr.reload()
+	if err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// reload loads the certificate from its files.
+func (cr *certReloader) reload() error {
+	modTimes, err := cr.fileModTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.cert = &cert
+	cr.modTimes = modTimes
+	return nil
+}
+
+// watch reloads the certificate every time its files change, checking them every `interval`, until `ctx` is done.
+func (cr *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modTimes, err := cr.fileModTimes()
+		if err != nil {
+			cr.logger.WithError(err).Warning("error checking the TLS certificate files")
+			continue
+		}
+
+		cr.mu.RLock()
+		changed := modTimes != cr.modTimes
+		cr.mu.RUnlock()
+
+		if !changed {
+			continue
+		}
+
+		err = cr.reload()
+		if err != nil {
+			// e.g., only one of the files has been replaced yet
+			cr.logger.WithError(err).Warning("error reloading the TLS certificate, keeping the previous one")
+			continue
+		}
+		cr.logger.Info("TLS certificate reloaded")
+	}
+}
+
+func (cr *certReloader) fileModTimes() (modTimes [2]time.Time, err error) {
+	for i, path := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTimes, fmt.Errorf("reading TLS certificate: %w", err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// getCertificate is the tls.Config.GetCertificate of the API server.
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// tlsConfig returns the TLS configuration of the API server: TLS 1.2 or newer, HTTP/2 preferred to HTTP/1.1.
+func (cr *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cr.getCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+}
+
+// redirectHandler redirects every request to the same URL over HTTPS, on the port of the API server at `apiAddr`.
+func redirectHandler(apiAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(apiAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+)
+
+// configErrors are the problems found in a configuration by validate
+type configErrors []string
+
+func (ce configErrors) Error() string {
+	return "invalid configuration: " + strings.Join(ce, "; ")
+}
+
+// validate checks that the configuration makes sense as a whole, beyond the types of its fields: addresses, durations,
+// enumerations, budgets, origins and the database. It returns all the problems found, as configErrors.
+func (cfg WebAPIConfiguration) validate() error {
+	var problems configErrors
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	check(checkHostPort("Web.APIHost", cfg.Web.APIHost, false))
+	check(checkHostPort("Web.DebugHost", cfg.Web.DebugHost, true))
+	check(checkHostPort("Web.TLS.RedirectHost", cfg.Web.TLS.RedirectHost, true))
+	check(checkPositive("Web.ReadTimeout", cfg.Web.ReadTimeout))
+	check(checkPositive("Web.WriteTimeout", cfg.Web.WriteTimeout))
+	check(checkPositive("Web.ShutdownTimeout", cfg.Web.ShutdownTimeout))
+	if (cfg.Web.TLS.CertFile == "") != (cfg.Web.TLS.KeyFile == "") {
+		check(errors.New("both or none of Web.TLS.CertFile and Web.TLS.KeyFile must be set"))
+	}
+	if cfg.Web.TLS.RedirectHost != "" && cfg.Web.TLS.CertFile == "" {
+		check(errors.New("the HTTPS redirect (Web.TLS.RedirectHost) needs TLS to be configured"))
+	}
+	if cfg.Web.HSTSMaxAge < 0 {
+		check(errors.New("negative Web.HSTSMaxAge"))
+	}
+
+	_, err := logLevel(cfg)
+	check(err)
+	switch cfg.Log.Destination {
+	case "stdout", "stderr":
+	case "file":
+		check(checkWritable("Log.File", cfg.Log.File))
+	default:
+		check(fmt.Errorf("invalid log destination %q (stdout, stderr or file)", cfg.Log.Destination))
+	}
+	if cfg.Log.MaxSize < 0 || cfg.Log.MaxAge < 0 || cfg.Log.MaxBackups < 0 {
+		check(errors.New("negative Log.MaxSize, Log.MaxAge or Log.MaxBackups"))
+	}
+
+	_, err = applyCORSHandler(http.NotFoundHandler(), cfg)
+	check(err)
+	_, err = cfg.rateLimits()
+	check(err)
+	_, err = newTracer(cfg)
+	check(err)
+
+	dialect := database.Dialect(cfg.DB.Driver)
+	switch {
+	case !dialect.Valid():
+		check(fmt.Errorf("unsupported database driver %q", cfg.DB.Driver))
+	case dialect == database.SQLite:
+		check(checkWritable("DB.Filename", cfg.DB.Filename))
+		if cfg.DB.Readers < 1 {
+			check(errors.New("no DB.Readers, there must be at least one"))
+		}
+	case cfg.DB.DSN == "":
+		check(fmt.Errorf("missing DB.DSN, required by %s", cfg.DB.Driver))
+	}
+	check(checkPositive("DB.ReadTimeout", cfg.DB.ReadTimeout))
+	check(checkPositive("DB.WriteTimeout", cfg.DB.WriteTimeout))
+	check(checkPositive("DB.BusyTimeout", cfg.DB.BusyTimeout))
+
+	check(checkPositive("Janitor.Interval", cfg.Janitor.Interval))
+	if cfg.Janitor.DeletedRetention < 0 || cfg.Janitor.AccountRetention < 0 {
+		check(errors.New("negative Janitor.DeletedRetention or Janitor.AccountRetention"))
+	}
+	check(checkPositive("Export.Retention", cfg.Export.Retention))
+
+	if cfg.Backup.Dir != "" {
+		if dialect != database.SQLite {
+			check(fmt.Errorf("backups are supported for sqlite3 only, not %s", cfg.DB.Driver))
+		}
+		check(checkPositive("Backup.Interval", cfg.Backup.Interval))
+		if cfg.Backup.Keep < 1 {
+			check(errors.New("no backups to keep (Backup.Keep), there must be at least one"))
+		}
+	}
+
+	if cfg.Seed.Users < 0 || cfg.Seed.Photos < 0 || cfg.Seed.Follows < 0 || cfg.Seed.Comments < 0 || cfg.Seed.Likes < 0 {
+		check(errors.New("negative count in Seed"))
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// checkHostPort checks that `value` of `field` is a <host>:<port> address, which may be empty if `optional`.
+func checkHostPort(field string, value string, optional bool) error {
+	if value == "" && optional {
+		return nil
+	}
+
+	_, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q, it must be <host>:<port>: %w", field, value, err)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid port in %s %q", field, value)
+	}
+	return nil
+}
+
+func checkPositive(field string, value time.Duration) error {
+	if value <= 0 {
+		return fmt.Errorf("non-positive %s, it must be positive", field)
+	}
+	return nil
+}
+
+// checkWritable checks that the file `path` of `field` can be written, or created if missing.
+func checkWritable(field string, path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err == nil {
+		_ = f.Close()
+		return nil
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("can't write %s %q: %w", field, path, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("can't create %s %q: %w", field, path, err)
+	}
+	_ = tmp.Close()
+	_ = os.Remove(tmp.Name())
+	return nil
+}
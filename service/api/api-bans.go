@@ -10,7 +10,7 @@ import (
 func (rt *_router) getUserBans(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 
 	// get the user ID
-	ret_data, err := rt.db.GetUserBans(ps.ByName("user_name"))
+	ret_data, err := rt.db.GetUserBans(ctx.Context, ps.ByName("user_name"))
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -38,7 +38,7 @@ func (rt *_router) banUser(w http.ResponseWriter, r *http.Request, ps httprouter
 	token := r.Header.Get("Authorization")
 	banisher := ps.ByName("user_name")
 
-	is_valid, err := rt.db.Validate(banisher, token)
+	is_valid, err := rt.db.Validate(ctx.Context, banisher, token)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -53,7 +53,7 @@ func (rt *_router) banUser(w http.ResponseWriter, r *http.Request, ps httprouter
 
 	to_ban := ps.ByName("banned_name")
 
-	ret, err := rt.db.BanUser(banisher, to_ban)
+	ret, err := rt.db.BanUser(ctx.Context, banisher, to_ban)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -77,7 +77,7 @@ func (rt *_router) unbanUser(w http.ResponseWriter, r *http.Request, ps httprout
 	token := r.Header.Get("Authorization")
 	banisher := ps.ByName("user_name")
 
-	is_valid, err := rt.db.Validate(banisher, token)
+	is_valid, err := rt.db.Validate(ctx.Context, banisher, token)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -92,7 +92,7 @@ func (rt *_router) unbanUser(w http.ResponseWriter, r *http.Request, ps httprout
 
 	to_unban := ps.ByName("banned_name")
 
-	ret, err := rt.db.UnbanUser(banisher, to_unban)
+	ret, err := rt.db.UnbanUser(ctx.Context, banisher, to_unban)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
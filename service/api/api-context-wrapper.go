@@ -2,7 +2,7 @@ package api
 
 import (
 	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/api/reqcontext"
-	"github.com/gofrs/uuid"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/tracing"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 	"net/http"
@@ -15,19 +15,17 @@ type httpRouterHandler func(http.ResponseWriter, *http.Request, httprouter.Param
 // wrap parses the request and adds a reqcontext.RequestContext instance related to the request.
 func (rt *_router) wrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Request, httprouter.Params) {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		reqUUID, err := uuid.NewV4()
-		if err != nil {
-			rt.baseLogger.WithError(err).Error("can't generate a request UUID")
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+		stats.Add("requests", 1)
+
+		// the ID is assigned when the request is routed (see routes.measure), so that the access log shares it
 		var ctx = reqcontext.RequestContext{
-			ReqUUID: reqUUID,
+			ReqID:   tracing.RequestID(r.Context()),
+			Context: r.Context(),
 		}
 
 		// Create a request-specific logger
 		ctx.Logger = rt.baseLogger.WithFields(logrus.Fields{
-			"reqid":     ctx.ReqUUID.String(),
+			"reqid":     ctx.ReqID,
 			"remote-ip": r.RemoteAddr,
 		})
 
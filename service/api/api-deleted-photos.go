@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net/http"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/api/reqcontext"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (rt *_router) getDeletedPhotos(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	// Only the author can see their deleted photos
+
+	token := r.Header.Get("Authorization")
+	userName := ps.ByName("user_name")
+
+	is_valid, err := rt.db.Validate(ctx.Context, userName, token)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		_, err := w.Write([]byte(components.InternalServerError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error validating user")
+		return
+	}
+
+	if !is_valid {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		_, err := w.Write([]byte(components.UnauthorizedError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	ret_data, err := rt.db.GetDeletedPhotos(ctx.Context, userName, rt.deletedRetention)
+
+	if err != nil {
+		w.WriteHeader(statusOf(ret_data))
+		ctx.Logger.WithError(err).Error("error getting deleted photos")
+		_, err := w.Write([]byte(ret_data))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	_, err = w.Write([]byte(ret_data))
+
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error writing response")
+	}
+
+}
+
+func (rt *_router) restorePhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	// get the user ID
+
+	token := r.Header.Get("Authorization")
+	userName := ps.ByName("user_name")
+
+	is_valid, err := rt.db.Validate(ctx.Context, userName, token)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		_, err := w.Write([]byte(components.InternalServerError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error validating user")
+		return
+	}
+
+	if !is_valid {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		_, err := w.Write([]byte(components.UnauthorizedError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	ret, err := rt.db.RestorePhoto(ctx.Context, userName, ps.ByName("photo_id"))
+
+	if err != nil {
+		w.WriteHeader(statusOf(ret))
+		ctx.Logger.WithError(err).Error("error restoring photo")
+		_, err := w.Write([]byte(ret))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+}
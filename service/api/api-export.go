@@ -0,0 +1,218 @@
+package api
+
+import (
+	"net/http"
+	"os"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/api/reqcontext"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (rt *_router) exportData(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	// Only the user can export their data
+
+	token := r.Header.Get("Authorization")
+	userName := ps.ByName("user_name")
+
+	is_valid, err := rt.db.Validate(ctx.Context, userName, token)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		_, err := w.Write([]byte(components.InternalServerError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error validating user")
+		return
+	}
+
+	if !is_valid {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		_, err := w.Write([]byte(components.UnauthorizedError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	ret_data, err := rt.db.CreateExport(ctx.Context, userName, rt.exportRetention)
+
+	if err != nil {
+		w.WriteHeader(statusOf(ret_data))
+		ctx.Logger.WithError(err).Error("error creating export")
+		_, err := w.Write([]byte(ret_data))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	rt.queueExport()
+
+	w.WriteHeader(http.StatusAccepted)
+
+	_, err = w.Write([]byte(ret_data))
+
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error writing response")
+	}
+
+}
+
+func (rt *_router) getExport(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	token := r.Header.Get("Authorization")
+	userName := ps.ByName("user_name")
+
+	is_valid, err := rt.db.Validate(ctx.Context, userName, token)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		_, err := w.Write([]byte(components.InternalServerError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error validating user")
+		return
+	}
+
+	if !is_valid {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		_, err := w.Write([]byte(components.UnauthorizedError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	ret_data, err := rt.db.GetExport(ctx.Context, userName, ps.ByName("job_id"), rt.exportRetention)
+
+	if err != nil {
+		w.WriteHeader(statusOf(ret_data))
+		ctx.Logger.WithError(err).Error("error getting export")
+		_, err := w.Write([]byte(ret_data))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	_, err = w.Write([]byte(ret_data))
+
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error writing response")
+	}
+
+}
+
+func (rt *_router) getExportArchive(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	token := r.Header.Get("Authorization")
+	userName := ps.ByName("user_name")
+
+	is_valid, err := rt.db.Validate(ctx.Context, userName, token)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		_, err := w.Write([]byte(components.InternalServerError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error validating user")
+		return
+	}
+
+	if !is_valid {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		_, err := w.Write([]byte(components.UnauthorizedError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	jobID := ps.ByName("job_id")
+
+	ret, err := rt.db.ExportReady(ctx.Context, userName, jobID)
+
+	if err != nil {
+		w.WriteHeader(statusOf(ret))
+		ctx.Logger.WithError(err).Error("error getting export archive")
+		_, err := w.Write([]byte(ret))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	archive, err := os.Open(database.ExportPath(jobID))
+
+	if err != nil {
+		// expired in the meantime
+		status, body := http.StatusInternalServerError, components.InternalServerError
+		if os.IsNotExist(err) {
+			status, body = http.StatusNotFound, components.NotFoundError
+		}
+
+		w.WriteHeader(status)
+		ctx.Logger.WithError(err).Error("error opening export archive")
+		_, err := w.Write([]byte(body))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	defer func() {
+		_ = archive.Close()
+	}()
+
+	info, err := archive.Stat()
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		ctx.Logger.WithError(err).Error("error reading export archive")
+		_, err := w.Write([]byte(components.InternalServerError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+userName+`-export.zip"`)
+
+	http.ServeContent(w, r, "", info.ModTime(), archive)
+
+}
@@ -14,7 +14,7 @@ func (rt *_router) getUserFollowers(w http.ResponseWriter, r *http.Request, ps h
 	uname := ps.ByName("user_name")
 
 	// Get the list of followers
-	followers, err := rt.db.GetUserFollowers(uname)
+	followers, err := rt.db.GetUserFollowers(ctx.Context, uname)
 	if err != nil {
 
 		w.WriteHeader(http.StatusInternalServerError)
@@ -42,7 +42,7 @@ func (rt *_router) getUserFollowing(w http.ResponseWriter, r *http.Request, ps h
 
 	uname := ps.ByName("user_name")
 
-	following, err := rt.db.GetUserFollowing(uname)
+	following, err := rt.db.GetUserFollowing(ctx.Context, uname)
 
 	if err != nil {
 
@@ -72,7 +72,7 @@ func (rt *_router) followUser(w http.ResponseWriter, r *http.Request, ps httprou
 	followed_name := ps.ByName("followed_name")
 	token := r.Header.Get("Authorization")
 
-	is_valid, err := rt.db.Validate(username, token)
+	is_valid, err := rt.db.Validate(ctx.Context, username, token)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -100,7 +100,7 @@ func (rt *_router) followUser(w http.ResponseWriter, r *http.Request, ps httprou
 
 	// Insert the follow relationship into the database
 
-	ret_data, err := rt.db.FollowUser(username, followed_name)
+	ret_data, err := rt.db.FollowUser(ctx.Context, username, followed_name)
 
 	if err != nil {
 
@@ -129,7 +129,7 @@ func (rt *_router) unfollowUser(w http.ResponseWriter, r *http.Request, ps httpr
 	followed_name := ps.ByName("followed_name")
 	token := r.Header.Get("Authorization")
 
-	is_valid, err := rt.db.Validate(username, token)
+	is_valid, err := rt.db.Validate(ctx.Context, username, token)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -161,7 +161,7 @@ func (rt *_router) unfollowUser(w http.ResponseWriter, r *http.Request, ps httpr
 
 	// Insert the follow relationship into the database
 
-	ret_data, err := rt.db.UnfollowUser(username, followed_name)
+	ret_data, err := rt.db.UnfollowUser(ctx.Context, username, followed_name)
 
 	if err != nil {
 
@@ -12,11 +12,14 @@ func (rt *_router) Handler() http.Handler {
 
 	// Special routes
 	rt.router.GET("/liveness", rt.liveness)
+	rt.router.GET("/readiness", rt.readiness)
 
 	// User routes
 
 	rt.router.GET("/users", rt.wrap(rt.searchUser))
 
+	rt.router.GET("/search", rt.wrap(rt.search))
+
 	// Session routes
 	rt.router.PUT("/session", rt.wrap(rt.doLogin))
 
@@ -60,14 +63,41 @@ func (rt *_router) Handler() http.Handler {
 
 	rt.router.PUT("/users/:user_name/profile/photos/:photo_id", rt.wrap(rt.uploadPhoto))
 	rt.router.DELETE("/users/:user_name/profile/photos/:photo_id", rt.wrap(rt.deletePhoto))
+	rt.router.PATCH("/users/:user_name/profile/photos/:photo_id", rt.wrap(rt.updatePhoto))
+	rt.router.PUT("/users/:user_name/profile/photos/:photo_id/media/:media_id/alt_text", rt.wrap(rt.setAltText))
+
+	// Recently deleted photos routes
+
+	rt.router.GET("/users/:user_name/profile/deleted_photos", rt.wrap(rt.getDeletedPhotos))
+	rt.router.POST("/users/:user_name/profile/deleted_photos/:photo_id/restore", rt.wrap(rt.restorePhoto))
 
 	// Username change routes
 
 	rt.router.PUT("/users/:user_name/profile", rt.wrap(rt.changeUsername))
 
+	// Account deletion routes
+
+	rt.router.PUT("/users/:user_name/reauthentication", rt.wrap(rt.reauthenticate))
+	rt.router.DELETE("/users/:user_name", rt.wrap(rt.deleteUser))
+
+	// Profile routes
+
+	rt.router.GET("/users/:user_name/profile", rt.wrap(rt.getUserProfile))
+	rt.router.PATCH("/users/:user_name/profile", rt.wrap(rt.updateProfile))
+
+	// Personal data export routes
+
+	rt.router.POST("/users/:user_name/export", rt.wrap(rt.exportData))
+	rt.router.GET("/users/:user_name/export/:job_id", rt.wrap(rt.getExport))
+	rt.router.GET("/users/:user_name/export/:job_id/archive", rt.wrap(rt.getExportArchive))
+
 	// Stream routes
 
 	rt.router.GET("/users/:user_name/stream", rt.wrap(rt.getStream))
 
+	// Hashtag routes
+
+	rt.router.GET("/tags/:tag/photos", rt.wrap(rt.getTagPhotos))
+
 	return rt.router
 }
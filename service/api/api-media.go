@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/api/reqcontext"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (rt *_router) setAltText(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	// get the user ID
+
+	token := r.Header.Get("Authorization")
+	userName := ps.ByName("user_name")
+
+	is_valid, err := rt.db.Validate(ctx.Context, userName, token)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		_, err := w.Write([]byte(components.InternalServerError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error validating user")
+		return
+	}
+
+	if !is_valid {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		_, err := w.Write([]byte(components.UnauthorizedError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	// Retrieve the alt text from request body
+
+	var alt components.AltText
+
+	err = json.NewDecoder(r.Body).Decode(&alt)
+
+	if err == nil && utf8.RuneCountInString(alt.AltText) > components.MaxAltTextLength {
+		err = fmt.Errorf("alt text longer than %d characters", components.MaxAltTextLength)
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		_, err := w.Write([]byte(components.BadRequestErrorFor(err)))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Info("bad alt text request")
+		return
+	}
+
+	ret, err := rt.db.SetAltText(ctx.Context, userName, ps.ByName("photo_id"), ps.ByName("media_id"), alt.AltText)
+
+	if err != nil {
+		w.WriteHeader(statusOf(ret))
+		ctx.Logger.WithError(err).Error("error setting alt text")
+		_, err := w.Write([]byte(ret))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+}
@@ -18,7 +18,7 @@ func (rt *_router) GetPhotoLikes(w http.ResponseWriter, r *http.Request, ps http
 	photoID := ps.ByName("photo_id")
 
 	// get the photo likes
-	ret_data, err := rt.db.GetPhotoLikes(photoID)
+	ret_data, err := rt.db.GetPhotoLikes(ctx.Context, photoID)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -48,7 +48,7 @@ func (rt *_router) GetPhotoComments(w http.ResponseWriter, r *http.Request, ps h
 	photoID := ps.ByName("photo_id")
 
 	// get the photo comments
-	ret_data, err := rt.db.GetPhotoComments(photoID)
+	ret_data, err := rt.db.GetPhotoComments(ctx.Context, photoID)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -81,7 +81,7 @@ func (rt *_router) likePhoto(w http.ResponseWriter, r *http.Request, ps httprout
 
 	token := r.Header.Get("Authorization")
 	liker_id := ps.ByName("liker_id")
-	liker_name, err := rt.db.GetUsername(liker_id)
+	liker_name, err := rt.db.GetUsername(ctx.Context, liker_id)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -89,7 +89,7 @@ func (rt *_router) likePhoto(w http.ResponseWriter, r *http.Request, ps httprout
 		return
 	}
 
-	is_valid, err := rt.db.Validate(liker_name, token)
+	is_valid, err := rt.db.Validate(ctx.Context, liker_name, token)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -102,7 +102,7 @@ func (rt *_router) likePhoto(w http.ResponseWriter, r *http.Request, ps httprout
 		return
 	}
 
-	ret, err := rt.db.LikePhoto(liker_id, photoID)
+	ret, err := rt.db.LikePhoto(ctx.Context, liker_id, photoID)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -131,7 +131,7 @@ func (rt *_router) unlikePhoto(w http.ResponseWriter, r *http.Request, ps httpro
 	token := r.Header.Get("Authorization")
 
 	liker_id := ps.ByName("liker_id")
-	liker_name, err := rt.db.GetUsername(liker_id)
+	liker_name, err := rt.db.GetUsername(ctx.Context, liker_id)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -139,7 +139,7 @@ func (rt *_router) unlikePhoto(w http.ResponseWriter, r *http.Request, ps httpro
 		return
 	}
 
-	is_valid, err := rt.db.Validate(liker_name, token)
+	is_valid, err := rt.db.Validate(ctx.Context, liker_name, token)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -152,7 +152,7 @@ func (rt *_router) unlikePhoto(w http.ResponseWriter, r *http.Request, ps httpro
 		return
 	}
 
-	ret, err := rt.db.UnlikePhoto(liker_id, photoID)
+	ret, err := rt.db.UnlikePhoto(ctx.Context, liker_id, photoID)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -185,7 +185,7 @@ func (rt *_router) commentPhoto(w http.ResponseWriter, r *http.Request, ps httpr
 	// Validate user, get name from header
 	commenter_name := r.Header.Get("commenter_name")
 
-	is_valid, err := rt.db.Validate(commenter_name, token)
+	is_valid, err := rt.db.Validate(ctx.Context, commenter_name, token)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -234,7 +234,7 @@ func (rt *_router) commentPhoto(w http.ResponseWriter, r *http.Request, ps httpr
 
 	comment.Comment_ID.Hash = comment_id
 
-	ret, err := rt.db.CommentPhoto(userName, photoID, comment)
+	ret, err := rt.db.CommentPhoto(ctx.Context, userName, photoID, comment)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -262,7 +262,7 @@ func (rt *_router) deleteComment(w http.ResponseWriter, r *http.Request, ps http
 
 	userName := ps.ByName("user_name")
 
-	is_valid, err := rt.db.Validate(userName, token)
+	is_valid, err := rt.db.Validate(ctx.Context, userName, token)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -291,7 +291,7 @@ func (rt *_router) deleteComment(w http.ResponseWriter, r *http.Request, ps http
 
 	comment_id := ps.ByName("comment_id")
 
-	ret, err := rt.db.UncommentPhoto(userName, photoID, comment_id)
+	ret, err := rt.db.UncommentPhoto(ctx.Context, userName, photoID, comment_id)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -313,7 +313,7 @@ func (rt *_router) uploadPhoto(w http.ResponseWriter, r *http.Request, ps httpro
 
 	token := r.Header.Get("Authorization")
 	userName := ps.ByName("user_name")
-	is_valid, err := rt.db.Validate(userName, token)
+	is_valid, err := rt.db.Validate(ctx.Context, userName, token)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -365,10 +365,10 @@ func (rt *_router) uploadPhoto(w http.ResponseWriter, r *http.Request, ps httpro
 
 	photo_id := ps.ByName("photo_id")
 
-	ret, err := rt.db.UploadPhoto(userName, photo, photo_id)
+	ret, err := rt.db.UploadPhoto(ctx.Context, userName, photo, photo_id)
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(statusOf(ret))
 		ctx.Logger.WithError(err).Error("error uploading photo")
 		_, err := w.Write([]byte(ret))
 
@@ -379,6 +379,8 @@ func (rt *_router) uploadPhoto(w http.ResponseWriter, r *http.Request, ps httpro
 		return
 	}
 
+	stats.Add("upload_bytes", uploadSize(photo))
+
 	w.WriteHeader(http.StatusNoContent)
 
 }
@@ -391,7 +393,7 @@ func (rt *_router) deletePhoto(w http.ResponseWriter, r *http.Request, ps httpro
 
 	userName := ps.ByName("user_name")
 
-	is_valid, err := rt.db.Validate(userName, token)
+	is_valid, err := rt.db.Validate(ctx.Context, userName, token)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -420,7 +422,7 @@ func (rt *_router) deletePhoto(w http.ResponseWriter, r *http.Request, ps httpro
 
 	photo_id := ps.ByName("photo_id")
 
-	ret, err := rt.db.DeletePhoto(userName, photo_id)
+	ret, err := rt.db.DeletePhoto(ctx.Context, userName, photo_id)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -438,6 +440,85 @@ func (rt *_router) deletePhoto(w http.ResponseWriter, r *http.Request, ps httpro
 
 }
 
+func (rt *_router) updatePhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	// get the user ID
+
+	token := r.Header.Get("Authorization")
+	userName := ps.ByName("user_name")
+
+	is_valid, err := rt.db.Validate(ctx.Context, userName, token)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		_, err := w.Write([]byte(components.InternalServerError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error validating user")
+		return
+	}
+
+	if !is_valid {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		_, err := w.Write([]byte(components.UnauthorizedError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	// Read the fields to update from the request body
+
+	var update components.PhotoUpdate
+
+	err = json.NewDecoder(r.Body).Decode(&update)
+
+	if err == nil {
+		err = update.Validate()
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		_, err := w.Write([]byte(components.BadRequestErrorFor(err)))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Info("bad photo update request")
+		return
+	}
+
+	ret_data, err := rt.db.UpdatePhoto(ctx.Context, userName, ps.ByName("photo_id"), update)
+
+	if err != nil {
+		w.WriteHeader(statusOf(ret_data))
+		ctx.Logger.WithError(err).Error("error updating photo")
+		_, err := w.Write([]byte(ret_data))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	_, err = w.Write([]byte(ret_data))
+
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error writing response")
+	}
+
+}
+
 func (rt *_router) getStream(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 
 	// get the user ID
@@ -446,7 +527,7 @@ func (rt *_router) getStream(w http.ResponseWriter, r *http.Request, ps httprout
 
 	userName := ps.ByName("user_name")
 
-	is_valid, err := rt.db.Validate(userName, token)
+	is_valid, err := rt.db.Validate(ctx.Context, userName, token)
 
 	if err != nil {
 
@@ -537,7 +618,7 @@ func (rt *_router) getStream(w http.ResponseWriter, r *http.Request, ps httprout
 
 	}
 
-	ret_json_string, err := rt.db.GetStream(userName, lower_bound, offset)
+	ret_json_string, err := rt.db.GetStream(ctx.Context, userName, lower_bound, offset)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -12,6 +12,7 @@ import (
 
 	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/api/reqcontext"
 	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -41,7 +42,7 @@ func (rt *_router) getPhoto(w http.ResponseWriter, r *http.Request, ps httproute
 
 	// Check if the picture exists
 	if uuid != well_known {
-		exists, err := rt.db.CheckPhotoExists(uuid)
+		exists, err := rt.db.CheckPhotoExists(ctx.Context, uuid)
 
 		if err != nil {
 			w.WriteHeader(http.StatusNotFound)
@@ -62,7 +63,7 @@ func (rt *_router) getPhoto(w http.ResponseWriter, r *http.Request, ps httproute
 
 	// Get the photo from the filesystem
 
-	img_file, err := os.Open("/tmp/photos/" + uuid + ".png")
+	img_file, err := os.Open(database.PhotoPath(uuid))
 
 	if err != nil {
 
@@ -81,6 +82,10 @@ func (rt *_router) getPhoto(w http.ResponseWriter, r *http.Request, ps httproute
 		_ = img_file.Close()
 	}()
 
+	if info, err := img_file.Stat(); err == nil {
+		photoBytesRead.Add(float64(info.Size()))
+	}
+
 	img, _, err := image.Decode(img_file)
 
 	if err != nil {
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"net/http"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/api/reqcontext"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxSearchLength is the maximum length (in bytes) of a search query
+const maxSearchLength = 256
+
+func (rt *_router) search(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	token := r.Header.Get("Authorization")
+	username := r.Header.Get("user_name")
+
+	is_valid, err := rt.db.Validate(ctx.Context, username, token)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		_, err := w.Write([]byte(components.InternalServerError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error validating user")
+		return
+	}
+
+	if !is_valid {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		_, err := w.Write([]byte(components.UnauthorizedError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	// get the search parameters from the query
+
+	text := r.URL.Query().Get("q")
+	kind := r.URL.Query().Get("type")
+
+	if kind == "" {
+		kind = database.SearchUsers
+	}
+
+	from, offset, err := parsePageBounds(r)
+
+	if err != nil || text == "" || len(text) > maxSearchLength ||
+		(kind != database.SearchUsers && kind != database.SearchPhotos) {
+
+		w.WriteHeader(http.StatusBadRequest)
+
+		_, err := w.Write([]byte(components.BadRequestError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.Info("bad search request query")
+		return
+	}
+
+	ret_data, err := rt.db.Search(ctx.Context, text, kind, username, from, offset)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte(ret_data))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error searching")
+		return
+	}
+
+	_, err = w.Write([]byte(ret_data))
+
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error writing response")
+	}
+
+}
@@ -34,10 +34,10 @@ func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, ps httprouter
 	}
 
 	// get the user ID
-	ret_data, err := rt.db.PostUserID(uname.Uname)
+	ret_data, err := rt.db.PostUserID(ctx.Context, uname.Uname)
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(statusOf(ret_data))
 
 		ctx.Logger.WithError(err).Error(
 			fmt.Errorf("error getting user ID (username: %s), details: %w", uname.Uname, err).Error())
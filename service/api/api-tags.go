@@ -0,0 +1,135 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/api/reqcontext"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (rt *_router) getTagPhotos(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	// the requester is needed to hide the photos of users that banned them
+
+	token := r.Header.Get("Authorization")
+	username := r.Header.Get("user_name")
+
+	is_valid, err := rt.db.Validate(ctx.Context, username, token)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+
+		_, err := w.Write([]byte(components.InternalServerError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error validating user")
+		return
+	}
+
+	if !is_valid {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		_, err := w.Write([]byte(components.UnauthorizedError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	// Get the tag from path, with or without the leading '#'
+
+	tag := components.NormalizeTag(ps.ByName("tag"))
+
+	if !components.ValidTag(tag) {
+		w.WriteHeader(http.StatusBadRequest)
+
+		_, err := w.Write([]byte(components.BadRequestError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.Error("bad hashtag in request path")
+		return
+	}
+
+	lower_bound, offset, err := parsePageBounds(r)
+
+	if err != nil {
+
+		ctx.Logger.WithError(err).Error("bad tag photos request query")
+
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte(components.BadRequestError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		return
+	}
+
+	ret_data, err := rt.db.GetTagPhotos(ctx.Context, tag, username, lower_bound, offset)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte(ret_data))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error getting tagged photos")
+		return
+	}
+
+	_, err = w.Write([]byte(ret_data))
+
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error writing response")
+	}
+
+}
+
+// parsePageBounds reads the `from` and `offset` pagination parameters from the query, with the same defaults and
+// limits of the stream.
+func parsePageBounds(r *http.Request) (from int, offset int, err error) {
+
+	from_str := r.URL.Query().Get("from")
+
+	if from_str == "" {
+		from_str = "0"
+	}
+
+	from, err = strconv.Atoi(from_str)
+
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad 'from' parameter: %w", err)
+	}
+
+	offset_str := r.URL.Query().Get("offset")
+
+	if offset_str == "" {
+		offset_str = "10"
+	}
+
+	offset, err = strconv.Atoi(offset_str)
+
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad 'offset' parameter: %w", err)
+	}
+
+	if from < 0 || offset < 1 || offset > 255 {
+		return 0, 0, fmt.Errorf("bounds out of range (from: %d, offset: %d)", from, offset)
+	}
+
+	return from, offset, nil
+}
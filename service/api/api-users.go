@@ -5,12 +5,16 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/api/reqcontext"
 	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
 	"github.com/julienschmidt/httprouter"
 )
 
+// reauthenticationTTL is how long a reauthentication token is valid for
+const reauthenticationTTL = 5 * time.Minute
+
 func (rt *_router) searchUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 
 	id := r.Header.Get("Authorization")
@@ -18,7 +22,7 @@ func (rt *_router) searchUser(w http.ResponseWriter, r *http.Request, ps httprou
 
 	// check if the user is logged in
 
-	is_valid, err := rt.db.Validate(username, id)
+	is_valid, err := rt.db.Validate(ctx.Context, username, id)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -43,7 +47,7 @@ func (rt *_router) searchUser(w http.ResponseWriter, r *http.Request, ps httprou
 	json_out := r.URL.Query().Get("search_term")
 
 	// get the list of users with the given name
-	ret_data, err := rt.db.SearchUserByName(json_out)
+	ret_data, err := rt.db.SearchUserByName(ctx.Context, json_out, username)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -84,7 +88,7 @@ func (rt *_router) getUserPhotos(w http.ResponseWriter, r *http.Request, ps http
 
 	// Check if the user exists
 
-	exists, err := rt.db.CheckUsernameExists(name)
+	exists, err := rt.db.CheckUsernameExists(ctx.Context, name)
 
 	if err != nil || !exists {
 		w.WriteHeader(http.StatusNotFound)
@@ -100,7 +104,7 @@ func (rt *_router) getUserPhotos(w http.ResponseWriter, r *http.Request, ps http
 
 	// Get the user id
 
-	id, err := rt.db.GetUserID(name)
+	id, err := rt.db.GetUserID(ctx.Context, name)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -114,9 +118,25 @@ func (rt *_router) getUserPhotos(w http.ResponseWriter, r *http.Request, ps http
 		return
 	}
 
+	// Archived photos are listed only to their author
+
+	is_owner, err := rt.db.Validate(ctx.Context, name, r.Header.Get("Authorization"))
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte(components.InternalServerError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error authenticating")
+		return
+	}
+
 	// Get the list of photos from the database
 
-	ret_data, err := rt.db.GetUserPhotos(id)
+	ret_data, err := rt.db.GetUserPhotos(ctx.Context, id, is_owner)
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -148,7 +168,7 @@ func (rt *_router) changeUsername(w http.ResponseWriter, r *http.Request, ps htt
 
 	id := r.Header.Get("Authorization")
 
-	is_valid, err := rt.db.Validate(user_name, id)
+	is_valid, err := rt.db.Validate(ctx.Context, user_name, id)
 
 	if err != nil {
 		w.WriteHeader(http.StatusUnauthorized)
@@ -197,7 +217,7 @@ func (rt *_router) changeUsername(w http.ResponseWriter, r *http.Request, ps htt
 
 	// Change the username in the database
 
-	ret_data, err := rt.db.ChangeUsername(user_name, new_username.Uname)
+	ret_data, err := rt.db.ChangeUsername(ctx.Context, user_name, new_username.Uname)
 
 	if err != nil {
 
@@ -221,13 +241,119 @@ func (rt *_router) changeUsername(w http.ResponseWriter, r *http.Request, ps htt
 
 		}
 
+		w.WriteHeader(statusOf(ret_data))
+		ctx.Logger.WithError(err).Error("error changing username")
+		_, err := w.Write([]byte(ret_data))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+		return
+	}
+
+	_, err = w.Write([]byte(ret_data))
+
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error writing response")
+	}
+
+}
+
+func (rt *_router) getUserProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	// Get username from path
+
+	name := ps.ByName("user_name")
+
+	ret_data, err := rt.db.GetUserProfile(ctx.Context, name)
+
+	if err != nil {
+		w.WriteHeader(statusOf(ret_data))
+		_, err := w.Write([]byte(ret_data))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error getting user profile")
+		return
+	}
+
+	_, err = w.Write([]byte(ret_data))
+
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error writing response")
+	}
+
+}
+
+func (rt *_router) updateProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	// Get user name from path and user id from header
+
+	user_name := ps.ByName("user_name")
+	id := r.Header.Get("Authorization")
+
+	is_valid, err := rt.db.Validate(ctx.Context, user_name, id)
+
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte(components.InternalServerError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error authenticating")
+		return
+	}
+
+	if !is_valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, err := w.Write([]byte(components.UnauthorizedError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+		ctx.Logger.Error("error authenticating")
+		return
+	}
+
+	// Read the fields to update from the request body
+
+	dec := json.NewDecoder(r.Body)
+
+	var update components.ProfileUpdate
+
+	err = dec.Decode(&update)
+
+	if err == nil {
+		err = update.Validate()
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte(components.BadRequestErrorFor(err)))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Info("bad profile update request")
+		return
+	}
+
+	ret_data, err := rt.db.UpdateProfile(ctx.Context, user_name, update)
+
+	if err != nil {
+		w.WriteHeader(statusOf(ret_data))
 		_, err := w.Write([]byte(ret_data))
 
 		if err != nil {
 			ctx.Logger.WithError(err).Error("error writing response")
 		}
-		ctx.Logger.WithError(err).Error("error changing username")
+
+		ctx.Logger.WithError(err).Error("error updating profile")
 		return
 	}
 
@@ -238,3 +364,153 @@ func (rt *_router) changeUsername(w http.ResponseWriter, r *http.Request, ps htt
 	}
 
 }
+
+// reauthenticate logs the user in again, with the login body of /session and the session token, and issues the
+// reauthentication token that deleteUser requires, valid once for reauthenticationTTL
+func (rt *_router) reauthenticate(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	user_name := ps.ByName("user_name")
+
+	token := r.Header.Get("Authorization")
+
+	dec := json.NewDecoder(r.Body)
+
+	var login components.User
+
+	err := dec.Decode(&login)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte(components.BadRequestError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error decoding request body")
+		return
+	}
+
+	if login.Uname != user_name {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, err := w.Write([]byte(components.UnauthorizedError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.Info("reauthentication of another user")
+		return
+	}
+
+	ret_data, err := rt.db.Reauthenticate(ctx.Context, user_name, token, reauthenticationTTL)
+
+	if err != nil {
+		w.WriteHeader(statusOf(ret_data))
+		_, err := w.Write([]byte(ret_data))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error reauthenticating")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write([]byte(ret_data))
+
+	if err != nil {
+		ctx.Logger.WithError(err).Error("error writing response")
+	}
+
+}
+
+func (rt *_router) deleteUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+
+	user_name := ps.ByName("user_name")
+
+	id := r.Header.Get("Authorization")
+
+	is_valid, err := rt.db.Validate(ctx.Context, user_name, id)
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err := w.Write([]byte(components.InternalServerError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error authenticating")
+		return
+	}
+
+	if !is_valid {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, err := w.Write([]byte(components.UnauthorizedError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+		ctx.Logger.Error("error authenticating")
+		return
+	}
+
+	// The body is the reauthentication token issued by reauthenticate: a stolen session token is not enough to delete
+	// the account
+
+	dec := json.NewDecoder(r.Body)
+
+	var reauth components.SHA256hash
+
+	err = dec.Decode(&reauth)
+
+	if err != nil || reauth.Hash == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, err := w.Write([]byte(components.BadRequestError))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error decoding request body")
+		return
+	}
+
+	ret, err := rt.db.DeactivateUser(ctx.Context, user_name, reauth.Hash)
+
+	if err != nil {
+		w.WriteHeader(statusOf(ret))
+		_, err := w.Write([]byte(ret))
+
+		if err != nil {
+			ctx.Logger.WithError(err).Error("error writing response")
+		}
+
+		ctx.Logger.WithError(err).Error("error deleting user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+}
+
+// statusOf returns the HTTP status matching one of the canned error bodies returned by the database, defaulting to
+// 500 for anything else.
+func statusOf(errstring string) int {
+	switch errstring {
+	case components.BadRequestError:
+		return http.StatusBadRequest
+	case components.UnauthorizedError:
+		return http.StatusUnauthorized
+	case components.ForbiddenError:
+		return http.StatusForbidden
+	case components.NotFoundError:
+		return http.StatusNotFound
+	case components.ConflictError:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
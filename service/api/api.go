@@ -9,8 +9,12 @@ Example:
 
 	// Create the API router
 	apirouter, err := api.New(api.Config{
-		Logger:   logger,
-		Database: appdb,
+		Logger:           logger,
+		Database:         appdb,
+		DeletedRetention: cfg.Janitor.DeletedRetention,
+		JanitorInterval:  cfg.Janitor.Interval,
+		AccountRetention: cfg.Janitor.AccountRetention,
+		ExportRetention:  cfg.Export.Retention,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
@@ -37,11 +41,12 @@ See the `main.go` file inside the `cmd/webapi` for a full usage example.
 package api
 
 import (
+	"context"
 	"errors"
 	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
-	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"time"
 )
 
 // Config is used to provide dependencies and configuration to the New function.
@@ -51,6 +56,37 @@ type Config struct {
 
 	// Database is the instance of database.AppDatabase where data are saved
 	Database database.AppDatabase
+
+	// DeletedRetention is how long deleted photos can be restored before the janitor purges them
+	DeletedRetention time.Duration
+
+	// AccountRetention is how long deleted accounts stay deactivated before the janitor purges them
+	AccountRetention time.Duration
+
+	// JanitorInterval is the time between two runs of the janitor
+	JanitorInterval time.Duration
+
+	// ExportRetention is how long the archive of a personal data export can be downloaded before the janitor erases it
+	ExportRetention time.Duration
+
+	// MinFreeSpace is the free space, in bytes, that the photo store needs for the server to be ready; 0 disables the
+	// check
+	MinFreeSpace uint64
+
+	// AccessLog enables a log line for each request, with its route, status, size and duration
+	AccessLog bool
+
+	// RateLimits are the budgets of requests of each client, none by default
+	RateLimits RateLimits
+}
+
+// Settings are the options of the Router that can be changed while it serves requests, see Router.Reconfigure
+type Settings struct {
+	// AccessLog enables a log line for each request, see Config.AccessLog
+	AccessLog bool
+
+	// RateLimits are the budgets of requests of each client, see Config.RateLimits
+	RateLimits RateLimits
 }
 
 // Router is the package API interface representing an API handler builder
@@ -58,6 +94,9 @@ type Router interface {
 	// Handler returns an HTTP handler for APIs provided in this package
 	Handler() http.Handler
 
+	// Reconfigure applies `s` to the requests that come next
+	Reconfigure(s Settings)
+
 	// Close terminates any resource used in the package
 	Close() error
 }
@@ -71,26 +110,74 @@ func New(cfg Config) (Router, error) {
 	if cfg.Database == nil {
 		return nil, errors.New("database is required")
 	}
+	if cfg.DeletedRetention < 0 {
+		return nil, errors.New("deleted photos retention must not be negative")
+	}
+	if cfg.AccountRetention < 0 {
+		return nil, errors.New("deleted accounts retention must not be negative")
+	}
+	if cfg.JanitorInterval <= 0 {
+		return nil, errors.New("janitor interval must be positive")
+	}
+	if cfg.ExportRetention <= 0 {
+		return nil, errors.New("export retention must be positive")
+	}
 
 	// Create a new router where we will register HTTP endpoints. The server will pass requests to this router to be
 	// handled.
-	router := httprouter.New()
+	router := newRoutes(cfg.Logger, cfg.Database.Authenticate, Settings{AccessLog: cfg.AccessLog, RateLimits: cfg.RateLimits})
 	router.RedirectTrailingSlash = false
 	router.RedirectFixedPath = false
 
-	return &_router{
-		router:     router,
-		baseLogger: cfg.Logger,
-		db:         cfg.Database,
-	}, nil
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+
+	rt := &_router{
+		router:           router,
+		baseLogger:       cfg.Logger,
+		db:               cfg.Database,
+		deletedRetention: cfg.DeletedRetention,
+		accountRetention: cfg.AccountRetention,
+		exportRetention:  cfg.ExportRetention,
+		minFreeSpace:     cfg.MinFreeSpace,
+		stopBackground:   stopBackground,
+		janitorDone:      make(chan struct{}),
+		exportQueued:     make(chan struct{}, 1),
+		exporterDone:     make(chan struct{}),
+	}
+
+	go rt.janitor(backgroundCtx, cfg.JanitorInterval)
+	go rt.exporter(backgroundCtx)
+
+	return rt, nil
 }
 
 type _router struct {
-	router *httprouter.Router
+	router routes
 
 	// baseLogger is a logger for non-requests contexts, like goroutines or background tasks not started by a request.
 	// Use context logger if available (e.g., in requests) instead of this logger.
 	baseLogger logrus.FieldLogger
 
 	db database.AppDatabase
+
+	// deletedRetention is how long deleted photos can be restored
+	deletedRetention time.Duration
+
+	// accountRetention is how long deleted accounts are kept deactivated
+	accountRetention time.Duration
+
+	// exportRetention is how long the archive of an export can be downloaded
+	exportRetention time.Duration
+
+	// minFreeSpace is the free space, in bytes, of the photo store below which the server is not ready
+	minFreeSpace uint64
+
+	// stopBackground cancels the context of the janitor and of the exporter, interrupting any running query; they
+	// then close janitorDone and exporterDone
+	stopBackground context.CancelFunc
+	janitorDone    chan struct{}
+	exporterDone   chan struct{}
+
+	// exportQueued wakes the exporter up, see queueExport
+	exportQueued chan struct{}
 }
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+)
+
+// exporter builds the archives of the personal data exports, one at a time, until Close is called: at start (resuming
+// those interrupted by a restart), and whenever queueExport is called. Its queries run in `ctx`, which Close cancels.
+func (rt *_router) exporter(ctx context.Context) {
+
+	defer close(rt.exporterDone)
+
+	for {
+		for {
+			ran, err := rt.db.RunNextExport(ctx)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("exporter: error running export")
+			}
+
+			// with an error and no job run, retry on the next queued export
+			if !ran {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-rt.exportQueued:
+		}
+	}
+}
+
+// queueExport wakes the exporter up; if it is building an archive, it looks for more once done.
+func (rt *_router) queueExport() {
+	select {
+	case rt.exportQueued <- struct{}{}:
+	default:
+	}
+}
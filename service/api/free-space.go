@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+
+package api
+
+import "syscall"
+
+// freeSpace returns the bytes available to unprivileged users on the file system of `path`.
+func freeSpace(path string) (free uint64, supported bool, err error) {
+	var st syscall.Statfs_t
+
+	err = syscall.Statfs(path, &st)
+	if err != nil {
+		return 0, true, err
+	}
+
+	// the types of the fields change among systems
+	return uint64(st.Bavail) * uint64(st.Bsize), true, nil //nolint:unconvert
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// orphanGrace is how old an image file must be before it can be removed as an orphan: images are written before their
+// post is committed, so a younger file may belong to an upload in progress.
+const orphanGrace = time.Hour
+
+// janitor runs the background maintenance, once at start and then every `interval`, until Close is called: it purges
+// the photos deleted more than deletedRetention ago and the accounts deleted more than accountRetention ago, removes
+// the image files that belong to no post, and erases the exports finished more than exportRetention ago. Its queries
+// run in `ctx`, which Close cancels.
+func (rt *_router) janitor(ctx context.Context, interval time.Duration) {
+
+	defer close(rt.janitorDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		rt.cleanUp(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (rt *_router) cleanUp(ctx context.Context) {
+
+	now := time.Now()
+
+	purged, err := rt.db.PurgeDeletedPhotos(ctx, now.Add(-rt.deletedRetention))
+
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("janitor: error purging deleted photos")
+	} else if purged > 0 {
+		rt.baseLogger.Infof("janitor: purged %d deleted photos", purged)
+	}
+
+	erased, err := rt.db.PurgeDeactivatedUsers(ctx, now.Add(-rt.accountRetention))
+
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("janitor: error purging deleted accounts")
+	} else if erased > 0 {
+		rt.baseLogger.Infof("janitor: purged %d deleted accounts", erased)
+	}
+
+	removed, err := rt.db.RemoveOrphanImages(ctx, now.Add(-orphanGrace))
+
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("janitor: error removing orphan images")
+	} else if removed > 0 {
+		rt.baseLogger.Infof("janitor: removed %d orphan images", removed)
+	}
+
+	expired, err := rt.db.PurgeExpiredExports(ctx, now.Add(-rt.exportRetention))
+
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("janitor: error purging expired exports")
+	} else if expired > 0 {
+		rt.baseLogger.Infof("janitor: purged %d expired exports", expired)
+	}
+}
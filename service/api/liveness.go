@@ -1,17 +1,92 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
+	"os"
 
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
 	"github.com/julienschmidt/httprouter"
 )
 
-// liveness is an HTTP handler that checks the API server status. If the server cannot serve requests (e.g., some
-// resources are not ready), this should reply with HTTP Status 500. Otherwise, with HTTP Status 200
+// liveness is an HTTP handler that checks the API server status. It replies with HTTP Status 200 as long as the
+// process can serve requests: the resources it depends on are checked by readiness instead, so that an orchestrator
+// does not restart the server when, e.g., the database is unreachable.
 func (rt *_router) liveness(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	/* Example of liveness check:
-	if err := rt.DB.Ping(); err != nil {
+}
+
+// readiness is an HTTP handler that checks whether the server can handle requests: the database answers and is fully
+// migrated, and the photo store is writable and has enough free space. It replies with HTTP Status 200 if every check
+// passed, 503 otherwise, and the outcome of each check in a components.Readiness.
+func (rt *_router) readiness(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+
+	report := components.Readiness{Ready: true}
+
+	check := func(name string, err error) {
+		c := components.ReadinessCheck{Name: name, OK: err == nil}
+
+		if err != nil {
+			c.Error = err.Error()
+			report.Ready = false
+			rt.baseLogger.WithError(err).Warnf("readiness check %s failed", name)
+		}
+
+		report.Checks = append(report.Checks, c)
+	}
+
+	check("database", rt.db.Ping(r.Context()))
+
+	pending, err := rt.db.PendingMigrations(r.Context())
+
+	if err == nil && pending > 0 {
+		err = fmt.Errorf("%d migrations pending", pending)
+	}
+
+	check("migrations", err)
+
+	check("photo_store", photoStoreWritable())
+
+	if rt.minFreeSpace > 0 {
+
+		free, supported, err := freeSpace(database.PhotoDir)
+
+		if err == nil && free < rt.minFreeSpace {
+			err = fmt.Errorf("%d bytes free, %d required", free, rt.minFreeSpace)
+		}
+
+		if supported {
+			check("free_space", err)
+		}
+	}
+
+	res, err := report.ToJSON()
+
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
+		rt.baseLogger.WithError(err).Error("error encoding readiness")
 		return
-	}*/
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if !report.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	_, _ = w.Write(res)
+}
+
+// photoStoreWritable checks that a file can be created in the photo store, by creating and removing one.
+func photoStoreWritable() error {
+
+	f, err := os.CreateTemp(database.PhotoDir, ".readiness-*")
+
+	if err != nil {
+		return err
+	}
+
+	_ = f.Close()
+
+	return os.Remove(f.Name())
 }
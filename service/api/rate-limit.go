@@ -0,0 +1,133 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/ratelimit"
+	"github.com/julienschmidt/httprouter"
+)
+
+// RateLimits are the budgets of requests of each client, per route. A client is both its IP address and, if the
+// request has a valid token, its user: a request is rejected if either of them is over budget.
+type RateLimits struct {
+	// Default is the budget of each route missing from Routes; the zero value leaves them unlimited
+	Default ratelimit.Budget
+
+	// Routes are the budgets of specific routes, keyed by method and pattern (e.g., `PUT /session`)
+	Routes map[string]ratelimit.Budget
+
+	// TrustedProxies are the networks of the reverse proxies in front of the server: for requests coming from them,
+	// the client IP is taken from the X-Forwarded-For header
+	TrustedProxies []*net.IPNet
+}
+
+// unlimitedRoutes are never rate limited, whatever the budgets: they are the probes of the orchestrator, which polls
+// them from the same few addresses, and must not get a 429 (nor spend the budget of the clients behind the same IP).
+var unlimitedRoutes = map[string]bool{
+	"GET /liveness":  true,
+	"GET /readiness": true,
+}
+
+// budget returns the budget of `route` (method and pattern), not valid if unlimited.
+func (rl RateLimits) budget(route string) ratelimit.Budget {
+	if unlimitedRoutes[route] {
+		return ratelimit.Budget{}
+	}
+	if b, ok := rl.Routes[route]; ok {
+		return b
+	}
+	return rl.Default
+}
+
+// limitRate returns `handle` limited to the budget of `route` per client, replying with HTTP Status 429 and a
+// Retry-After header to the requests over budget. The budget is the one in the current settings.
+func (rs routes) limitRate(route string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		s := rs.current()
+
+		limiter := s.limiter(route)
+		if limiter == nil {
+			handle(w, r, ps)
+			return
+		}
+
+		keys := []string{"ip:" + clientIP(r, s.RateLimits.TrustedProxies)}
+
+		// only a valid token gets a bucket of its own: made-up ones would get a new, full one each
+		if token := r.Header.Get("Authorization"); token != "" {
+			userID, err := rs.authenticate(r.Context(), token)
+			if err != nil {
+				rs.logger.WithError(err).Error("error authenticating the user for the rate limit")
+			} else if userID != "" {
+				keys = append(keys, "user:"+userID)
+			}
+		}
+
+		// tokens are taken from both buckets, or from none
+		ok, retryAfter := limiter.Allow(keys...)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(components.TooManyRequestsError))
+			return
+		}
+
+		handle(w, r, ps)
+	}
+}
+
+// clientIP returns the IP address of the client of `r`. If the request comes from one of `trusted`, the addresses in
+// X-Forwarded-For are walked from the last (the one appended by the nearest proxy) to the first: the client is the
+// first one that is not a trusted proxy.
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if !isTrusted(ip, trusted) {
+		return ip
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(header, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(addr))
+		}
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if net.ParseIP(forwarded[i]) == nil {
+			// a malformed entry cannot be trusted, nor anything before it
+			return ip
+		}
+
+		ip = forwarded[i]
+
+		if !isTrusted(ip, trusted) {
+			return ip
+		}
+	}
+
+	return ip
+}
+
+func isTrusted(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range trusted {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,7 @@
+package api
+
+// Reconfigure applies `s` to the requests that come next. Clients keep their state in the rate limits whose budget is
+// unchanged, and start afresh in the others.
+func (rt *_router) Reconfigure(s Settings) {
+	rt.router.reconfigure(s)
+}
@@ -7,15 +7,21 @@ Each value here should be assumed valid only per request only, with some excepti
 package reqcontext
 
 import (
-	"github.com/gofrs/uuid"
+	"context"
+
 	"github.com/sirupsen/logrus"
 )
 
 // RequestContext is the context of the request, for request-dependent parameters
 type RequestContext struct {
-	// ReqUUID is the request unique ID
-	ReqUUID uuid.UUID
+	// ReqID is the request unique ID: the X-Request-ID header of the request if valid, else the trace ID of its
+	// traceparent header, else a new UUID. It is sent back in the X-Request-ID header, and in the JSON error bodies.
+	ReqID string
 
 	// Logger is a custom field logger for the request
 	Logger logrus.FieldLogger
+
+	// Context is the context of the HTTP request, done when the client goes away or the server shuts down. It should be
+	// passed to every database call made for the request.
+	Context context.Context
 }
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/tracing"
+	"github.com/gofrs/uuid"
+)
+
+// requestIDHeader is the header with the ID of a request, both in the request (optional) and in the response
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the maximum length of an incoming request ID, longer ones are replaced
+const maxRequestIDLength = 128
+
+// requestID returns the ID of the request `r`, see reqcontext.RequestContext.ReqID, and the span of the caller from
+// its traceparent header (not valid if missing).
+func requestID(r *http.Request) (reqID string, parent tracing.SpanContext, err error) {
+	parent, _ = tracing.ParseTraceparent(r.Header.Get("traceparent"))
+
+	if reqID = r.Header.Get(requestIDHeader); validRequestID(reqID) {
+		return reqID, parent, nil
+	}
+
+	if parent.IsValid() {
+		return parent.TraceID.String(), parent, nil
+	}
+
+	reqUUID, err := uuid.NewV4()
+	if err != nil {
+		return "", parent, err
+	}
+
+	return reqUUID.String(), parent, nil
+}
+
+// validRequestID returns whether `reqID` can be used as is: the ID ends up in logs, headers and JSON bodies, so only a
+// safe subset of ASCII is accepted.
+func validRequestID(reqID string) bool {
+	if reqID == "" || len(reqID) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range reqID {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// errorWriter holds back the body of error responses (status 4xx and 5xx) until flush, which adds the request ID to
+// the JSON errors (e.g., components.NotFoundError). Other bodies go through untouched.
+type errorWriter struct {
+	http.ResponseWriter
+	reqID string
+
+	status int
+	body   bytes.Buffer
+}
+
+func (ew *errorWriter) WriteHeader(status int) {
+	if ew.status != 0 {
+		return
+	}
+
+	ew.status = status
+
+	if status < http.StatusBadRequest {
+		ew.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (ew *errorWriter) Write(p []byte) (int, error) {
+	if ew.status == 0 {
+		ew.WriteHeader(http.StatusOK)
+	}
+
+	if ew.status < http.StatusBadRequest {
+		return ew.ResponseWriter.Write(p)
+	}
+
+	return ew.body.Write(p)
+}
+
+// flush sends the error response held back, if any.
+func (ew *errorWriter) flush() {
+	if ew.status < http.StatusBadRequest {
+		return
+	}
+
+	body := ew.body.Bytes()
+	trimmed := bytes.TrimSpace(body)
+
+	if len(trimmed) > 1 && trimmed[0] == '{' && trimmed[len(trimmed)-1] == '}' {
+		// the ID is validated, or made of hex digits and dashes, so it needs no escaping
+		field := `"request_id": "` + ew.reqID + `"`
+
+		if len(bytes.TrimSpace(trimmed[1:len(trimmed)-1])) > 0 {
+			field = ", " + field
+		}
+
+		body = append(append(append([]byte{}, trimmed[:len(trimmed)-1]...), field...), '}')
+		ew.Header().Del("Content-Length")
+	}
+
+	ew.ResponseWriter.WriteHeader(ew.status)
+	_, _ = ew.ResponseWriter.Write(body)
+}
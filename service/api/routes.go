@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/metrics"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/ratelimit"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/tracing"
+	"github.com/felixge/httpsnoop"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	requestsTotal = metrics.NewCounter("http_requests_total",
+		"Requests handled by the API, by route pattern and status code.", "method", "route", "code")
+	requestDuration = metrics.NewHistogram("http_request_duration_seconds",
+		"Duration of the requests handled by the API, by route pattern.", metrics.DurationBuckets, "method", "route")
+	photoBytesRead = metrics.NewCounter("photo_store_read_bytes_total",
+		"Bytes of the images read from the photo store.")
+)
+
+// unmatchedRoute is the route of the requests that match none, so that random paths do not make a series each
+const unmatchedRoute = "unmatched"
+
+// routes is the httprouter.Router of the API, which assigns an ID and a span to every request, measures it under the pattern of
+// its route (e.g., `/users/:user_name`) rather than its path, limits its rate, and writes it to the access log. Only the
+// methods used by Handler are measured.
+type routes struct {
+	*httprouter.Router
+
+	logger logrus.FieldLogger
+
+	// authenticate returns the user of a token, for the rate limits
+	authenticate func(ctx context.Context, token string) (userID string, err error)
+
+	// settings holds the current *settings, replaced by reconfigure
+	settings *atomic.Value
+}
+
+// settings are the Settings in use, with the rate limiters of the routes (by method and pattern, e.g. `PUT /session`),
+// created on their first request; nil for the unlimited ones.
+type settings struct {
+	Settings
+
+	mu       sync.Mutex
+	limiters map[string]*ratelimit.Limiter
+}
+
+func newRoutes(logger logrus.FieldLogger, authenticate func(context.Context, string) (string, error), s Settings) routes {
+	rs := routes{Router: httprouter.New(), logger: logger, authenticate: authenticate, settings: &atomic.Value{}}
+	rs.settings.Store(&settings{Settings: s, limiters: map[string]*ratelimit.Limiter{}})
+
+	rs.NotFound = rs.measure(unmatchedRoute, http.NotFoundHandler())
+	rs.MethodNotAllowed = rs.measure(unmatchedRoute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// as httprouter does by default, after setting the Allow header
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}))
+
+	return rs
+}
+
+func (rs routes) GET(path string, handle httprouter.Handle) {
+	rs.Handle(http.MethodGet, path, handle)
+}
+
+func (rs routes) POST(path string, handle httprouter.Handle) {
+	rs.Handle(http.MethodPost, path, handle)
+}
+
+func (rs routes) PUT(path string, handle httprouter.Handle) {
+	rs.Handle(http.MethodPut, path, handle)
+}
+
+func (rs routes) PATCH(path string, handle httprouter.Handle) {
+	rs.Handle(http.MethodPatch, path, handle)
+}
+
+func (rs routes) DELETE(path string, handle httprouter.Handle) {
+	rs.Handle(http.MethodDelete, path, handle)
+}
+
+func (rs routes) Handle(method, path string, handle httprouter.Handle) {
+	handle = rs.limitRate(method+" "+path, handle)
+
+	rs.Router.Handle(method, path, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		rs.measure(path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handle(w, r, ps)
+		})).ServeHTTP(w, r)
+	})
+}
+
+// measure returns `h`, recording its requests under `route`. Every request gets its ID (see requestID) and a span,
+// child of the one of the caller if it sent a traceparent.
+func (rs routes) measure(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqID, parent, err := requestID(r)
+		if err != nil {
+			rs.logger.WithError(err).Error("can't generate a request UUID")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		ctx := tracing.WithRequestID(r.Context(), reqID)
+		if parent.IsValid() {
+			ctx = tracing.ContextWithRemoteParent(ctx, parent)
+		}
+
+		ctx, span := tracing.Start(ctx, r.Method+" "+route)
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("request.id", reqID)
+
+		w.Header().Set(requestIDHeader, reqID)
+
+		m := httpsnoop.CaptureMetrics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ew := &errorWriter{ResponseWriter: w, reqID: reqID}
+			h.ServeHTTP(ew, r)
+			ew.flush()
+		}), w, r.WithContext(ctx))
+
+		span.SetAttribute("http.status_code", m.Code)
+		span.End()
+
+		requestsTotal.Add(1, r.Method, route, strconv.Itoa(m.Code))
+		requestDuration.Observe(m.Duration.Seconds(), r.Method, route)
+
+		if rs.current().AccessLog {
+			rs.logger.WithFields(logrus.Fields{
+				"reqid":     reqID,
+				"remote-ip": r.RemoteAddr,
+				"method":    r.Method,
+				"route":     route,
+				"status":    m.Code,
+				"bytes":     m.Written,
+				"duration":  m.Duration.Seconds(),
+			}).Info("request")
+		}
+	})
+}
+
+// current returns the settings in use.
+func (rs routes) current() *settings {
+	return rs.settings.Load().(*settings)
+}
+
+// reconfigure replaces the settings in use with `s`. The rate limiters of the routes whose budget is unchanged are kept,
+// with the state of their clients.
+func (rs routes) reconfigure(s Settings) {
+	next := &settings{Settings: s, limiters: map[string]*ratelimit.Limiter{}}
+
+	prev := rs.current()
+	prev.mu.Lock()
+	for route, limiter := range prev.limiters {
+		if limiter == nil {
+			continue
+		}
+		if budget := s.RateLimits.budget(route); budget == limiter.Budget() {
+			next.limiters[route] = limiter
+		}
+	}
+	prev.mu.Unlock()
+
+	rs.settings.Store(next)
+}
+
+// limiter returns the rate limiter of `route`, nil if unlimited.
+func (s *settings) limiter(route string) *ratelimit.Limiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limiter, found := s.limiters[route]
+	if !found {
+		if budget := s.RateLimits.budget(route); budget.Valid() {
+			limiter = ratelimit.New(budget)
+		}
+		s.limiters[route] = limiter
+	}
+	return limiter
+}
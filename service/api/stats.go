@@ -0,0 +1,23 @@
+package api
+
+import (
+	"encoding/base64"
+	"expvar"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+)
+
+// stats counts the activity of the API, published by expvar as "api": "requests" (handled by wrap) and
+// "upload_bytes" (of the images uploaded).
+var stats = expvar.NewMap("api")
+
+// uploadSize returns the (approximate, ignoring the padding) size of the images of `photo`, once decoded.
+func uploadSize(photo components.Photo) int64 {
+	size := base64.StdEncoding.DecodedLen(len(photo.Data))
+
+	for _, m := range photo.Media {
+		size += base64.StdEncoding.DecodedLen(len(m.Data))
+	}
+
+	return int64(size)
+}
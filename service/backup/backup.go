@@ -0,0 +1,274 @@
+/*
+Package backup takes consistent snapshots of the SQLite database and of the photo store into a single tar archive, and
+restores them.
+
+An archive holds the database (`decaf.db`), copied with SQLite's online backup API so that the server can keep running,
+the images of the photo directory (`photos/<name>`) and, last, a `manifest.json` listing size and SHA-256 checksum of
+every other entry. The database is copied first: images published while the backup runs may end up in the archive
+without their post, and are then removed as orphans by the janitor after a restore.
+
+Restore verifies the whole archive (checksums, SQLite integrity check) before touching anything, and keeps the
+replaced database and photo directory next to the new ones, with a `.pre-restore` suffix. The server must be stopped
+while restoring.
+*/
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// FormatVersion is the version of the archive layout written by Create
+const FormatVersion = 1
+
+// Names of the entries of an archive
+const (
+	manifestName = "manifest.json"
+	databaseName = "decaf.db"
+	photosPrefix = "photos/"
+)
+
+// Manifest describes the content of an archive
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Files     []File    `json:"files"`
+}
+
+// File is an entry of an archive
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Create writes to `w` an archive with a snapshot of the SQLite database `db` and the images in `photoDir`. Staged
+// images (of uploads not committed yet) are left out.
+func Create(ctx context.Context, db *sql.DB, photoDir string, w io.Writer) (*Manifest, error) {
+
+	snapshot, err := snapshotDatabase(ctx, db)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = os.Remove(snapshot)
+	}()
+
+	manifest := &Manifest{
+		Version:   FormatVersion,
+		CreatedAt: time.Now().UTC(),
+		Files:     []File{},
+	}
+
+	tw := tar.NewWriter(w)
+
+	file, err := addFile(tw, databaseName, snapshot)
+
+	if err != nil {
+		return nil, err
+	}
+
+	manifest.Files = append(manifest.Files, file)
+
+	entries, err := os.ReadDir(photoDir)
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error listing photos: %w", err)
+	}
+
+	for _, e := range entries {
+
+		if !e.Type().IsRegular() || strings.HasSuffix(e.Name(), ".staged.png") {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		file, err = addFile(tw, photosPrefix+e.Name(), filepath.Join(photoDir, e.Name()))
+
+		// purged after being listed
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "	")
+
+	if err != nil {
+		return nil, fmt.Errorf("error converting manifest to JSON: %w", err)
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    manifestName,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: manifest.CreatedAt,
+	})
+
+	if err == nil {
+		_, err = tw.Write(data)
+	}
+
+	if err == nil {
+		err = tw.Close()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error writing manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// snapshotDatabase copies `db` to a new temporary file, whose path it returns, with the online backup API: the copy
+// is a consistent snapshot even while other connections write.
+func snapshotDatabase(ctx context.Context, db *sql.DB) (path string, err error) {
+
+	tmp, err := os.CreateTemp("", "decaf-snapshot-*.db")
+
+	if err != nil {
+		return "", fmt.Errorf("error creating snapshot file: %w", err)
+	}
+
+	path = tmp.Name()
+	_ = tmp.Close()
+
+	defer func() {
+		if err != nil {
+			_ = os.Remove(path)
+		}
+	}()
+
+	dst, err := sql.Open("sqlite3", path)
+
+	if err != nil {
+		return "", fmt.Errorf("error opening snapshot: %w", err)
+	}
+
+	defer func() {
+		_ = dst.Close()
+	}()
+
+	srcConn, err := db.Conn(ctx)
+
+	if err != nil {
+		return "", fmt.Errorf("error connecting to database: %w", err)
+	}
+
+	defer func() {
+		_ = srcConn.Close()
+	}()
+
+	dstConn, err := dst.Conn(ctx)
+
+	if err != nil {
+		return "", fmt.Errorf("error connecting to snapshot: %w", err)
+	}
+
+	defer func() {
+		_ = dstConn.Close()
+	}()
+
+	err = dstConn.Raw(func(dstDriverConn interface{}) error {
+		return srcConn.Raw(func(srcDriverConn interface{}) error {
+
+			dstSQLite, ok := dstDriverConn.(*sqlite3.SQLiteConn)
+			srcSQLite, ok2 := srcDriverConn.(*sqlite3.SQLiteConn)
+
+			if !ok || !ok2 {
+				return errors.New("backups need a sqlite3 database")
+			}
+
+			bk, err := dstSQLite.Backup("main", srcSQLite, "main")
+
+			if err != nil {
+				return err
+			}
+
+			// a single step copies everything while holding a read transaction on the source
+			_, err = bk.Step(-1)
+
+			if err != nil {
+				_ = bk.Finish()
+				return err
+			}
+
+			return bk.Finish()
+		})
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("error copying database: %w", err)
+	}
+
+	return path, nil
+}
+
+// addFile writes the file at `path` to `tw` as `name`, returning its manifest entry.
+func addFile(tw *tar.Writer, name string, path string) (file File, err error) {
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return File{}, fmt.Errorf("error opening %s: %w", name, err)
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	// the size of the opened file, even if the path has been replaced in the meantime
+	info, err := f.Stat()
+
+	if err != nil {
+		return File{}, fmt.Errorf("error reading %s: %w", name, err)
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	})
+
+	if err != nil {
+		return File{}, fmt.Errorf("error writing header of %s: %w", name, err)
+	}
+
+	h := sha256.New()
+
+	_, err = io.Copy(io.MultiWriter(tw, h), io.LimitReader(f, info.Size()))
+
+	if err != nil {
+		return File{}, fmt.Errorf("error writing %s: %w", name, err)
+	}
+
+	return File{
+		Name:   name,
+		Size:   info.Size(),
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
@@ -0,0 +1,340 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// entry is an entry of an archive, read back for the tests to tamper with
+type entry struct {
+	hdr  tar.Header
+	data []byte
+}
+
+// fixture is a database and a photo directory to back up, and the paths to restore them to
+type fixture struct {
+	db       *sql.DB
+	photoDir string
+
+	dbPath       string
+	restoreDir   string
+	restorePhoto string
+}
+
+func newFixture(t *testing.T) fixture {
+	t.Helper()
+
+	dir := t.TempDir()
+	fx := fixture{
+		photoDir:     filepath.Join(dir, "photos"),
+		dbPath:       filepath.Join(dir, "restored", "decaf.db"),
+		restoreDir:   filepath.Join(dir, "restored"),
+		restorePhoto: filepath.Join(dir, "restored", "photos"),
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "decaf.db"))
+	if err != nil {
+		t.Fatalf("opening the database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	fx.db = db
+
+	_, err = db.Exec(`CREATE TABLE users (name TEXT PRIMARY KEY); INSERT INTO users VALUES ('alice'), ('bob')`)
+	if err != nil {
+		t.Fatalf("filling the database: %v", err)
+	}
+
+	writeFiles(t, fx.photoDir, map[string]string{
+		"alice-1.png":       "image of alice",
+		"bob-1.png":         "image of bob",
+		"bob-2.staged.png":  "upload in progress",
+		"empty-caption.png": "",
+	})
+
+	// what is in place before the restore
+	writeFiles(t, fx.restoreDir, map[string]string{"decaf.db": "the database before the restore"})
+	writeFiles(t, fx.restorePhoto, map[string]string{"old.png": "image before the restore"})
+
+	return fx
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatalf("creating %s: %v", dir, err)
+	}
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+}
+
+// listFiles returns the contents of the regular files in `dir`, by name.
+func listFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("listing %s: %v", dir, err)
+	}
+	files := map[string]string{}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatalf("reading %s: %v", e.Name(), err)
+		}
+		files[e.Name()] = string(data)
+	}
+	return files
+}
+
+func (fx fixture) create(t *testing.T) (*Manifest, []byte) {
+	t.Helper()
+
+	var archive bytes.Buffer
+	manifest, err := Create(context.Background(), fx.db, fx.photoDir, &archive)
+	if err != nil {
+		t.Fatalf("creating the archive: %v", err)
+	}
+	return manifest, archive.Bytes()
+}
+
+func readEntries(t *testing.T, archive []byte) []entry {
+	t.Helper()
+
+	var entries []entry
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("reading the archive: %v", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("reading %s: %v", hdr.Name, err)
+		}
+		entries = append(entries, entry{hdr: *hdr, data: data})
+	}
+}
+
+func writeEntries(t *testing.T, entries []entry) []byte {
+	t.Helper()
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, e := range entries {
+		hdr := e.hdr
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.data))
+		}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatalf("writing the header of %s: %v", hdr.Name, err)
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			t.Fatalf("writing %s: %v", hdr.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing the archive: %v", err)
+	}
+	return archive.Bytes()
+}
+
+// checkUntouched checks that a failed restore left everything as it was, with nothing staged.
+func (fx fixture) checkUntouched(t *testing.T) {
+	t.Helper()
+
+	got := listFiles(t, fx.restoreDir)
+	if len(got) != 1 || got["decaf.db"] != "the database before the restore" {
+		t.Errorf("files next to the database after a failed restore: %v", got)
+	}
+	got = listFiles(t, fx.restorePhoto)
+	if len(got) != 1 || got["old.png"] != "image before the restore" {
+		t.Errorf("photos after a failed restore: %v", got)
+	}
+	if _, err := os.Stat(fx.restorePhoto + restoringSuffix); !os.IsNotExist(err) {
+		t.Errorf("the staged photos are left behind: %v", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	fx := newFixture(t)
+	created, archive := fx.create(t)
+
+	var names []string
+	for _, f := range created.Files {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, ","); got != "decaf.db,photos/alice-1.png,photos/bob-1.png,photos/empty-caption.png" {
+		t.Errorf("files in the manifest: %s", got)
+	}
+	if created.Version != FormatVersion {
+		t.Errorf("version %d", created.Version)
+	}
+
+	restored, err := Restore(bytes.NewReader(archive), fx.dbPath, fx.restorePhoto)
+	if err != nil {
+		t.Fatalf("restoring: %v", err)
+	}
+	if !created.CreatedAt.Equal(restored.CreatedAt) || len(restored.Files) != len(created.Files) {
+		t.Errorf("restored manifest %+v, want %+v", restored, created)
+	}
+
+	db, err := sql.Open("sqlite3", fx.dbPath)
+	if err != nil {
+		t.Fatalf("opening the restored database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	var users int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&users); err != nil || users != 2 {
+		t.Errorf("%d users in the restored database, %v", users, err)
+	}
+
+	photos := listFiles(t, fx.restorePhoto)
+	want := map[string]string{"alice-1.png": "image of alice", "bob-1.png": "image of bob", "empty-caption.png": ""}
+	if len(photos) != len(want) {
+		t.Errorf("restored photos %v, want %v", photos, want)
+	}
+	for name, content := range want {
+		if photos[name] != content {
+			t.Errorf("restored %s is %q, want %q", name, photos[name], content)
+		}
+	}
+
+	// the replaced database and photos are kept aside
+	previous, err := os.ReadFile(fx.dbPath + preRestoreSuffix)
+	if err != nil || string(previous) != "the database before the restore" {
+		t.Errorf("database kept aside: %q, %v", previous, err)
+	}
+	if got := listFiles(t, fx.restorePhoto+preRestoreSuffix); got["old.png"] != "image before the restore" {
+		t.Errorf("photos kept aside: %v", got)
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	fx := newFixture(t)
+	_, archive := fx.create(t)
+
+	tamper := func(name string, change func(e *entry)) []byte {
+		entries := readEntries(t, archive)
+		for i := range entries {
+			if entries[i].hdr.Name == name {
+				change(&entries[i])
+			}
+		}
+		return writeEntries(t, entries)
+	}
+
+	tests := []struct {
+		name    string
+		archive []byte
+		err     string
+	}{
+		{"photo changed, same size", tamper("photos/bob-1.png", func(e *entry) {
+			e.data = []byte("image of BOB")
+		}), "checksum mismatch for photos/bob-1.png"},
+		{"photo truncated", tamper("photos/alice-1.png", func(e *entry) {
+			e.data = e.data[:5]
+		}), "checksum mismatch for photos/alice-1.png"},
+		{"database changed", tamper("decaf.db", func(e *entry) {
+			e.data = append([]byte{}, e.data...)
+			e.data[len(e.data)-1] ^= 0xff
+		}), "checksum mismatch for decaf.db"},
+		{"manifest changed", tamper("manifest.json", func(e *entry) {
+			var m Manifest
+			if err := json.Unmarshal(e.data, &m); err != nil {
+				t.Fatalf("decoding the manifest: %v", err)
+			}
+			m.Files[1].SHA256 = strings.Repeat("0", 64)
+			e.data, _ = json.Marshal(m)
+		}), "checksum mismatch for photos/alice-1.png"},
+		{"photo missing", tamper("photos/bob-1.png", func(e *entry) {
+			e.hdr.Name = "photos/carol-1.png"
+		}), "photos/bob-1.png missing from the archive"},
+		{"manifest missing", archive[:bytes.Index(archive, []byte("manifest.json"))],
+			"manifest missing, the archive is incomplete"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Restore(bytes.NewReader(tt.archive), fx.dbPath, fx.restorePhoto)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("restoring: %v, want %q", err, tt.err)
+			}
+			fx.checkUntouched(t)
+		})
+	}
+}
+
+func TestRestoreRejectsPathTraversal(t *testing.T) {
+	fx := newFixture(t)
+	_, archive := fx.create(t)
+	entries := readEntries(t, archive)
+
+	// an entry is appended after the database, before the manifest
+	with := func(t *testing.T, hdr tar.Header) []byte {
+		if hdr.Typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+		hdr.Mode = 0644
+		evil := entry{hdr: hdr, data: []byte("evil")}
+		if hdr.Typeflag != tar.TypeReg {
+			evil.data = nil
+		}
+		return writeEntries(t, append([]entry{entries[0], evil}, entries[1:]...))
+	}
+
+	tests := []struct {
+		name string
+		hdr  tar.Header
+		err  string
+	}{
+		{"parent of the photos", tar.Header{Name: "photos/../evil.png"}, "invalid photo name"},
+		{"outside the restore", tar.Header{Name: "photos/../../evil.png"}, "invalid photo name"},
+		{"subdirectory", tar.Header{Name: "photos/sub/evil.png"}, "invalid photo name"},
+		{"dot", tar.Header{Name: "photos/."}, "invalid photo name"},
+		{"dot dot", tar.Header{Name: "photos/.."}, "invalid photo name"},
+		{"no name", tar.Header{Name: "photos/", Typeflag: tar.TypeDir}, "invalid photo name"},
+		{"absolute", tar.Header{Name: "/tmp/evil.png"}, "unexpected entry"},
+		{"relative", tar.Header{Name: "../evil.png"}, "unexpected entry"},
+		{"symlink", tar.Header{Name: "photos/evil.png", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+			"not a regular file"},
+		{"hard link", tar.Header{Name: "photos/evil.png", Typeflag: tar.TypeLink, Linkname: "decaf.db"},
+			"not a regular file"},
+		{"duplicate", tar.Header{Name: "decaf.db"}, "duplicate entry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Restore(bytes.NewReader(with(t, tt.hdr)), fx.dbPath, fx.restorePhoto)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("restoring: %v, want %q", err, tt.err)
+			}
+			fx.checkUntouched(t)
+
+			for _, dir := range []string{filepath.Dir(fx.restoreDir), fx.restoreDir, "/tmp"} {
+				if _, err := os.Lstat(filepath.Join(dir, "evil.png")); !os.IsNotExist(err) {
+					t.Errorf("evil.png written to %s", dir)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package backup
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxManifestSize bounds the manifest read in memory, a generous limit for a few hundred thousand photos
+const maxManifestSize = 64 << 20
+
+// Suffixes of the files and directories that Restore works on next to the target ones
+const (
+	restoringSuffix  = ".restoring"
+	preRestoreSuffix = ".pre-restore"
+)
+
+// Restore extracts the archive in `r` and, once verified, puts it in place of the SQLite database at `dbPath` and of
+// the photo directory `photoDir`. The replaced ones are renamed with a `.pre-restore` suffix (replacing those of an
+// earlier restore). Nothing is replaced if the archive is incomplete, corrupted or does not match its manifest.
+func Restore(r io.Reader, dbPath string, photoDir string) (*Manifest, error) {
+
+	dbStaging := dbPath + restoringSuffix
+	photoStaging := filepath.Clean(photoDir) + restoringSuffix
+
+	cleanUp := func() {
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			_ = os.Remove(dbStaging + suffix)
+		}
+		_ = os.RemoveAll(photoStaging)
+	}
+
+	cleanUp()
+
+	manifest, err := extract(r, dbStaging, photoStaging)
+
+	if err == nil {
+		err = checkIntegrity(dbStaging)
+	}
+
+	if err != nil {
+		cleanUp()
+		return nil, err
+	}
+
+	err = swap(dbStaging, dbPath, photoStaging, filepath.Clean(photoDir))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// extract writes the database of the archive in `r` to `dbStaging` and its images to the directory `photoStaging`,
+// checking every entry against the manifest.
+func extract(r io.Reader, dbStaging string, photoStaging string) (*Manifest, error) {
+
+	err := os.MkdirAll(photoStaging, 0755)
+
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s: %w", photoStaging, err)
+	}
+
+	tr := tar.NewReader(r)
+
+	var manifest *Manifest
+	extracted := map[string]File{}
+
+	for {
+
+		hdr, err := tr.Next()
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive: %w", err)
+		}
+
+		if manifest != nil {
+			return nil, fmt.Errorf("unexpected entry %s after the manifest", hdr.Name)
+		}
+
+		if hdr.Name == manifestName {
+
+			data, err := io.ReadAll(io.LimitReader(tr, maxManifestSize+1))
+
+			if err != nil {
+				return nil, fmt.Errorf("error reading manifest: %w", err)
+			}
+
+			if len(data) > maxManifestSize {
+				return nil, errors.New("manifest too large")
+			}
+
+			manifest = &Manifest{}
+
+			err = json.Unmarshal(data, manifest)
+
+			if err != nil {
+				return nil, fmt.Errorf("error parsing manifest: %w", err)
+			}
+
+			continue
+		}
+
+		var path string
+
+		switch {
+		case hdr.Name == databaseName:
+			path = dbStaging
+		case strings.HasPrefix(hdr.Name, photosPrefix):
+
+			name := strings.TrimPrefix(hdr.Name, photosPrefix)
+
+			if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+				return nil, fmt.Errorf("invalid photo name %q", hdr.Name)
+			}
+
+			path = filepath.Join(photoStaging, name)
+		default:
+			return nil, fmt.Errorf("unexpected entry %s", hdr.Name)
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("entry %s is not a regular file", hdr.Name)
+		}
+
+		if _, ok := extracted[hdr.Name]; ok {
+			return nil, fmt.Errorf("duplicate entry %s", hdr.Name)
+		}
+
+		file, err := extractFile(tr, hdr.Name, path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		extracted[hdr.Name] = file
+	}
+
+	if manifest == nil {
+		return nil, errors.New("manifest missing, the archive is incomplete")
+	}
+
+	if manifest.Version != FormatVersion {
+		return nil, fmt.Errorf("unsupported archive version %d", manifest.Version)
+	}
+
+	if _, ok := extracted[databaseName]; !ok {
+		return nil, errors.New("database missing from the archive")
+	}
+
+	if len(manifest.Files) != len(extracted) {
+		return nil, fmt.Errorf("the manifest lists %d files, the archive has %d", len(manifest.Files), len(extracted))
+	}
+
+	for _, want := range manifest.Files {
+
+		got, ok := extracted[want.Name]
+
+		if !ok {
+			return nil, fmt.Errorf("%s missing from the archive", want.Name)
+		}
+
+		if got != want {
+			return nil, fmt.Errorf("checksum mismatch for %s", want.Name)
+		}
+	}
+
+	return manifest, nil
+}
+
+func extractFile(r io.Reader, name string, path string) (file File, err error) {
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+
+	if err != nil {
+		return File{}, fmt.Errorf("error creating %s: %w", path, err)
+	}
+
+	h := sha256.New()
+
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+
+	if err != nil {
+		_ = f.Close()
+		return File{}, fmt.Errorf("error extracting %s: %w", name, err)
+	}
+
+	err = f.Close()
+
+	if err != nil {
+		return File{}, fmt.Errorf("error extracting %s: %w", name, err)
+	}
+
+	return File{
+		Name:   name,
+		Size:   size,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// checkIntegrity runs SQLite's integrity check on the database at `path`.
+func checkIntegrity(path string) error {
+
+	db, err := sql.Open("sqlite3", path)
+
+	if err != nil {
+		return fmt.Errorf("error opening restored database: %w", err)
+	}
+
+	defer func() {
+		_ = db.Close()
+	}()
+
+	var result string
+
+	err = db.QueryRow(`PRAGMA integrity_check`).Scan(&result)
+
+	if err != nil {
+		return fmt.Errorf("error checking restored database: %w", err)
+	}
+
+	if result != "ok" {
+		return fmt.Errorf("restored database is corrupted: %s", result)
+	}
+
+	return nil
+}
+
+// swap moves the current database (with its WAL files) and photo directory aside, and the restored ones in place.
+func swap(dbStaging string, dbPath string, photoStaging string, photoDir string) error {
+
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+
+		err := os.Rename(dbPath+suffix, dbPath+suffix+preRestoreSuffix)
+
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error moving aside %s: %w", dbPath+suffix, err)
+		}
+
+		// a stale WAL file of an earlier restore would be replayed on the wrong database
+		if os.IsNotExist(err) && suffix != "" {
+			_ = os.Remove(dbPath + suffix + preRestoreSuffix)
+		}
+	}
+
+	err := os.Rename(dbStaging, dbPath)
+
+	if err != nil {
+		return fmt.Errorf("error moving restored database in place: %w", err)
+	}
+
+	err = os.RemoveAll(photoDir + preRestoreSuffix)
+
+	if err != nil {
+		return fmt.Errorf("error removing photos of an earlier restore: %w", err)
+	}
+
+	err = os.Rename(photoDir, photoDir+preRestoreSuffix)
+
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error moving aside %s: %w", photoDir, err)
+	}
+
+	err = os.Rename(photoStaging, photoDir)
+
+	if err != nil {
+		return fmt.Errorf("error moving restored photos in place: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package components
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	EntityHashtag string = "hashtag"
+	EntityMention string = "mention"
+)
+
+// Entity is a structured fragment of a free-text field (a post description or a comment body), such as a #hashtag or
+// an @mention. Start and End are offsets in Unicode code points (not bytes) into the original text, End is exclusive,
+// and the range includes the leading '#' or '@' so the UI can replace it with a link as-is.
+type Entity struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// MaxTagLength is the maximum number of characters of a hashtag (without the leading '#').
+const MaxTagLength = 64
+
+// isEntityChar reports whether r may appear inside a hashtag or a mention.
+func isEntityChar(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// NormalizeTag returns the canonical (lower case, without '#') form of a hashtag, used both for indexing and lookups.
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+// ValidTag reports whether tag (without the leading '#') is a well-formed hashtag.
+func ValidTag(tag string) bool {
+	runes := []rune(tag)
+
+	if len(runes) == 0 || len(runes) > MaxTagLength {
+		return false
+	}
+
+	hasLetter := false
+
+	for _, r := range runes {
+		if !isEntityChar(r) {
+			return false
+		}
+		if !unicode.IsDigit(r) {
+			hasLetter = true
+		}
+	}
+
+	// "#1" is a number, not a tag
+	return hasLetter
+}
+
+// ValidUsername reports whether name follows the username rules of the API (see doc/api.yaml).
+func ValidUsername(name string) bool {
+	if len(name) < 3 || len(name) > 32 {
+		return false
+	}
+
+	for i, r := range name {
+		isAlpha := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if i == 0 && !isAlpha {
+			return false
+		}
+		if !isAlpha && !(r >= '0' && r <= '9') && r != '_' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseEntities scans text for #hashtags and @mentions. An entity starts only at the beginning of the text or after a
+// character that cannot be part of an entity, so e-mail addresses and "C#" are not matched. Hashtag values are
+// normalized with NormalizeTag, mention values are returned as written. Mentions are not checked against existing users:
+// that is the database's job.
+func ParseEntities(text string) []Entity {
+	entities := []Entity{}
+	runes := []rune(text)
+
+	for i := 0; i < len(runes); i++ {
+
+		if runes[i] != '#' && runes[i] != '@' {
+			continue
+		}
+
+		if i > 0 && (isEntityChar(runes[i-1]) || runes[i-1] == '#' || runes[i-1] == '@') {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && isEntityChar(runes[end]) {
+			end++
+		}
+
+		value := string(runes[i+1 : end])
+
+		switch {
+		case runes[i] == '#' && ValidTag(value):
+			entities = append(entities, Entity{Type: EntityHashtag, Value: NormalizeTag(value), Start: i, End: end})
+		case runes[i] == '@' && ValidUsername(value):
+			entities = append(entities, Entity{Type: EntityMention, Value: value, Start: i, End: end})
+		}
+
+		i = end - 1
+	}
+
+	return entities
+}
+
+// Tags returns the distinct hashtags found by ParseEntities.
+func Tags(entities []Entity) []string {
+	return distinctValues(entities, EntityHashtag)
+}
+
+// Mentions returns the distinct mentioned usernames found by ParseEntities.
+func Mentions(entities []Entity) []string {
+	return distinctValues(entities, EntityMention)
+}
+
+func distinctValues(entities []Entity, kind string) []string {
+	seen := map[string]bool{}
+	values := []string{}
+
+	for _, e := range entities {
+		if e.Type == kind && !seen[e.Value] {
+			seen[e.Value] = true
+			values = append(values, e.Value)
+		}
+	}
+
+	return values
+}
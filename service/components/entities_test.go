@@ -0,0 +1,77 @@
+package components
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEntities(t *testing.T) {
+	tag := func(value string, start, end int) Entity {
+		return Entity{Type: EntityHashtag, Value: value, Start: start, End: end}
+	}
+	mention := func(value string, start, end int) Entity {
+		return Entity{Type: EntityMention, Value: value, Start: start, End: end}
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want []Entity
+	}{
+		{"empty", "", []Entity{}},
+		{"none", "just coffee", []Entity{}},
+		{"hashtag and mention", "#coffee with @alice", []Entity{tag("coffee", 0, 7), mention("alice", 13, 19)}},
+		{"e-mail", "write to bob@example.com", []Entity{}},
+		{"e-mail and mention", "alice@example.com or @alice", []Entity{mention("alice", 21, 27)}},
+		{"language names", "C# and F# are not tags", []Entity{}},
+		{"numeric only", "#2024 and #1", []Entity{}},
+		{"digits and letters", "#1st #v2", []Entity{tag("1st", 0, 4), tag("v2", 5, 8)}},
+		{"trailing punctuation", "Great! #Coffee, #tea. @alice!",
+			[]Entity{tag("coffee", 7, 14), tag("tea", 16, 20), mention("alice", 22, 28)}},
+		{"in parentheses", "(#coffee)", []Entity{tag("coffee", 1, 8)}},
+		{"underscores", "#cold_brew @bob_2", []Entity{tag("cold_brew", 0, 10), mention("bob_2", 11, 17)}},
+		{"duplicates", "#coffee #coffee", []Entity{tag("coffee", 0, 7), tag("coffee", 8, 15)}},
+		{"case", "#Coffee #COFFEE @Alice", []Entity{tag("coffee", 0, 7), tag("coffee", 8, 15), mention("Alice", 16, 22)}},
+		{"doubled marks", "##coffee @@alice #@alice", []Entity{}},
+		{"bare marks", "# @ #", []Entity{}},
+		{"short username", "@al", []Entity{}},
+		{"username starting with a digit", "@1alice", []Entity{}},
+		{"offsets in code points", "café #thé ☕ @bob", []Entity{tag("thé", 5, 9), mention("bob", 12, 16)}},
+		{"tag too long", "#" + strings.Repeat("a", MaxTagLength+1), []Entity{}},
+		{"longest tag", "#" + strings.Repeat("a", MaxTagLength), []Entity{tag(strings.Repeat("a", MaxTagLength), 0, MaxTagLength+1)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseEntities(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseEntities(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+
+			// the offsets point at the entity as written
+			runes := []rune(tt.text)
+			for _, e := range got {
+				written := string(runes[e.Start:e.End])
+				if !strings.EqualFold(written[1:], e.Value) {
+					t.Errorf("%s at [%d, %d) is %q", e.Value, e.Start, e.End, written)
+				}
+			}
+		})
+	}
+}
+
+func TestDistinctEntities(t *testing.T) {
+	entities := ParseEntities("#Coffee @alice #coffee #tea @alice @Alice #COFFEE")
+
+	if got, want := Tags(entities), []string{"coffee", "tea"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Tags = %v, want %v", got, want)
+	}
+	// mentions keep their case, the database matches them against the usernames
+	if got, want := Mentions(entities), []string{"alice", "Alice"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Mentions = %v, want %v", got, want)
+	}
+	if got := Tags(ParseEntities("no tags")); got == nil || len(got) != 0 {
+		t.Errorf("Tags without tags = %#v, want an empty list", got)
+	}
+}
@@ -6,6 +6,7 @@ const UnauthorizedError string = "{\"code\": 401, \"message\": \"Unauthorized\"}
 const NotFoundError string = "{\"code\": 404, \"message\": \"Not Found\"}"
 const ConflictError string = "{\"code\": 409, \"message\": \"Conflict\"}"
 const ForbiddenError string = "{\"code\": 403, \"message\": \"Forbidden\"}"
+const TooManyRequestsError string = "{\"code\": 429, \"message\": \"Too Many Requests\"}"
 
 const InternalServerErrorF string = "{\"code\": 500, \"message\": \"Internal Server Error: %w\"}"
 const BadRequestErrorF string = "{\"code\": 400, \"message\": \"Bad Request: %w\"}"
@@ -0,0 +1,44 @@
+package components
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// MaxDescriptionLength is the maximum length, in characters, of the description of a post
+const MaxDescriptionLength = 1024
+
+// MediaAltText is the new alt text of the image Media_ID of a post
+type MediaAltText struct {
+	Media_ID SHA256hash `json:"media_id"`
+	AltText  string     `json:"alt_text"`
+}
+
+// PhotoUpdate is the body of a photo PATCH request, absent (nil) fields are left untouched and images not listed in
+// Media keep their alt text.
+type PhotoUpdate struct {
+	Description *string        `json:"description"`
+	Media       []MediaAltText `json:"media"`
+	Archived    *bool          `json:"archived"`
+}
+
+// Validate checks the lengths of the fields that are present.
+func (p PhotoUpdate) Validate() error {
+
+	if p.Description != nil && utf8.RuneCountInString(*p.Description) > MaxDescriptionLength {
+		return fmt.Errorf("description longer than %d characters", MaxDescriptionLength)
+	}
+
+	for i, m := range p.Media {
+
+		if !hashPattern.MatchString(m.Media_ID.Hash) {
+			return fmt.Errorf("invalid media ID %q", m.Media_ID.Hash)
+		}
+
+		if utf8.RuneCountInString(m.AltText) > MaxAltTextLength {
+			return fmt.Errorf("alt text of media item %d longer than %d characters", i, MaxAltTextLength)
+		}
+	}
+
+	return nil
+}
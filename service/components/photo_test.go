@@ -0,0 +1,26 @@
+package components
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPhotoUpdateErrorsAreJSON(t *testing.T) {
+	update := PhotoUpdate{Media: []MediaAltText{{Media_ID: SHA256hash{Hash: `"x\y"`}}}}
+
+	err := update.Validate()
+	if err == nil {
+		t.Fatal("no error for an invalid media ID")
+	}
+
+	body := BadRequestErrorFor(err)
+
+	var decoded Error
+	if err := json.Unmarshal([]byte(body), &decoded); err != nil {
+		t.Fatalf("%s is not JSON: %v", body, err)
+	}
+	if want := `invalid media ID "\"x\\y\""`; !strings.Contains(decoded.Message, want) {
+		t.Errorf("message %q, want %q", decoded.Message, want)
+	}
+}
@@ -0,0 +1,70 @@
+package components
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"unicode/utf8"
+)
+
+// Limits of the profile fields, in characters
+const (
+	MaxDisplayNameLength = 64
+	MaxBioLength         = 256
+	MaxWebsiteLength     = 256
+)
+
+// DefaultAvatar is the well-known photo served in place of a missing avatar (see getPhoto)
+const DefaultAvatar string = "default"
+
+var hashPattern = regexp.MustCompile(`^[a-fA-F0-9]{64}$`)
+
+// ProfileUpdate is the body of a profile PATCH request, absent (nil) fields are left untouched.
+type ProfileUpdate struct {
+	Uname       *string     `json:"username-string"`
+	DisplayName *string     `json:"display_name"`
+	Bio         *string     `json:"bio"`
+	Website     *string     `json:"website"`
+	Avatar      *SHA256hash `json:"avatar"`
+}
+
+// Validate checks lengths and formats of the fields that are present. An empty website clears it, and an avatar
+// with an empty (or the DefaultAvatar) hash resets it to the default picture.
+func (p ProfileUpdate) Validate() error {
+
+	if p.Uname != nil && !ValidUsername(*p.Uname) {
+		return fmt.Errorf("invalid username %q", *p.Uname)
+	}
+
+	if p.DisplayName != nil && utf8.RuneCountInString(*p.DisplayName) > MaxDisplayNameLength {
+		return fmt.Errorf("display name longer than %d characters", MaxDisplayNameLength)
+	}
+
+	if p.Bio != nil && utf8.RuneCountInString(*p.Bio) > MaxBioLength {
+		return fmt.Errorf("bio longer than %d characters", MaxBioLength)
+	}
+
+	if p.Website != nil && *p.Website != "" {
+
+		if utf8.RuneCountInString(*p.Website) > MaxWebsiteLength {
+			return fmt.Errorf("website longer than %d characters", MaxWebsiteLength)
+		}
+
+		u, err := url.Parse(*p.Website)
+
+		if err != nil {
+			return fmt.Errorf("invalid website: %w", err)
+		}
+
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("website must be an absolute http(s) URL")
+		}
+	}
+
+	if p.Avatar != nil && p.Avatar.Hash != "" && p.Avatar.Hash != DefaultAvatar && !hashPattern.MatchString(p.Avatar.Hash) {
+		return fmt.Errorf("invalid avatar photo ID %q", p.Avatar.Hash)
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package components
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestProfileUpdateErrorsAreJSON(t *testing.T) {
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name   string
+		update ProfileUpdate
+		// message is part of the message of the 400 body
+		message string
+	}{
+		{"username with quotes", ProfileUpdate{Uname: str(`"bob"`)}, `invalid username "\"bob\""`},
+		{"username with a backslash", ProfileUpdate{Uname: str(`bo\b`)}, `invalid username "bo\\b"`},
+		{"unparsable website", ProfileUpdate{Website: str("http://[::1")}, `invalid website: parse "http://[::1"`},
+		{"relative website", ProfileUpdate{Website: str("example.com")}, "absolute http(s) URL"},
+		{"avatar", ProfileUpdate{Avatar: &SHA256hash{Hash: `"x"`}}, `invalid avatar photo ID "\"x\""`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.update.Validate()
+			if err == nil {
+				t.Fatal("no error")
+			}
+
+			body := BadRequestErrorFor(err)
+
+			var decoded Error
+			if err := json.Unmarshal([]byte(body), &decoded); err != nil {
+				t.Fatalf("%s is not JSON: %v", body, err)
+			}
+			if decoded.Code != 400 || !strings.Contains(decoded.Message, tt.message) {
+				t.Errorf("got %d %q, want 400 with %q", decoded.Code, decoded.Message, tt.message)
+			}
+		})
+	}
+}
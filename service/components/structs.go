@@ -29,9 +29,33 @@ func (h SHA256hash) ToJSON() ([]byte, error) {
 	return json.MarshalIndent(wrapper, "", "  ")
 }
 
+// Session is the result of a login: the token to send as Authorization, and the ID of the user
+type Session struct {
+	Token  SHA256hash `json:"token"`
+	UserID SHA256hash `json:"user_id"`
+}
+
+func (s Session) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// Reauthentication proves that the user logged in again shortly before an operation that cannot be undone, such as
+// deleting the account. Its token is valid once, until ExpiresAt.
+type Reauthentication struct {
+	Token     SHA256hash `json:"token"`
+	ExpiresAt JSONTime   `json:"expires_at"`
+}
+
+func (r Reauthentication) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
 type Profile struct {
-	Username string       `json:"username-string"`
-	Photos   []SHA256hash `json:"photos"`
+	Username    string     `json:"username-string"`
+	DisplayName string     `json:"display_name"`
+	Bio         string     `json:"bio"`
+	Website     string     `json:"website"`
+	Avatar      SHA256hash `json:"avatar"`
 }
 
 func (p Profile) ToJSON() ([]byte, error) {
@@ -47,6 +71,19 @@ func (e Error) ToJSON() ([]byte, error) {
 	return json.MarshalIndent(e, "", "  ")
 }
 
+// BadRequestErrorFor returns the body of a 400 response explaining `err`. Unlike BadRequestErrorF, the message is
+// escaped, so that quotes in the error (e.g. quoted input) keep the body valid JSON.
+func BadRequestErrorFor(err error) string {
+
+	data, e := json.Marshal(Error{Code: 400, Message: "Bad Request: " + err.Error()})
+
+	if e != nil {
+		return BadRequestError
+	}
+
+	return string(data)
+}
+
 type IDList struct {
 	IDs []SHA256hash `json:"ids"`
 }
@@ -96,15 +133,43 @@ type Comment struct {
 	Body         string     `json:"body"`
 	CreationTime JSONTime   `json:"creation-time"`
 	Parent       SHA256hash `json:"parent_post"`
+	Entities     []Entity   `json:"entities"`
 }
 
 func (c Comment) ToJSON() ([]byte, error) {
 	return json.MarshalIndent(c, "", "  ")
 }
 
+// Photo is the body of an upload. A carousel post lists its images in Media, in order,
+// otherwise Data holds the only image of the post.
 type Photo struct {
-	Data string `json:"photo_data"`
-	Desc string `json:"photo_desc"`
+	Data  string        `json:"photo_data"`
+	Desc  string        `json:"photo_desc"`
+	Media []MediaUpload `json:"media"`
+}
+
+type MediaUpload struct {
+	Data    string `json:"photo_data"`
+	AltText string `json:"alt_text"`
+}
+
+// MaxAltTextLength is the maximum length, in characters, of the alt text of an image
+const MaxAltTextLength = 1024
+
+// Media is an image of a post, served by /resources/photos/:UUID with its Media_ID.
+// The dominant color and the size are computed on upload, so that the UI can show a placeholder of the right shape
+// while loading, images uploaded before they were computed lack them.
+type Media struct {
+	Media_ID      SHA256hash `json:"media_id"`
+	AltText       string     `json:"alt_text"`
+	DominantColor string     `json:"dominant_color,omitempty"`
+	Width         int        `json:"width,omitempty"`
+	Height        int        `json:"height,omitempty"`
+	AspectRatio   float64    `json:"aspect_ratio,omitempty"`
+}
+
+type AltText struct {
+	AltText string `json:"alt_text"`
 }
 
 type Post struct {
@@ -112,8 +177,57 @@ type Post struct {
 	Author_Name  User       `json:"author_name"`
 	Description  string     `json:"description"`
 	CreationTime JSONTime   `json:"created_at"`
+	Entities     []Entity   `json:"description_entities"`
+	Media        []Media    `json:"media"`
+	Archived     bool       `json:"archived"`
+}
+
+// DeletedPost is a recently deleted post, it can be restored until PurgeAt.
+type DeletedPost struct {
+	Post
+	DeletedAt JSONTime `json:"deleted_at"`
+	PurgeAt   JSONTime `json:"purge_at"`
 }
 
 type Stream struct {
 	Posts []Post `json:"posts"`
 }
+
+// Statuses of an ExportJob
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+// ExportJob is an export of the personal data of a user, built in the background. Once done, its archive can be
+// downloaded until ExpiresAt.
+type ExportJob struct {
+	Job_ID     SHA256hash `json:"job_id"`
+	Status     string     `json:"status"`
+	CreatedAt  JSONTime   `json:"created_at"`
+	FinishedAt *JSONTime  `json:"finished_at,omitempty"`
+	ExpiresAt  *JSONTime  `json:"expires_at,omitempty"`
+}
+
+func (e ExportJob) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(e, "", "  ")
+}
+
+// Readiness is the report of /readiness: the server is ready if every check passed
+type Readiness struct {
+	Ready  bool             `json:"ready"`
+	Checks []ReadinessCheck `json:"checks"`
+}
+
+// ReadinessCheck is the outcome of one of the checks of a Readiness, with the reason of a failure in Error
+type ReadinessCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func (r Readiness) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
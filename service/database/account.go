@@ -0,0 +1,273 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+)
+
+// Deleting an account first deactivates the user: it can no longer log in or be validated, and it is hidden from
+// every list, search and stream, but its rows and images are kept until PurgeDeactivatedUsers erases them. As for
+// deleted posts, the deactivation time is stored as an RFC 3339 UTC string.
+//
+// The session token alone does not allow it, since it may have been stolen or left on a shared device: the user must
+// log in again first, which issues a short-lived reauthentication token, spent by the deactivation.
+
+func (db *appdbimpl) Reauthenticate(ctx context.Context, username string, token string, ttl time.Duration) (reauthentication string, err error) {
+
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
+
+	reauth, err := randomID()
+
+	if err != nil {
+		return components.InternalServerError, err
+	}
+
+	expiresAt := time.Now().UTC().Add(ttl).Truncate(time.Second)
+
+	// a new token replaces the previous one, if any
+	res, err := db.c.ExecContext(ctx, `UPDATE users SET reauth_token = ?, reauth_expires_at = ?
+	WHERE name = ? AND token = ? AND deactivated_at IS NULL`, reauth, expiresAt.Format(time.RFC3339), username, token)
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error reauthenticating user: %w", err)
+	}
+
+	reauthenticated, err := res.RowsAffected()
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error reauthenticating user: %w", err)
+	}
+
+	if reauthenticated == 0 {
+		return components.UnauthorizedError, fmt.Errorf("token is not the token of user %s", username)
+	}
+
+	data, err := components.Reauthentication{
+		Token:     components.SHA256hash{Hash: reauth},
+		ExpiresAt: components.JSONTime(expiresAt),
+	}.ToJSON()
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error converting reauthentication to JSON: %w", err)
+	}
+
+	return string(data), nil
+}
+
+func (db *appdbimpl) DeactivateUser(ctx context.Context, username string, reauth string) (errstring string, err error) {
+
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	res, err := db.c.ExecContext(ctx, `UPDATE users SET deactivated_at = ?, reauth_token = NULL, reauth_expires_at = NULL
+	WHERE name = ? AND deactivated_at IS NULL AND reauth_token = ? AND reauth_expires_at > ?`,
+		now, username, reauth, now)
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error deactivating user: %w", err)
+	}
+
+	deactivated, err := res.RowsAffected()
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error deactivating user: %w", err)
+	}
+
+	if deactivated == 0 {
+		return components.UnauthorizedError, fmt.Errorf("user %s has not reauthenticated", username)
+	}
+
+	return "", nil
+}
+
+func (db *appdbimpl) PurgeDeactivatedUsers(ctx context.Context, before time.Time) (purged int, err error) {
+
+	rows, err := db.c.QueryContext(ctx, `SELECT ID FROM users WHERE deactivated_at IS NOT NULL AND deactivated_at < ?`,
+		before.UTC().Format(time.RFC3339))
+
+	if err != nil {
+		return 0, fmt.Errorf("error getting expired users: %w", err)
+	}
+
+	var IDs []string
+
+	for rows.Next() {
+
+		var ID string
+
+		err = rows.Scan(&ID)
+
+		if err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("error scanning expired user: %w", err)
+		}
+
+		IDs = append(IDs, ID)
+	}
+
+	err = rows.Close()
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("error getting expired users: %w", err)
+	}
+
+	for _, ID := range IDs {
+
+		erased, err := db.purgeUser(ctx, ID, before)
+
+		if err != nil {
+			return purged, err
+		}
+
+		if erased {
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// purgeUser erases the user `userID`, if it is still deactivated since before `before`, with everything it owns or
+// that refers to it. Like for purgePhoto, the images and the export archives are removed only after the rows are gone.
+// The write timeout applies to each user, not to the whole purge.
+func (db *appdbimpl) purgeUser(ctx context.Context, userID string, before time.Time) (erased bool, err error) {
+
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
+
+	var media, exports []string
+
+	err = db.inTx(ctx, func(tx txconn) error {
+
+		// checked again in the transaction, as another janitor may be purging it too
+		var count int
+
+		err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM users
+		WHERE ID = ? AND deactivated_at IS NOT NULL AND deactivated_at < ?`, userID, before.UTC().Format(time.RFC3339)).Scan(&count)
+
+		if err != nil {
+			return fmt.Errorf("error checking user %s: %w", userID, err)
+		}
+
+		if count == 0 {
+			return nil
+		}
+
+		for _, q := range []struct {
+			query string
+			IDs   *[]string
+		}{
+			{`SELECT m.media_ID FROM media AS m, posts AS p WHERE p.poster_ID = ? AND m.post_ID = p.post_ID`, &media},
+			{`SELECT job_ID FROM export_jobs WHERE user_ID = ?`, &exports},
+		} {
+			*q.IDs, err = queryIDs(ctx, tx, q.query, userID)
+
+			if err != nil {
+				return fmt.Errorf("error getting files of user %s: %w", userID, err)
+			}
+		}
+
+		// foreign keys may not be enforced, so what would cascade is deleted explicitly, children first
+		for _, stmt := range []string{
+			// what belongs to the posts of the user
+			`DELETE FROM photo_metadata WHERE media_ID IN (
+				SELECT m.media_ID FROM media AS m, posts AS p WHERE p.poster_ID = ? AND m.post_ID = p.post_ID)`,
+			`DELETE FROM media WHERE post_ID IN (SELECT post_ID FROM posts WHERE poster_ID = ?)`,
+			`DELETE FROM likes WHERE post_ID IN (SELECT post_ID FROM posts WHERE poster_ID = ?)`,
+			`DELETE FROM comment_tags WHERE comment_ID IN (
+				SELECT c.comment_ID FROM comments AS c, posts AS p WHERE p.poster_ID = ? AND c.post_code = p.post_ID)`,
+			`DELETE FROM comment_mentions WHERE comment_ID IN (
+				SELECT c.comment_ID FROM comments AS c, posts AS p WHERE p.poster_ID = ? AND c.post_code = p.post_ID)`,
+			`DELETE FROM comments WHERE post_code IN (SELECT post_ID FROM posts WHERE poster_ID = ?)`,
+			`DELETE FROM post_tags WHERE post_ID IN (SELECT post_ID FROM posts WHERE poster_ID = ?)`,
+			`DELETE FROM post_mentions WHERE post_ID IN (SELECT post_ID FROM posts WHERE poster_ID = ?)`,
+			`DELETE FROM profiles WHERE user_ID = ?`,
+			`DELETE FROM posts WHERE poster_ID = ?`,
+
+			// what the user did elsewhere
+			`DELETE FROM comment_tags WHERE comment_ID IN (SELECT comment_ID FROM comments WHERE user_code = ?)`,
+			`DELETE FROM comment_mentions WHERE comment_ID IN (SELECT comment_ID FROM comments WHERE user_code = ?)`,
+			`DELETE FROM comments WHERE user_code = ?`,
+			`DELETE FROM likes WHERE liker = ?`,
+			`DELETE FROM post_mentions WHERE user_ID = ?`,
+			`DELETE FROM comment_mentions WHERE user_ID = ?`,
+			`DELETE FROM followers WHERE follower = ? OR followed = ?`,
+			`DELETE FROM bans WHERE banisher = ? OR banished = ?`,
+			`DELETE FROM export_jobs WHERE user_ID = ?`,
+			`DELETE FROM users WHERE ID = ?`,
+		} {
+			// every placeholder stands for the user
+			args := make([]interface{}, strings.Count(stmt, "?"))
+
+			for i := range args {
+				args[i] = userID
+			}
+
+			_, err = tx.ExecContext(ctx, stmt, args...)
+
+			if err != nil {
+				return fmt.Errorf("error purging user %s: %w", userID, err)
+			}
+		}
+
+		erased = true
+
+		return nil
+	})
+
+	if err != nil || !erased {
+		return false, err
+	}
+
+	removeImages(media)
+
+	for _, ID := range exports {
+		_ = os.Remove(ExportPath(ID))
+	}
+
+	return true, nil
+}
+
+// queryIDs returns the single column of the rows of `query`.
+func queryIDs(ctx context.Context, tx dbtx, query string, args ...interface{}) (IDs []string, err error) {
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+
+		var ID string
+
+		err = rows.Scan(&ID)
+
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+
+		IDs = append(IDs, ID)
+	}
+
+	err = rows.Close()
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	return IDs, err
+}
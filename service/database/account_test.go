@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+)
+
+// login logs `name` in, and returns its session token.
+func login(t *testing.T, db *appdbimpl, name string) string {
+	t.Helper()
+
+	res, err := db.PostUserID(context.Background(), name)
+	if err != nil {
+		t.Fatalf("logging %s in: %v", name, err)
+	}
+	var session components.Session
+	if err := json.Unmarshal([]byte(res), &session); err != nil {
+		t.Fatalf("decoding %s: %v", res, err)
+	}
+	return session.Token.Hash
+}
+
+// reauthenticate reauthenticates `name` for `ttl`, and returns the reauthentication token.
+func reauthenticate(t *testing.T, db *appdbimpl, name string, token string, ttl time.Duration) string {
+	t.Helper()
+
+	res, err := db.Reauthenticate(context.Background(), name, token, ttl)
+	if err != nil {
+		t.Fatalf("reauthenticating %s: %s, %v", name, res, err)
+	}
+	var reauth components.Reauthentication
+	if err := json.Unmarshal([]byte(res), &reauth); err != nil {
+		t.Fatalf("decoding %s: %v", res, err)
+	}
+	return reauth.Token.Hash
+}
+
+func TestConformanceDeactivationNeedsReauthentication(t *testing.T) {
+	conform(t, func(t *testing.T, db *appdbimpl) {
+		ctx := context.Background()
+
+		token := login(t, db, "alice")
+		other := login(t, db, "bob")
+
+		if res, _ := db.Reauthenticate(ctx, "alice", other, time.Minute); res != components.UnauthorizedError {
+			t.Errorf("reauthenticating alice with the token of bob: %s", res)
+		}
+
+		deactivate := func(reauth string) string {
+			res, _ := db.DeactivateUser(ctx, "alice", reauth)
+			return res
+		}
+
+		if res := deactivate(""); res != components.UnauthorizedError {
+			t.Errorf("deactivating without reauthenticating: %s", res)
+		}
+
+		expired := reauthenticate(t, db, "alice", token, -time.Minute)
+		if res := deactivate(expired); res != components.UnauthorizedError {
+			t.Errorf("deactivating with an expired token: %s", res)
+		}
+
+		replaced := reauthenticate(t, db, "alice", token, time.Minute)
+		reauth := reauthenticate(t, db, "alice", token, time.Minute)
+		if res := deactivate(replaced); res != components.UnauthorizedError {
+			t.Errorf("deactivating with a replaced token: %s", res)
+		}
+		if res := deactivate(token); res != components.UnauthorizedError {
+			t.Errorf("deactivating with the session token: %s", res)
+		}
+
+		if res := deactivate(reauth); res != "" {
+			t.Fatalf("deactivating: %s", res)
+		}
+		if res := deactivate(reauth); res != components.UnauthorizedError {
+			t.Errorf("deactivating twice with the same token: %s", res)
+		}
+
+		valid, err := db.Validate(ctx, "alice", token)
+		if err != nil || valid {
+			t.Errorf("the token of a deactivated user validates: %v", err)
+		}
+		if res, _ := db.Reauthenticate(ctx, "alice", token, time.Minute); res != components.UnauthorizedError {
+			t.Errorf("reauthenticating a deactivated user: %s", res)
+		}
+	})
+}
+
+func TestConformancePurgeDeactivatedUsers(t *testing.T) {
+	conform(t, func(t *testing.T, db *appdbimpl) {
+		ctx := context.Background()
+
+		aliceID := newUserWithReferences(t, db, "alice", "bob")
+		token := login(t, db, "alice")
+		reauth := reauthenticate(t, db, "alice", token, time.Minute)
+		if res, err := db.DeactivateUser(ctx, "alice", reauth); err != nil {
+			t.Fatalf("deactivating alice: %s, %v", res, err)
+		}
+
+		// alice is kept during the grace period
+		purged, err := db.PurgeDeactivatedUsers(ctx, time.Now().Add(-time.Hour))
+		if err != nil || purged != 0 {
+			t.Errorf("purging the users deactivated an hour ago: %d, %v", purged, err)
+		}
+		if refs := referencesTo(t, db, aliceID); isZero(refs) {
+			t.Error("the rows of alice are gone during the grace period")
+		}
+
+		purged, err = db.PurgeDeactivatedUsers(ctx, time.Now().Add(time.Second))
+		if err != nil || purged != 1 {
+			t.Fatalf("purging the users deactivated until now: %d, %v", purged, err)
+		}
+		if n := count(t, db, `SELECT COUNT(*) FROM users`); n != 1 {
+			t.Errorf("%d users after purging alice, want 1", n)
+		}
+		if refs := referencesTo(t, db, aliceID); !isZero(refs) {
+			t.Errorf("rows still refer to alice: %v", refs)
+		}
+		checkNoDanglingReferences(t, db)
+
+		// the name is free again, for an account with a new token
+		if again := login(t, db, "alice"); again == token {
+			t.Error("the new alice has the token of the purged one")
+		}
+		if valid, err := db.Validate(ctx, "alice", token); err != nil || valid {
+			t.Errorf("the token of the purged alice validates: %v", err)
+		}
+	})
+}
@@ -26,22 +26,20 @@ This is an example on how to migrate the DB and connect to it:
 		_ = db.Close()
 	}()
 
-Then you can initialize the AppDatabase and pass it to the api package.
+Then you can initialize the AppDatabase and pass it to the api package. PostgreSQL is supported as well: open the
+connection with the "postgres" driver, and pass the matching Dialect to New (see Config).
 */
 package database
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
 	"database/sql"
 	_ "embed"
-	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"image/png"
-	"os"
 	"time"
 
 	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
@@ -53,91 +51,199 @@ var migration string
 
 // AppDatabase is the high level interface for the DB
 type AppDatabase interface {
-	GetName() (string, error)
-	SetName(name string) error
-	Ping() error
+	GetName(ctx context.Context) (string, error)
+	SetName(ctx context.Context, name string) error
+	Ping(ctx context.Context) error
+
+	// PendingMigrations returns the number of versioned migrations not yet applied to the database
+	PendingMigrations(ctx context.Context) (pending int, err error)
 
 	// Boilerplate code for APIs
 	// each method encapsulates the logic for a specific API
 	// it goes from data estracting from the DB to data serialization
 
-	// GetUserID returns the ID of the user with the given name
+	// PostUserID returns the session of the user with the given name, its ID and its token, as JSON
 	// Create the user if it doesn't exist
-	PostUserID(userName string) (ID string, err error)
+	PostUserID(ctx context.Context, userName string) (session string, err error)
 
 	// GetUserID returns the ID of the user with the given name
 	// it returns an error if the user doesn't exist
 	// therefore the user is NOT created.
-	GetUserID(name string) (ID string, err error)
+	GetUserID(ctx context.Context, name string) (ID string, err error)
+
+	GetUsername(ctx context.Context, ID string) (username string, err error)
 
-	GetUsername(ID string) (username string, err error)
+	// SearchUserByName returns the users whose name matches `name`,
+	// hiding those that banned `searcher`
+	SearchUserByName(ctx context.Context, name string, searcher string) (matches string, err error)
 
-	SearchUserByName(name string) (matches string, err error)
+	// Search runs a full-text search over users (names) or photos (descriptions and comments),
+	// `kind` is either SearchUsers or SearchPhotos, results are ranked best first
+	Search(ctx context.Context, text string, kind string, searcher string, from, offset int) (results string, err error)
 
 	// CheckUserExists returns true if the user with the given ID exists
-	CheckUserExists(ID string) (exists bool, err error)
+	CheckUserExists(ctx context.Context, ID string) (exists bool, err error)
 
 	// CheckPhotoExists returns true if the photo with the given ID exists
-	CheckPhotoExists(ID string) (exists bool, err error)
+	CheckPhotoExists(ctx context.Context, ID string) (exists bool, err error)
 
 	// CheckUsernameExists returns true if the user with the given username exists
-	CheckUsernameExists(username string) (exists bool, err error)
+	CheckUsernameExists(ctx context.Context, username string) (exists bool, err error)
+
+	// GetUserPhotos returns the photos of the user `ID`, the archived ones only if `archived` is true
+	GetUserPhotos(ctx context.Context, ID string, archived bool) (photos string, err error)
+
+	GetUserFollowers(ctx context.Context, username string) (followers string, err error)
+
+	GetUserFollowing(ctx context.Context, username string) (following string, err error)
+
+	GetPhotoLikes(ctx context.Context, ID string) (likes string, err error)
+
+	GetPhotoComments(ctx context.Context, ID string) (comments string, err error)
+
+	GetUserBans(ctx context.Context, ID string) (bans string, err error)
+
+	FollowUser(ctx context.Context, follower string, followed string) (errstring string, err error)
+
+	UnfollowUser(ctx context.Context, follower string, followed string) (errstring string, err error)
+
+	// Validate returns whether `token` is the token of the active user `username`
+	Validate(ctx context.Context, username string, token string) (is_valid bool, err error)
 
-	GetUserPhotos(ID string) (photos string, err error)
+	// Authenticate returns the ID of the active user whose token is `token`, empty if none
+	Authenticate(ctx context.Context, token string) (ID string, err error)
 
-	GetUserFollowers(username string) (followers string, err error)
+	BanUser(ctx context.Context, bannedID string, bannerID string) (errstring string, err error)
 
-	GetUserFollowing(username string) (following string, err error)
+	UnbanUser(ctx context.Context, bannedID string, bannerID string) (errstring string, err error)
 
-	GetPhotoLikes(ID string) (likes string, err error)
+	LikePhoto(ctx context.Context, likerID string, photoID string) (errstring string, err error)
 
-	GetPhotoComments(ID string) (comments string, err error)
+	UnlikePhoto(ctx context.Context, likerID string, photoID string) (errstring string, err error)
 
-	GetUserBans(ID string) (bans string, err error)
+	CommentPhoto(ctx context.Context, username string, photoID string, comment components.Comment) (errstring string, err error)
 
-	FollowUser(follower string, followed string) (errstring string, err error)
+	UncommentPhoto(ctx context.Context, username string, photoID string, comment_id string) (errstring string, err error)
 
-	UnfollowUser(follower string, followed string) (errstring string, err error)
+	UploadPhoto(ctx context.Context, username string, photo components.Photo, photo_ID string) (errstring string, err error)
 
-	Validate(username string, ID string) (is_valid bool, err error)
+	// DeletePhoto moves the post `photoID` of `username` to the recently deleted ones
+	DeletePhoto(ctx context.Context, username string, photoID string) (errstring string, err error)
 
-	BanUser(bannedID string, bannerID string) (errstring string, err error)
+	// GetDeletedPhotos returns the recently deleted posts of `username`, with the time they will be purged at
+	// after `retention`
+	GetDeletedPhotos(ctx context.Context, username string, retention time.Duration) (photos string, err error)
 
-	UnbanUser(bannedID string, bannerID string) (errstring string, err error)
+	// RestorePhoto brings back the recently deleted post `photoID` of `username`
+	RestorePhoto(ctx context.Context, username string, photoID string) (errstring string, err error)
 
-	LikePhoto(likerID string, photoID string) (errstring string, err error)
+	// PurgeDeletedPhotos erases the posts deleted before `before`, together with their images
+	PurgeDeletedPhotos(ctx context.Context, before time.Time) (purged int, err error)
 
-	UnlikePhoto(likerID string, photoID string) (errstring string, err error)
+	// RemoveOrphanImages erases the image files, last modified before `before`, that belong to no post
+	RemoveOrphanImages(ctx context.Context, before time.Time) (removed int, err error)
 
-	CommentPhoto(username string, photoID string, comment components.Comment) (errstring string, err error)
+	// Reauthenticate issues a new reauthentication token, valid once for `ttl`, to the active user `username` whose
+	// session token is `token`, and returns it as JSON
+	Reauthenticate(ctx context.Context, username string, token string, ttl time.Duration) (reauthentication string, err error)
 
-	UncommentPhoto(username string, photoID string, comment_id string) (errstring string, err error)
+	// DeactivateUser starts the deletion of the account of `username`, spending its reauthentication token `reauth`:
+	// the user is hidden everywhere and can no longer log in, until PurgeDeactivatedUsers erases it
+	DeactivateUser(ctx context.Context, username string, reauth string) (errstring string, err error)
 
-	UploadPhoto(username string, photo components.Photo, photo_ID string) (errstring string, err error)
+	// PurgeDeactivatedUsers erases the users deactivated before `before`, with their posts, images and
+	// everything else referring to them
+	PurgeDeactivatedUsers(ctx context.Context, before time.Time) (purged int, err error)
 
-	DeletePhoto(username string, photoID string) (errstring string, err error)
+	// CreateExport queues an export of the personal data of `username`, unless one is already queued or running,
+	// and returns the job, with the time its archive will expire at after `retention`
+	CreateExport(ctx context.Context, username string, retention time.Duration) (job string, err error)
 
-	ChangeUsername(username string, ID string) (errstring string, err error)
+	// GetExport returns the export job `jobID` of `username`, with the time its archive expires at after `retention`
+	GetExport(ctx context.Context, username string, jobID string, retention time.Duration) (job string, err error)
 
-	GetStream(username string, from, offset int) (stream string, err error)
+	// ExportReady checks that the archive of the export job `jobID` of `username` is built,
+	// the archive is then at ExportPath(jobID)
+	ExportReady(ctx context.Context, username string, jobID string) (errstring string, err error)
+
+	// RunNextExport builds the archive of the oldest export job still to be built, it returns false if there is none
+	RunNextExport(ctx context.Context) (ran bool, err error)
+
+	// PurgeExpiredExports erases the export jobs finished before `before`, together with their archives
+	PurgeExpiredExports(ctx context.Context, before time.Time) (purged int, err error)
+
+	// UpdatePhoto applies the non-nil fields of `update` to the post `photoID` of `username`,
+	// and returns the updated post
+	UpdatePhoto(ctx context.Context, username string, photoID string, update components.PhotoUpdate) (photo string, err error)
+
+	// SetAltText changes the alt text of the image `mediaID` of the post `photoID` of `username`
+	SetAltText(ctx context.Context, username string, photoID string, mediaID string, altText string) (errstring string, err error)
+
+	ChangeUsername(ctx context.Context, username string, ID string) (errstring string, err error)
+
+	// GetUserProfile returns the profile (display name, bio, website and avatar) of `username`
+	GetUserProfile(ctx context.Context, username string) (profile string, err error)
+
+	// UpdateProfile applies the non-nil fields of `update` to the profile of `username`,
+	// changing the username (and therefore the ID) too if requested
+	UpdateProfile(ctx context.Context, username string, update components.ProfileUpdate) (profile string, err error)
+
+	GetStream(ctx context.Context, username string, from, offset int) (stream string, err error)
+
+	// GetTagPhotos returns the photos whose description contains the hashtag `tag`,
+	// hiding those of users that banned `username`
+	GetTagPhotos(ctx context.Context, tag string, username string, from, offset int) (photos string, err error)
 }
 
 type appdbimpl struct {
-	c *sql.DB
+	c conn
+
+	// fts is true if the full-text search index is available
+	fts bool
+
+	timeouts Timeouts
 }
 
-// New returns a new instance of AppDatabase based on the SQLite connection `db`.
-// `db` is required - an error will be returned if `db` is `nil`.
-func New(db *sql.DB) (AppDatabase, error) {
+// Config is used to provide the connections and the settings to the New function.
+type Config struct {
+	// DB is the connection pool of the database, every write goes through it. On SQLite it should hold a single
+	// connection, so that concurrent writers wait for their turn in the pool instead of failing with "database is
+	// locked".
+	DB *sql.DB
+
+	// Readers is an optional pool for the reads made outside transactions, e.g. read-only connections to the same
+	// SQLite file, which in WAL mode neither block the writer nor wait for it. If nil, reads go through DB. A result set
+	// holds its connection until closed, so no query is made while reading one: a bounded pool would run out.
+	Readers *sql.DB
+
+	// Dialect is the SQL dialect of DB (and Readers)
+	Dialect Dialect
+
+	// Timeouts bound every operation
+	Timeouts Timeouts
+}
+
+// New returns a new instance of AppDatabase based on the connections in `cfg`.
+// `cfg.DB` is required - an error will be returned if it is `nil`.
+func New(cfg Config) (AppDatabase, error) {
+	db := cfg.DB
 	if db == nil {
 		return nil, errors.New("database is required when building a AppDatabase")
 	}
 
+	if !cfg.Dialect.Valid() {
+		return nil, fmt.Errorf("unsupported database dialect %q", cfg.Dialect)
+	}
+
 	err := db.Ping()
 	if err != nil {
 		return nil, fmt.Errorf("error pinging database: %w", err)
 	}
 
+	if cfg.Dialect == Postgres {
+		return newPostgres(cfg)
+	}
+
 	// Check if table exists. If not, the database is empty, and we need to create the structure
 	var tableName string
 	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='example_table';`).Scan(&tableName)
@@ -214,20 +320,40 @@ CREATE TABLE IF NOT EXISTS comments (
 		}
 	}
 
+	err = migrate(db, SQLite)
+
+	if err != nil {
+		return nil, fmt.Errorf("error migrating database: %w", err)
+	}
+
+	fts, err := initSearch(db)
+
+	if err != nil {
+		return nil, fmt.Errorf("error initializing search: %w", err)
+	}
+
 	return &appdbimpl{
-		c: db,
+		c:        conn{DB: db, readers: cfg.Readers, dialect: SQLite},
+		fts:      fts,
+		timeouts: cfg.Timeouts,
 	}, nil
 }
 
 // Wraps the Ping() method of the underlying DB connection
 // This is used to check if the DB is still alive or to
 // prompt the establishment of a new connection.
-func (db *appdbimpl) Ping() error {
-	return db.c.Ping()
+func (db *appdbimpl) Ping(ctx context.Context) error {
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
+
+	return db.c.PingContext(ctx)
 }
 
-func (db *appdbimpl) GetUsername(ID string) (username string, err error) {
-	err = db.c.QueryRow(`SELECT name FROM users WHERE id = ?`, ID).Scan(&username)
+func (db *appdbimpl) GetUsername(ctx context.Context, ID string) (username string, err error) {
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
+
+	err = db.c.QueryRowContext(ctx, `SELECT name FROM users WHERE id = ?`, ID).Scan(&username)
 
 	if err != nil {
 		return "", fmt.Errorf("error getting username: %w", err)
@@ -236,140 +362,108 @@ func (db *appdbimpl) GetUsername(ID string) (username string, err error) {
 	return username, nil
 }
 
-// PostUserID returns the ID of the user with the given name,
+// PostUserID returns the session of the user with the given name, its ID and its token,
 // Creates the user if it doesn't exist
-func (db *appdbimpl) PostUserID(userName string) (json string, err error) {
+func (db *appdbimpl) PostUserID(ctx context.Context, userName string) (json string, err error) {
 
-	// check if the user already exists
-	var count int
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
 
-	// Selects ALWAYS one row
-	err = db.c.QueryRow(`SELECT COUNT(id) FROM users WHERE name = ?`, userName).Scan(&count)
+	// Hash the user name with SHA256, that is the ID of a new user
 
-	if err != nil {
+	h := sha256.New()
+	h.Write([]byte(userName))
+	newID := hex.EncodeToString(h.Sum(nil))
 
-		data, e := components.Error{Code: 500, Message: "Internal Server Error, Weird number of users found"}.ToJSON()
+	// The token is random, not derived from the name as the ID: once an account is purged, a new one with the same
+	// name gets a new token, and those of the old account stop validating
 
-		if e != nil {
-			return components.InternalServerError, fmt.Errorf("error converting error to JSON: %w", e)
-		}
+	newToken, err := randomID()
 
-		return string(data), fmt.Errorf("error getting user ID on record counting stage: %w", err)
+	if err != nil {
+		return components.InternalServerError, err
 	}
 
-	// get the count
-	var userID string
-
-	if count == 0 {
-		// create the user
-		// Hash the user name with SHA256
+	var userID, token string
+	var deactivated bool
 
-		h := sha256.New()
-		h.Write([]byte(userName))
-		userID = hex.EncodeToString(h.Sum(nil))
+	// Create the user if it doesn't exist and read its ID in the same transaction, so that concurrent logins with
+	// the same name cannot both create it. Not an INSERT OR IGNORE, that would fire the search index triggers even
+	// when ignored.
+	create := func(tx txconn) error {
 
-		// Insert the user in the DB
-		_, err = db.c.Exec(`INSERT OR REPLACE INTO users (ID, name) VALUES (?, ?)`, userID, userName)
+		_, err := tx.ExecContext(ctx, `INSERT INTO users (ID, name, token) SELECT ?, ?, ? WHERE NOT EXISTS (
+			SELECT * FROM users WHERE name = ?
+		)`, newID, userName, newToken, userName)
 
 		if err != nil {
-
-			data, e := components.Error{Code: 500, Message: "Internal Server Error"}.ToJSON()
-
-			if e != nil {
-				return components.InternalServerError, fmt.Errorf("error converting error to JSON: %w", e)
-			}
-
-			return string(data), fmt.Errorf("error creating nonexisting user: %w", err)
+			return fmt.Errorf("error creating nonexisting user: %w", err)
 		}
 
-	} else {
-
-		// get the user ID
-		err = db.c.QueryRow(`SELECT ID FROM users WHERE name = ?`, userName).Scan(&userID)
+		err = tx.QueryRowContext(ctx, `SELECT ID, token, deactivated_at IS NOT NULL FROM users WHERE name = ?`,
+			userName).Scan(&userID, &token, &deactivated)
 
 		if err != nil {
-			data, e := components.Error{Code: 500, Message: "Internal Server Error"}.ToJSON()
-
-			if e != nil {
-				return components.InternalServerError, fmt.Errorf("error converting error to JSON: %w", e)
-			}
-
-			return string(data), fmt.Errorf("error getting existing user ID: %w", err)
+			return fmt.Errorf("error getting existing user ID: %w", err)
 		}
 
+		return nil
 	}
 
-	// return the user ID
-	userID_json := components.SHA256hash{Hash: userID}
+	err = db.inTx(ctx, create)
 
-	data, err := userID_json.ToJSON()
+	// on PostgreSQL, a concurrent login may still create the user between the check and the insert: it exists now
+	if isUniqueViolation(err) {
+		err = db.inTx(ctx, create)
+	}
 
 	if err != nil {
+
 		data, e := components.Error{Code: 500, Message: "Internal Server Error"}.ToJSON()
 
 		if e != nil {
 			return components.InternalServerError, fmt.Errorf("error converting error to JSON: %w", e)
 		}
 
-		return string(data), fmt.Errorf("error converting user to JSON: %w", err)
+		return string(data), err
 	}
 
-	return string(data), nil
-
-}
-
-func (db *appdbimpl) SearchUserByName(name string) (matches string, err error) {
-
-	res, err := db.c.Query(`SELECT u.name FROM users as u WHERE u.name LIKE '%'||?||'%'`, name)
-
-	defer func() {
-		if res != nil {
-			err := res.Close()
-			if err != nil {
-				logrus.Errorf("error closing result set: %v", err)
-			}
-		}
-	}()
-
-	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error searching user: %w", err)
+	// the name stays taken until the account is purged
+	if deactivated {
+		return components.ForbiddenError, fmt.Errorf("user %s is being deleted", userName)
 	}
 
-	var users []components.User
-
-	for res.Next() {
-
-		if res.Err() != nil {
-			return components.InternalServerError, fmt.Errorf("error getting next user: %w", res.Err())
-		}
+	// return the user ID and token
+	session := components.Session{
+		Token:  components.SHA256hash{Hash: token},
+		UserID: components.SHA256hash{Hash: userID},
+	}
 
-		user := components.User{}
+	data, err := session.ToJSON()
 
-		err = res.Scan(&user.Uname)
+	if err != nil {
+		data, e := components.Error{Code: 500, Message: "Internal Server Error"}.ToJSON()
 
-		if err != nil {
-			return components.InternalServerError, fmt.Errorf("error scanning user: %w", err)
+		if e != nil {
+			return components.InternalServerError, fmt.Errorf("error converting error to JSON: %w", e)
 		}
 
-		users = append(users, user)
-	}
-
-	data, err := json.Marshal(users)
-
-	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error converting users to JSON: %w", err)
+		return string(data), fmt.Errorf("error converting user to JSON: %w", err)
 	}
 
 	return string(data), nil
 
 }
 
-func (db *appdbimpl) CheckUserExists(userID string) (exists bool, err error) {
+func (db *appdbimpl) CheckUserExists(ctx context.Context, userID string) (exists bool, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
 
 	var count int
 
 	// Selects ALWAYS one row
-	err = db.c.QueryRow(`SELECT COUNT(id) FROM users WHERE id = ?`, userID).Scan(&count)
+	err = db.c.QueryRowContext(ctx, `SELECT COUNT(id) FROM users WHERE id = ? AND deactivated_at IS NULL`, userID).Scan(&count)
 
 	if err != nil {
 		return false, fmt.Errorf("error getting user ID: %w", err)
@@ -383,12 +477,17 @@ func (db *appdbimpl) CheckUserExists(userID string) (exists bool, err error) {
 
 }
 
-func (db *appdbimpl) CheckPhotoExists(photoID string) (exists bool, err error) {
+func (db *appdbimpl) CheckPhotoExists(ctx context.Context, photoID string) (exists bool, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
 
 	var count int
 
 	// Selects ALWAYS one row
-	err = db.c.QueryRow(`SELECT COUNT(post_ID) FROM posts WHERE post_ID = ?`, photoID).Scan(&count)
+	// every image of a post is a media item, the first one has the ID of the post itself
+	err = db.c.QueryRowContext(ctx, `SELECT COUNT(m.media_ID) FROM media AS m, posts AS p, users AS u
+	WHERE m.media_ID = ? AND p.post_ID = m.post_ID AND u.ID = p.poster_ID AND u.deactivated_at IS NULL`, photoID).Scan(&count)
 
 	if err != nil {
 		return false, fmt.Errorf("error getting photo ID: %w", err)
@@ -402,12 +501,15 @@ func (db *appdbimpl) CheckPhotoExists(photoID string) (exists bool, err error) {
 
 }
 
-func (db *appdbimpl) CheckUsernameExists(username string) (exists bool, err error) {
+func (db *appdbimpl) CheckUsernameExists(ctx context.Context, username string) (exists bool, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
 
 	var count int
 
 	// Selects ALWAYS one row
-	err = db.c.QueryRow(`SELECT COUNT(ID) FROM users WHERE name = ?`, username).Scan(&count)
+	err = db.c.QueryRowContext(ctx, `SELECT COUNT(ID) FROM users WHERE name = ? AND deactivated_at IS NULL`, username).Scan(&count)
 
 	if err != nil {
 		return false, fmt.Errorf("error getting user ID: %w", err)
@@ -421,7 +523,10 @@ func (db *appdbimpl) CheckUsernameExists(username string) (exists bool, err erro
 
 }
 
-func (db *appdbimpl) GetUserPhotos(userID string) (photo string, err error) {
+func (db *appdbimpl) GetUserPhotos(ctx context.Context, userID string, archived bool) (photo string, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
 
 	photoIDlist := struct {
 		Posts []components.Post `json:"posts"`
@@ -429,37 +534,19 @@ func (db *appdbimpl) GetUserPhotos(userID string) (photo string, err error) {
 		Posts: []components.Post{},
 	}
 
-	res, err := db.c.Query(`SELECT * FROM posts AS pt 
-		WHERE pt.poster_ID = ? ORDER BY pt.creation_date DESC`, userID)
+	res, err := db.c.QueryContext(ctx, `SELECT pt.post_ID, pt.poster_ID, pt.description, pt.creation_date, pt.archived_at IS NOT NULL
+		FROM posts AS pt WHERE pt.poster_ID = ? AND (? OR pt.archived_at IS NULL) AND pt.deleted_at IS NULL
+		ORDER BY pt.creation_date DESC`, userID, archived)
 
 	if err != nil {
 		return components.InternalServerError,
 			fmt.Errorf("error getting user's photos: %w", err)
 	}
 
-	for res.Next() {
-
-		if res.Err() != nil {
-			return components.InternalServerError, fmt.Errorf("error getting next user: %w", res.Err())
-		}
-
-		var post components.Post
+	photoIDlist.Posts, err = db.scanPosts(ctx, res)
 
-		err = res.Scan(&post.Photo_ID.Hash, &post.Author_Name.Uname, &post.Description, &post.CreationTime)
-
-		if err != nil {
-			return components.InternalServerError,
-				fmt.Errorf("error scanning photo: %w", err)
-		}
-
-		post.Author_Name.Uname, err = db.GetUsername(post.Author_Name.Uname)
-
-		if err != nil {
-			return components.InternalServerError,
-				fmt.Errorf("error getting username: %w", err)
-		}
-
-		photoIDlist.Posts = append(photoIDlist.Posts, post)
+	if err != nil {
+		return components.InternalServerError, err
 	}
 
 	data, err := json.MarshalIndent(
@@ -474,11 +561,14 @@ func (db *appdbimpl) GetUserPhotos(userID string) (photo string, err error) {
 	return string(data), nil
 }
 
-func (db *appdbimpl) GetUserID(name string) (ID string, err error) {
+func (db *appdbimpl) GetUserID(ctx context.Context, name string) (ID string, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
 
 	var userID string
 
-	err = db.c.QueryRow(`SELECT id FROM users WHERE name = ?`, name).Scan(&userID)
+	err = db.c.QueryRowContext(ctx, `SELECT id FROM users WHERE name = ? AND deactivated_at IS NULL`, name).Scan(&userID)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error getting user ID: %w", err)
@@ -488,63 +578,12 @@ func (db *appdbimpl) GetUserID(name string) (ID string, err error) {
 
 }
 
-func (db *appdbimpl) GetUserProfile(userID string) (profile string, err error) {
+func (db *appdbimpl) GetUserFollowers(ctx context.Context, username string) (followers string, err error) {
 
-	var username string
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
 
-	err = db.c.QueryRow(`SELECT name FROM Users WHERE id = ?`, userID).Scan(&username)
-
-	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error getting username: %w", err)
-	}
-
-	photoIDlist := []components.SHA256hash{}
-
-	res, err := db.c.Query(`SELECT ps.photo_name FROM posts AS pt 
-		WHERE ps.poster_name = ?`, userID)
-
-	if err != nil {
-		return components.InternalServerError,
-			fmt.Errorf("error getting user's photos: %w", err)
-	}
-
-	for res.Next() {
-
-		if res.Err() != nil {
-			return components.InternalServerError, fmt.Errorf("error getting next user: %w", res.Err())
-		}
-
-		var photoID components.SHA256hash
-
-		err = res.Scan(&photoID.Hash)
-
-		if err != nil {
-			return components.InternalServerError,
-				fmt.Errorf("error scanning photo: %w", err)
-		}
-
-		photoIDlist = append(photoIDlist, photoID)
-	}
-
-	prof_struct := components.Profile{
-		Username: username,
-		Photos:   photoIDlist,
-	}
-
-	data, err := prof_struct.ToJSON()
-
-	if err != nil {
-		return components.InternalServerError,
-			fmt.Errorf("error converting profile to JSON: %w", err)
-	}
-
-	return string(data), nil
-
-}
-
-func (db *appdbimpl) GetUserFollowers(username string) (followers string, err error) {
-
-	userID, err := db.GetUserID(username)
+	userID, err := db.GetUserID(ctx, username)
 
 	if err != nil {
 
@@ -552,13 +591,21 @@ func (db *appdbimpl) GetUserFollowers(username string) (followers string, err er
 			fmt.Errorf("error getting user ID: %w", err)
 	}
 
-	res, err := db.c.Query(`SELECT follower FROM followers WHERE followed = ?`, userID)
+	res, err := db.c.QueryContext(ctx, `SELECT u.name FROM followers AS f, users AS u
+	WHERE f.followed = ? AND u.ID = f.follower AND u.deactivated_at IS NULL`, userID)
 
 	if err != nil {
 		return components.InternalServerError,
 			fmt.Errorf("error getting user's followers: %w", err)
 	}
 
+	defer func() {
+		err := res.Close()
+		if err != nil {
+			logger(ctx).Errorf("error closing result set: %v", err)
+		}
+	}()
+
 	followerNames := struct {
 		Owner components.User   `json:"owner"`
 		Names []components.User `json:"follow-list"`
@@ -575,25 +622,15 @@ func (db *appdbimpl) GetUserFollowers(username string) (followers string, err er
 			return components.InternalServerError, fmt.Errorf("error getting next user: %w", res.Err())
 		}
 
-		var followerID string
+		var follower components.User
 
-		err = res.Scan(&followerID)
+		err = res.Scan(&follower.Uname)
 
 		if err != nil {
 			return components.InternalServerError,
 				fmt.Errorf("error scanning follower: %w", err)
 		}
 
-		followerName, err := db.GetUsername(followerID)
-
-		if err != nil {
-			return components.InternalServerError, fmt.Errorf("error getting follower name: %w", err)
-		}
-
-		follower := components.User{
-			Uname: followerName,
-		}
-
 		followerNames.Names = append(followerNames.Names, follower)
 
 	}
@@ -608,24 +645,35 @@ func (db *appdbimpl) GetUserFollowers(username string) (followers string, err er
 	return string(data), nil
 }
 
-func (db *appdbimpl) GetUserFollowing(username string) (following string, err error) {
+func (db *appdbimpl) GetUserFollowing(ctx context.Context, username string) (following string, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
 
-	userID, err := db.GetUserID(username)
+	userID, err := db.GetUserID(ctx, username)
 
 	if err != nil {
-		logrus.Error(err)
+		logger(ctx).Error(err)
 		return components.InternalServerError,
 			fmt.Errorf("error getting user ID: %w", err)
 	}
 
-	res, err := db.c.Query(`SELECT followed FROM followers WHERE follower = ?`, userID)
+	res, err := db.c.QueryContext(ctx, `SELECT u.name FROM followers AS f, users AS u
+	WHERE f.follower = ? AND u.ID = f.followed AND u.deactivated_at IS NULL`, userID)
 
 	if err != nil {
-		logrus.Error(err)
+		logger(ctx).Error(err)
 		return components.InternalServerError,
 			fmt.Errorf("error getting user's following: %w", err)
 	}
 
+	defer func() {
+		err := res.Close()
+		if err != nil {
+			logger(ctx).Errorf("error closing result set: %v", err)
+		}
+	}()
+
 	followingNames := struct {
 		Owner components.User   `json:"owner"`
 		Names []components.User `json:"follow-list"`
@@ -637,31 +685,20 @@ func (db *appdbimpl) GetUserFollowing(username string) (following string, err er
 	for res.Next() {
 
 		if res.Err() != nil {
-			logrus.Error(err)
+			logger(ctx).Error(err)
 			return components.InternalServerError, fmt.Errorf("error getting next user: %w", res.Err())
 		}
 
-		var followingID string
+		var followed components.User
 
-		err = res.Scan(&followingID)
+		err = res.Scan(&followed.Uname)
 
 		if err != nil {
-			logrus.Error(err)
+			logger(ctx).Error(err)
 			return components.InternalServerError,
 				fmt.Errorf("error scanning following: %w", err)
 		}
 
-		followingName, err := db.GetUsername(followingID)
-
-		followed := components.User{
-			Uname: followingName,
-		}
-
-		if err != nil {
-			logrus.Error(err)
-			return components.InternalServerError, fmt.Errorf("error getting following name: %w", err)
-		}
-
 		followingNames.Names = append(followingNames.Names, followed)
 
 	}
@@ -669,7 +706,7 @@ func (db *appdbimpl) GetUserFollowing(username string) (following string, err er
 	data, err := json.MarshalIndent(followingNames, "", "	")
 
 	if err != nil {
-		logrus.Error(err)
+		logger(ctx).Error(err)
 		return components.InternalServerError,
 			fmt.Errorf("error converting following to JSON: %w", err)
 	}
@@ -677,9 +714,13 @@ func (db *appdbimpl) GetUserFollowing(username string) (following string, err er
 	return string(data), nil
 }
 
-func (db *appdbimpl) GetPhotoLikes(photoID string) (likes string, err error) {
+func (db *appdbimpl) GetPhotoLikes(ctx context.Context, photoID string) (likes string, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
 
-	res, err := db.c.Query(`SELECT l.liker FROM likes as l, posts as p WHERE p.post_ID = ? AND p.post_ID = l.post_ID`, photoID)
+	res, err := db.c.QueryContext(ctx, `SELECT u.name FROM likes as l, posts as p, users as u
+	WHERE p.post_ID = ? AND p.post_ID = l.post_ID AND u.ID = l.liker AND u.deactivated_at IS NULL`, photoID)
 
 	if err != nil {
 
@@ -687,6 +728,13 @@ func (db *appdbimpl) GetPhotoLikes(photoID string) (likes string, err error) {
 			fmt.Errorf("error getting photo's likes: %w", err)
 	}
 
+	defer func() {
+		err := res.Close()
+		if err != nil {
+			logger(ctx).Errorf("error closing result set: %v", err)
+		}
+	}()
+
 	likerNames := struct {
 		Names []components.User `json:"users"`
 	}{
@@ -699,25 +747,15 @@ func (db *appdbimpl) GetPhotoLikes(photoID string) (likes string, err error) {
 			return components.InternalServerError, fmt.Errorf("error getting next user: %w", res.Err())
 		}
 
-		var likerID string
+		var liker components.User
 
-		err = res.Scan(&likerID)
+		err = res.Scan(&liker.Uname)
 
 		if err != nil {
 			return components.InternalServerError,
 				fmt.Errorf("error scanning liker: %w", err)
 		}
 
-		likerName, err := db.GetUsername(likerID)
-
-		liker := components.User{
-			Uname: likerName,
-		}
-
-		if err != nil {
-			return components.InternalServerError, fmt.Errorf("error getting liker name: %w", err)
-		}
-
 		likerNames.Names = append(likerNames.Names, liker)
 
 	}
@@ -732,36 +770,61 @@ func (db *appdbimpl) GetPhotoLikes(photoID string) (likes string, err error) {
 	return string(data), nil
 }
 
-func (db *appdbimpl) GetPhotoComments(photoID string) (comments string, err error) {
+func (db *appdbimpl) GetPhotoComments(ctx context.Context, photoID string) (comments string, err error) {
 
-	res, err := db.c.Query(`SELECT c.comment_ID, u.name, c.content, c.creation_date, c.post_code FROM comments as c, posts as p, users as u WHERE c.post_code = p.post_ID AND p.post_ID = ? AND u.ID = c.user_code`, photoID)
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
+
+	res, err := db.c.QueryContext(ctx, `SELECT c.comment_ID, u.name, c.content, c.creation_date, c.post_code FROM comments as c, posts as p, users as u WHERE c.post_code = p.post_ID AND p.post_ID = ? AND u.ID = c.user_code AND u.deactivated_at IS NULL`, photoID)
 
 	if err != nil {
 		return components.InternalServerError,
 			fmt.Errorf("error getting photo's comments: %w", err)
 	}
 
-	commentsList := struct {
-		Comments []components.Comment `json:"comments"`
-	}{
-		Comments: []components.Comment{},
-	}
+	var list []components.Comment
 
 	for res.Next() {
 
-		if res.Err() != nil {
-			return components.InternalServerError, fmt.Errorf("error getting next user: %w", res.Err())
-		}
-
 		var comment components.Comment
 
 		err = res.Scan(&comment.Comment_ID.Hash, &comment.Username.Uname, &comment.Body, &comment.CreationTime, &comment.Parent.Hash)
 
 		if err != nil {
+			_ = res.Close()
 			return components.InternalServerError,
 				fmt.Errorf("error scanning comment: %w", err)
 		}
 
+		list = append(list, comment)
+	}
+
+	// the entities take a query each, made once the result set is closed
+	err = res.Close()
+
+	if err == nil {
+		err = res.Err()
+	}
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error getting photo's comments: %w", err)
+	}
+
+	commentsList := struct {
+		Comments []components.Comment `json:"comments"`
+	}{
+		Comments: []components.Comment{},
+	}
+
+	for _, comment := range list {
+
+		comment.Entities, err = db.entitiesOf(ctx, commentEntityOwner, comment.Comment_ID.Hash, comment.Body)
+
+		if err != nil {
+			return components.InternalServerError,
+				fmt.Errorf("error getting comment entities: %w", err)
+		}
+
 		commentsList.Comments = append(commentsList.Comments, comment)
 
 	}
@@ -776,15 +839,26 @@ func (db *appdbimpl) GetPhotoComments(photoID string) (comments string, err erro
 	return string(data), nil
 }
 
-func (db *appdbimpl) GetUserBans(username string) (bans string, err error) {
+func (db *appdbimpl) GetUserBans(ctx context.Context, username string) (bans string, err error) {
 
-	res, err := db.c.Query(`SELECT b.banished FROM bans as b, users as u WHERE u.name = ? AND u.ID = b.banisher`, username)
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
+
+	res, err := db.c.QueryContext(ctx, `SELECT d.name FROM bans as b, users as u, users as d
+	WHERE u.name = ? AND u.ID = b.banisher AND d.ID = b.banished AND d.deactivated_at IS NULL`, username)
 
 	if err != nil {
 		return components.InternalServerError,
 			fmt.Errorf("error getting user's bans: %w", err)
 	}
 
+	defer func() {
+		err := res.Close()
+		if err != nil {
+			logger(ctx).Errorf("error closing result set: %v", err)
+		}
+	}()
+
 	banList := struct {
 		Bans []components.User `json:"users"`
 	}{
@@ -806,12 +880,6 @@ func (db *appdbimpl) GetUserBans(username string) (bans string, err error) {
 				fmt.Errorf("error scanning ban record: %w", err)
 		}
 
-		ban.Uname, err = db.GetUsername(ban.Uname)
-
-		if err != nil {
-			return components.InternalServerError, fmt.Errorf("error getting banished user name: %w", err)
-		}
-
 		banList.Bans = append(banList.Bans, ban)
 
 	}
@@ -826,21 +894,24 @@ func (db *appdbimpl) GetUserBans(username string) (bans string, err error) {
 	return string(data), nil
 }
 
-func (db *appdbimpl) FollowUser(follower string, followed string) (errstring string, err error) {
+func (db *appdbimpl) FollowUser(ctx context.Context, follower string, followed string) (errstring string, err error) {
+
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
 
-	followerID, err := db.GetUserID(follower)
+	followerID, err := db.GetUserID(ctx, follower)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error getting follower ID: %w", err)
 	}
 
-	followedID, err := db.GetUserID(followed)
+	followedID, err := db.GetUserID(ctx, followed)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error getting followed ID: %w", err)
 	}
 
-	_, err = db.c.Exec(`INSERT OR REPLACE INTO followers (follower, followed) VALUES (?, ?)`, followerID, followedID)
+	_, err = db.c.ExecContext(ctx, `INSERT INTO followers (follower, followed) VALUES (?, ?)`, followerID, followedID)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error inserting follower: %w", err)
@@ -849,21 +920,24 @@ func (db *appdbimpl) FollowUser(follower string, followed string) (errstring str
 	return "", nil
 }
 
-func (db *appdbimpl) UnfollowUser(follower, followed string) (errstring string, err error) {
+func (db *appdbimpl) UnfollowUser(ctx context.Context, follower, followed string) (errstring string, err error) {
+
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
 
-	followerID, err := db.GetUserID(follower)
+	followerID, err := db.GetUserID(ctx, follower)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error getting follower ID: %w", err)
 	}
 
-	followedID, err := db.GetUserID(followed)
+	followedID, err := db.GetUserID(ctx, followed)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error getting followed ID: %w", err)
 	}
 
-	_, err = db.c.Exec(`DELETE FROM followers WHERE follower = ? AND followed = ?`, followerID, followedID)
+	_, err = db.c.ExecContext(ctx, `DELETE FROM followers WHERE follower = ? AND followed = ?`, followerID, followedID)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error deleting follower: %w", err)
@@ -872,11 +946,14 @@ func (db *appdbimpl) UnfollowUser(follower, followed string) (errstring string,
 	return "", nil
 }
 
-func (db *appdbimpl) Validate(username string, ID string) (is_valid bool, err error) {
+func (db *appdbimpl) Validate(ctx context.Context, username string, token string) (is_valid bool, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
 
 	count := 0
 
-	err = db.c.QueryRow(`SELECT COUNT(*) FROM users as u WHERE u.ID = ? AND u.name = ?`, ID, username).Scan(&count)
+	err = db.c.QueryRowContext(ctx, `SELECT COUNT(*) FROM users as u WHERE u.token = ? AND u.name = ? AND u.deactivated_at IS NULL`, token, username).Scan(&count)
 	is_valid = count == 1
 
 	if err != nil {
@@ -887,21 +964,40 @@ func (db *appdbimpl) Validate(username string, ID string) (is_valid bool, err er
 
 }
 
-func (db *appdbimpl) BanUser(banisher, banished string) (errstring string, err error) {
+func (db *appdbimpl) Authenticate(ctx context.Context, token string) (ID string, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
+
+	err = db.c.QueryRowContext(ctx, `SELECT ID FROM users WHERE token = ? AND deactivated_at IS NULL`, token).Scan(&ID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("error authenticating user: %w", err)
+	}
+
+	return ID, nil
+}
+
+func (db *appdbimpl) BanUser(ctx context.Context, banisher, banished string) (errstring string, err error) {
+
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
 
-	banisherID, err := db.GetUserID(banisher)
+	banisherID, err := db.GetUserID(ctx, banisher)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error getting banisher ID: %w", err)
 	}
 
-	banishedID, err := db.GetUserID(banished)
+	banishedID, err := db.GetUserID(ctx, banished)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error getting banished ID: %w", err)
 	}
 
-	_, err = db.c.Exec(`INSERT OR REPLACE INTO bans (banisher, banished) VALUES (?, ?)`, banisherID, banishedID)
+	_, err = db.c.ExecContext(ctx, `INSERT INTO bans (banisher, banished) VALUES (?, ?)`, banisherID, banishedID)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error inserting ban: %w", err)
@@ -910,21 +1006,24 @@ func (db *appdbimpl) BanUser(banisher, banished string) (errstring string, err e
 	return "", nil
 }
 
-func (db *appdbimpl) UnbanUser(banisher, banished string) (errstring string, err error) {
+func (db *appdbimpl) UnbanUser(ctx context.Context, banisher, banished string) (errstring string, err error) {
+
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
 
-	banisherID, err := db.GetUserID(banisher)
+	banisherID, err := db.GetUserID(ctx, banisher)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error getting banisher ID: %w", err)
 	}
 
-	banishedID, err := db.GetUserID(banished)
+	banishedID, err := db.GetUserID(ctx, banished)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error getting banished ID: %w", err)
 	}
 
-	_, err = db.c.Exec(`DELETE FROM bans WHERE banisher = ? AND banished = ?`, banisherID, banishedID)
+	_, err = db.c.ExecContext(ctx, `DELETE FROM bans WHERE banisher = ? AND banished = ?`, banisherID, banishedID)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error deleting ban: %w", err)
@@ -933,13 +1032,12 @@ func (db *appdbimpl) UnbanUser(banisher, banished string) (errstring string, err
 	return "", nil
 }
 
-func (db *appdbimpl) LikePhoto(likerID, photoID string) (errstring string, err error) {
+func (db *appdbimpl) LikePhoto(ctx context.Context, likerID, photoID string) (errstring string, err error) {
 
-	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error getting user ID: %w", err)
-	}
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
 
-	_, err = db.c.Exec(`INSERT OR REPLACE INTO likes (post_ID, liker) VALUES (?, ?)`, photoID, likerID)
+	_, err = db.c.ExecContext(ctx, `INSERT INTO likes (post_ID, liker) VALUES (?, ?)`, photoID, likerID)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error inserting like: %w", err)
@@ -948,13 +1046,12 @@ func (db *appdbimpl) LikePhoto(likerID, photoID string) (errstring string, err e
 	return "", nil
 }
 
-func (db *appdbimpl) UnlikePhoto(likerID, photoID string) (errstring string, err error) {
+func (db *appdbimpl) UnlikePhoto(ctx context.Context, likerID, photoID string) (errstring string, err error) {
 
-	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error getting user ID: %w", err)
-	}
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
 
-	_, err = db.c.Exec(`DELETE FROM likes WHERE post_ID = ? AND liker = ?`, photoID, likerID)
+	_, err = db.c.ExecContext(ctx, `DELETE FROM likes WHERE post_ID = ? AND liker = ?`, photoID, likerID)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error deleting like: %w", err)
@@ -963,9 +1060,12 @@ func (db *appdbimpl) UnlikePhoto(likerID, photoID string) (errstring string, err
 	return "", nil
 }
 
-func (db *appdbimpl) CommentPhoto(username string, photoID string, comment components.Comment) (errstring string, err error) {
+func (db *appdbimpl) CommentPhoto(ctx context.Context, username string, photoID string, comment components.Comment) (errstring string, err error) {
 
-	userID, err := db.GetUserID(username)
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
+
+	userID, err := db.GetUserID(ctx, username)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error getting user ID: %w", err)
@@ -973,169 +1073,236 @@ func (db *appdbimpl) CommentPhoto(username string, photoID string, comment compo
 
 	comment_id := comment.Comment_ID.Hash
 
-	_, err = db.c.Exec(`INSERT OR REPLACE INTO comments (comment_ID, post_code, user_code, content, creation_date ) VALUES (?, ?, ?, ?, ?)`, comment_id, comment.Parent.Hash, userID, comment.Body, time.Time(comment.CreationTime))
+	err = db.inTx(ctx, func(tx txconn) error {
+
+		_, err := tx.ExecContext(ctx, `INSERT INTO comments (comment_ID, post_code, user_code, content, creation_date ) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (comment_ID) DO UPDATE SET post_code = excluded.post_code, user_code = excluded.user_code,
+			content = excluded.content, creation_date = excluded.creation_date`, comment_id, comment.Parent.Hash, userID, comment.Body, time.Time(comment.CreationTime))
+
+		if err != nil {
+			return fmt.Errorf("error inserting comment: %w", err)
+		}
+
+		err = indexEntities(ctx, tx, commentEntityOwner, comment_id, comment.Body)
+
+		if err != nil {
+			return fmt.Errorf("error indexing comment: %w", err)
+		}
+
+		return nil
+	})
 
 	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error inserting comment: %w", err)
+		return components.InternalServerError, err
 	}
 
 	return "", nil
 }
 
-func (db *appdbimpl) UncommentPhoto(username string, photoID string, comment_id string) (errstring string, err error) {
+func (db *appdbimpl) UncommentPhoto(ctx context.Context, username string, photoID string, comment_id string) (errstring string, err error) {
+
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
 
-	userID, err := db.GetUserID(username)
+	userID, err := db.GetUserID(ctx, username)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error getting user ID: %w", err)
 	}
 
-	_, err = db.c.Exec(`DELETE FROM comments WHERE comment_ID = ? AND post_code = ? AND user_code = ?`, comment_id, photoID, userID)
+	err = db.inTx(ctx, func(tx txconn) error {
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM comments WHERE comment_ID = ? AND post_code = ? AND user_code = ?`, comment_id, photoID, userID)
+
+		if err != nil {
+			return fmt.Errorf("error deleting comment: %w", err)
+		}
+
+		deleted, err := res.RowsAffected()
+
+		if err != nil {
+			return fmt.Errorf("error deleting comment: %w", err)
+		}
+
+		if deleted == 0 {
+			return nil
+		}
+
+		// foreign keys may not be enforced (e.g., on SQLite without `_foreign_keys`), the index rows of the comment go explicitly
+		return indexEntities(ctx, tx, commentEntityOwner, comment_id, "")
+	})
 
 	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error deleting comment: %w", err)
+		return components.InternalServerError, err
 	}
 
 	return "", nil
 }
 
-func (db *appdbimpl) UploadPhoto(username string, photo components.Photo, photo_ID string) (errstring string, err error) {
+func (db *appdbimpl) UploadPhoto(ctx context.Context, username string, photo components.Photo, photo_ID string) (errstring string, err error) {
+
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
 
-	var data string = photo.Data
+	// Decode every image first, a bad one rejects the whole post
 
-	encoded_data, err := base64.StdEncoding.DecodeString(data)
+	images, err := decodeMedia(photo)
 
 	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error encoding data: %w", err)
+		return components.BadRequestError, err
 	}
 
 	// Get user ID
 
-	userID, err := db.GetUserID(username)
+	userID, err := db.GetUserID(ctx, username)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error getting user ID: %w", err)
 	}
 
-	// Get current time
-
-	creation_time := time.Now().Format(time.RFC3339)
-
-	// Insert photo
+	// If the post is being replaced, its extra images will have to go
 
-	_, err = db.c.Exec(`INSERT OR REPLACE INTO posts (post_ID, poster_ID, description, creation_date) VALUES (?, ?, ?, ?)`, photo_ID, userID, photo.Desc, creation_time)
+	old_media, err := db.postMedia(ctx, photo_ID)
 
 	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error inserting photo: %w", err)
+		return components.InternalServerError, err
 	}
 
-	PNG_reader := bytes.NewReader(encoded_data)
+	// Get current time
 
-	img, err := png.Decode(PNG_reader)
+	creation_time := time.Now().Format(time.RFC3339)
 
-	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error decoding PNG: %w", err)
-	}
+	// Insert the post, its media items and its index rows together. The images are staged last, right before
+	// the commit, and published only once the rows are there.
+
+	err = db.inTx(ctx, func(tx txconn) error {
 
-	_, err = os.Stat("/tmp/photos")
+		_, err := tx.ExecContext(ctx, `INSERT INTO posts (post_ID, poster_ID, description, creation_date) VALUES (?, ?, ?, ?)
+		ON CONFLICT (post_ID) DO UPDATE SET poster_ID = excluded.poster_ID, description = excluded.description,
+			creation_date = excluded.creation_date, archived_at = NULL, deleted_at = NULL`, photo_ID, userID, photo.Desc, creation_time)
 
-	if os.IsNotExist(err) {
-		err = os.Mkdir("/tmp/photos", 0755)
 		if err != nil {
-			return components.InternalServerError, fmt.Errorf("error creating /tmp/photos: %w", err)
+			return fmt.Errorf("error inserting photo: %w", err)
 		}
-	}
 
-	f, err := os.OpenFile("/tmp/photos/"+photo_ID+".png", os.O_WRONLY|os.O_CREATE, 0777)
+		_, err = tx.ExecContext(ctx, `DELETE FROM media WHERE post_ID = ?`, photo_ID)
 
-	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error creating file: %w", err)
-	}
+		if err != nil {
+			return fmt.Errorf("error clearing media: %w", err)
+		}
 
-	defer f.Close()
+		for i := range images {
 
-	err = png.Encode(f, img)
+			alt_text := ""
 
-	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error encoding PNG: %w", err)
-	}
+			if len(photo.Media) > 0 {
+				alt_text = photo.Media[i].AltText
+			}
 
-	return "", nil
-}
+			_, err = tx.ExecContext(ctx, `INSERT INTO media (media_ID, post_ID, position, alt_text) VALUES (?, ?, ?, ?)`, mediaID(photo_ID, i), photo_ID, i, alt_text)
+
+			if err != nil {
+				return fmt.Errorf("error inserting media %d: %w", i, err)
+			}
 
-func (db *appdbimpl) DeletePhoto(username string, photoID string) (errstring string, err error) {
+			size := images[i].Bounds().Size()
 
-	userID, err := db.GetUserID(username)
+			_, err = tx.ExecContext(ctx, `INSERT INTO photo_metadata (media_ID, dominant_color, width, height) VALUES (?, ?, ?, ?)
+			ON CONFLICT (media_ID) DO UPDATE SET dominant_color = excluded.dominant_color, width = excluded.width,
+				height = excluded.height`,
+				mediaID(photo_ID, i), dominantColor(images[i]), size.X, size.Y)
 
-	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error getting user ID: %w", err)
-	}
+			if err != nil {
+				return fmt.Errorf("error inserting metadata of media %d: %w", i, err)
+			}
+		}
+
+		err = indexEntities(ctx, tx, postEntityOwner, photo_ID, photo.Desc)
+
+		if err != nil {
+			return fmt.Errorf("error indexing photo description: %w", err)
+		}
 
-	_, err = db.c.Exec(`DELETE FROM posts WHERE post_ID = ? AND poster_ID = ?`, photoID, userID)
+		return stageImages(photo_ID, images)
+	})
 
 	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error deleting photo: %w", err)
+		discardImages(photo_ID, len(images))
+		return components.InternalServerError, err
 	}
 
-	// erase from /tmp/photos
-
-	err = os.Remove("/tmp/photos/" + photoID + ".png")
+	err = publishImages(photo_ID, len(images))
 
 	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error deleting photo: %w", err)
+		return components.InternalServerError, err
+	}
+
+	if len(old_media) > len(images) {
+		for _, m := range old_media[len(images):] {
+			removeImages([]string{m.Media_ID.Hash})
+		}
 	}
 
 	return "", nil
 }
 
-func (db *appdbimpl) ChangeUsername(user_name string, new_username string) (errstring string, err error) {
+func (db *appdbimpl) DeletePhoto(ctx context.Context, username string, photoID string) (errstring string, err error) {
+
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
 
-	userID, err := db.GetUserID(user_name)
+	userID, err := db.GetUserID(ctx, username)
 
 	if err != nil {
 		return components.InternalServerError, fmt.Errorf("error getting user ID: %w", err)
 	}
 
-	// Check if username is taken
+	// The post is only marked as deleted, so that it can be restored for a while: the janitor purges it, together with
+	// its images, once the retention period is over (see PurgeDeletedPhotos). Not a photo of this user, nothing happens.
 
-	rows, err := db.c.Query(`SELECT ID FROM users WHERE name = ?`, new_username)
+	_, err = db.c.ExecContext(ctx, `UPDATE posts SET deleted_at = ? WHERE post_ID = ? AND poster_ID = ? AND deleted_at IS NULL`,
+		time.Now().UTC().Format(time.RFC3339), photoID, userID)
 
 	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error checking if username is taken: %w", err)
+		return components.InternalServerError, fmt.Errorf("error deleting photo: %w", err)
 	}
 
-	defer func() {
-		err := rows.Close()
-		if err != nil {
-			logrus.Error(err)
-		}
-	}()
-
-	if rows.Next() {
+	return "", nil
+}
 
-		if rows.Err() != nil {
-			return components.InternalServerError, fmt.Errorf("error checking if username is taken: %w", err)
-		}
+func (db *appdbimpl) ChangeUsername(ctx context.Context, user_name string, new_username string) (errstring string, err error) {
 
-		return components.ConflictError, fmt.Errorf("username is taken")
-	}
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
 
-	_, err = db.c.Exec(`UPDATE users SET name = ? WHERE ID = ?`, new_username, userID)
+	userID, err := db.GetUserID(ctx, user_name)
 
-	// Generate new ID for user
+	// renamed (or deleted) in the meantime
+	if errors.Is(err, sql.ErrNoRows) {
+		return components.NotFoundError, fmt.Errorf("error getting user ID: %w", err)
+	}
 
 	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error changing username: %w", err)
+		return components.InternalServerError, fmt.Errorf("error getting user ID: %w", err)
 	}
 
-	h := sha256.New()
-	h.Write([]byte(new_username))
-	newID := hex.EncodeToString(h.Sum(nil))
+	var newID string
 
-	_, err = db.c.Exec(`UPDATE users SET ID = ? WHERE ID = ?`, newID, userID)
+	err = db.inTx(ctx, func(tx txconn) error {
+		newID, err = renameUser(ctx, tx, userID, new_username)
+		return err
+	})
+
+	if errors.Is(err, errUsernameTaken) {
+		return components.ConflictError, err
+	}
+
+	if errors.Is(err, errUserRenamed) {
+		return components.NotFoundError, err
+	}
 
 	if err != nil {
-		return components.InternalServerError, fmt.Errorf("error changing username: %w", err)
+		return components.InternalServerError, err
 	}
 
 	ret := components.SHA256hash{
@@ -1151,75 +1318,126 @@ func (db *appdbimpl) ChangeUsername(user_name string, new_username string) (errs
 	return string(ret_str), nil
 }
 
-func (db *appdbimpl) GetStream(user_name string, from, offset int) (stream string, err error) {
+// errUsernameTaken is returned by renameUser when the new name belongs to another user
+var errUsernameTaken = errors.New("username is taken")
+
+// errUserRenamed is returned by renameUser when the user is no longer there under its ID, renamed in the meantime
+var errUserRenamed = errors.New("user renamed concurrently")
+
+// userReferences are the columns that refer to a user by ID, rewritten by renameUser
+var userReferences = []struct{ table, column string }{
+	{"bans", "banisher"}, {"bans", "banished"},
+	{"followers", "follower"}, {"followers", "followed"},
+	{"posts", "poster_ID"},
+	{"likes", "liker"},
+	{"comments", "user_code"},
+	{"post_mentions", "user_ID"}, {"comment_mentions", "user_ID"},
+	{"profiles", "user_ID"},
+	{"export_jobs", "user_ID"},
+}
+
+// renameUser changes the name of the user `userID`, and therefore its ID, returning the new one. The ID is changed in
+// every table referring to the user too, since foreign keys (and their ON UPDATE CASCADE) may not be enforced (e.g., on
+// SQLite connections opened without `_foreign_keys`).
+func renameUser(ctx context.Context, tx dbtx, userID string, newName string) (newID string, err error) {
+
+	var count int
 
-	userID, err := db.GetUserID(user_name)
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE name = ?`, newName).Scan(&count)
 
 	if err != nil {
-		logrus.Errorf("error getting user ID: %v", err)
-		return components.InternalServerError, fmt.Errorf("error getting user ID: %w", err)
+		return "", fmt.Errorf("error checking if username is taken: %w", err)
+	}
+
+	if count > 0 {
+		return "", errUsernameTaken
 	}
 
-	rows, err := db.c.Query(`SELECT post_ID, poster_ID, description, creation_date 
-	FROM posts AS p, followers AS f 
-	WHERE ((f.follower = ? 
-	AND f.followed = p.poster_ID) OR (p.poster_ID = ?))
-	AND ? NOT IN (
-		SELECT banished FROM bans WHERE banisher = p.poster_ID AND banished = ?
-	)  ORDER BY p.creation_date DESC LIMIT ?, ?`, userID, userID, userID, userID, from, offset)
+	// Generate new ID for user
+
+	h := sha256.New()
+	h.Write([]byte(newName))
+	newID = hex.EncodeToString(h.Sum(nil))
+
+	res, err := tx.ExecContext(ctx, `UPDATE users SET name = ?, ID = ? WHERE ID = ?`, newName, newID, userID)
+
+	// a concurrent rename to the same name got there first
+	if isUniqueViolation(err) {
+		return "", errUsernameTaken
+	}
 
 	if err != nil {
-		logrus.Errorf("error getting stream: %v", err)
-		return components.InternalServerError, fmt.Errorf("error getting stream: %w", err)
+		return "", fmt.Errorf("error changing username: %w", err)
 	}
 
-	defer func() {
-		err = rows.Close()
-		if err != nil {
-			logrus.Errorf("error closing rows: %v", err)
-		}
-	}()
+	renamed, err := res.RowsAffected()
 
-	posts := struct {
-		Posts []components.Post `json:"posts"`
-	}{
-		Posts: []components.Post{},
+	if err != nil {
+		return "", fmt.Errorf("error changing username: %w", err)
 	}
 
-	for rows.Next() {
+	// a concurrent rename of the same user got there first, the other tables already refer to its new ID
+	if renamed == 0 {
+		return "", errUserRenamed
+	}
 
-		// for each retrieved post, get the comments and likes
+	for _, ref := range userReferences {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET %s = ? WHERE %s = ?`, ref.table, ref.column, ref.column), newID, userID)
 
-		if rows.Err() != nil {
-			logrus.Errorf("error getting post in the stream: %v", err)
-			return components.InternalServerError, fmt.Errorf("error getting post in the stream: %w", err)
+		if err != nil {
+			return "", fmt.Errorf("error changing user ID in %s: %w", ref.table, err)
 		}
+	}
 
-		var post components.Post
+	return newID, nil
+}
 
-		err := rows.Scan(&post.Photo_ID.Hash, &post.Author_Name.Uname, &post.Description, &post.CreationTime)
+func (db *appdbimpl) GetStream(ctx context.Context, user_name string, from, offset int) (stream string, err error) {
 
-		if err != nil {
-			logrus.Errorf("error scanning row: %v", err)
-			return components.InternalServerError, fmt.Errorf("error scanning row: %w", err)
-		}
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
 
-		auth_name, err := db.GetUsername(post.Author_Name.Uname)
+	userID, err := db.GetUserID(ctx, user_name)
 
-		if err != nil {
-			logrus.Errorf("error getting author name: %v", err)
-			return components.InternalServerError, fmt.Errorf("error getting author name: %w", err)
-		}
+	if err != nil {
+		logger(ctx).Errorf("error getting user ID: %v", err)
+		return components.InternalServerError, fmt.Errorf("error getting user ID: %w", err)
+	}
+
+	// archived posts only show up in the stream of their author. Each post is a single row (the followed users are a
+	// subquery, not a join), ordered by ID too, so that pages don't overlap.
+	rows, err := db.c.QueryContext(ctx, `SELECT p.post_ID, p.poster_ID, p.description, p.creation_date, p.archived_at IS NOT NULL
+	FROM posts AS p
+	WHERE (p.poster_ID = ? OR (p.archived_at IS NULL AND p.poster_ID IN (
+		SELECT followed FROM followers WHERE follower = ?
+	)))
+	AND p.deleted_at IS NULL
+	AND p.poster_ID NOT IN (
+		SELECT banisher FROM bans WHERE banished = ?
+	) AND p.poster_ID NOT IN (
+		SELECT ID FROM users WHERE deactivated_at IS NOT NULL
+	) ORDER BY p.creation_date DESC, p.post_ID LIMIT ? OFFSET ?`, userID, userID, userID, offset, from)
 
-		post.Author_Name.Uname = auth_name
+	if err != nil {
+		logger(ctx).Errorf("error getting stream: %v", err)
+		return components.InternalServerError, fmt.Errorf("error getting stream: %w", err)
+	}
 
-		posts.Posts = append(posts.Posts, post)
+	posts := struct {
+		Posts []components.Post `json:"posts"`
+	}{}
+
+	posts.Posts, err = db.scanPosts(ctx, rows)
+
+	if err != nil {
+		logger(ctx).Errorf("error getting posts of the stream: %v", err)
+		return components.InternalServerError, err
 	}
 
 	stream_data, err := json.MarshalIndent(posts, "", "  ")
 
 	if err != nil {
-		logrus.Errorf("error marshaling stream: %v", err)
+		logger(ctx).Errorf("error marshaling stream: %v", err)
 		return components.InternalServerError, fmt.Errorf("error marshaling stream: %w", err)
 	}
 
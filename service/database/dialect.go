@@ -0,0 +1,143 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Dialect is the flavour of SQL spoken by the database behind an AppDatabase, named after its database/sql driver.
+//
+// Queries are written once, with `?` placeholders and in the subset of SQL shared by SQLite and PostgreSQL (e.g.,
+// `LIMIT ? OFFSET ?`, `ON CONFLICT`); conn rewrites the placeholders for PostgreSQL. The few statements that cannot be
+// shared ask the dialect for their variant. Each dialect has its own migrations, in migrations/<dialect>.
+type Dialect string
+
+// Dialects supported by New
+const (
+	SQLite   Dialect = "sqlite3"
+	Postgres Dialect = "postgres"
+)
+
+// Valid returns whether New supports the dialect `d`.
+func (d Dialect) Valid() bool {
+	return d == SQLite || d == Postgres
+}
+
+// rebind rewrites the `?` placeholders of `query` to the numbered `$1, $2, ...` of PostgreSQL. Question marks inside
+// string literals are left alone.
+func (d Dialect) rebind(query string) string {
+
+	if d != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	quoted := false
+
+	for _, r := range query {
+
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// position returns the expression for the 1-based index of `needle` in `haystack`, 0 if missing.
+func (d Dialect) position(haystack, needle string) string {
+
+	if d == Postgres {
+		return fmt.Sprintf("strpos(%s, %s)", haystack, needle)
+	}
+
+	return fmt.Sprintf("instr(%s, %s)", haystack, needle)
+}
+
+// isUniqueViolation returns whether `err` comes from a statement that broke a UNIQUE (or PRIMARY KEY) constraint.
+// SQLite does not tell apart the kinds of constraint, so any violation counts.
+func isUniqueViolation(err error) bool {
+
+	var sqliteErr sqlite3.Error
+
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrConstraint
+	}
+
+	var pqErr *pq.Error
+
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+
+	return false
+}
+
+// conn is the connection pool of an AppDatabase: the Context methods rebind their queries to the dialect, the others
+// (e.g., Exec) are those of sql.DB and must not be used with `?` placeholders. Queries go to the `readers` pool, if
+// any, everything else (including transactions) to the embedded one. Every statement is counted in stats.
+type conn struct {
+	*sql.DB
+	readers *sql.DB
+	dialect Dialect
+}
+
+func (c conn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	stats.Add("execs", 1)
+	return c.DB.ExecContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c conn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	stats.Add("queries", 1)
+	return c.reader().QueryContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c conn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	stats.Add("queries", 1)
+	return c.reader().QueryRowContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c conn) reader() *sql.DB {
+
+	if c.readers != nil {
+		return c.readers
+	}
+
+	return c.DB
+}
+
+// txconn is a transaction of a conn, its Context methods rebind (and count) their queries as well.
+type txconn struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+func (t txconn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	stats.Add("execs", 1)
+	return t.Tx.ExecContext(ctx, t.dialect.rebind(query), args...)
+}
+
+func (t txconn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	stats.Add("queries", 1)
+	return t.Tx.QueryContext(ctx, t.dialect.rebind(query), args...)
+}
+
+func (t txconn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	stats.Add("queries", 1)
+	return t.Tx.QueryRowContext(ctx, t.dialect.rebind(query), args...)
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+)
+
+// entityOwner describes where the hashtags and mentions of a text are indexed: posts (descriptions) and comments
+// (bodies) have their own pair of index tables.
+type entityOwner struct {
+	tagTable     string
+	mentionTable string
+	key          string
+}
+
+var (
+	postEntityOwner    = entityOwner{tagTable: "post_tags", mentionTable: "post_mentions", key: "post_ID"}
+	commentEntityOwner = entityOwner{tagTable: "comment_tags", mentionTable: "comment_mentions", key: "comment_ID"}
+)
+
+// indexEntities parses `text` and replaces the hashtag and mention index rows of the post or comment `ID`, within the
+// transaction that writes the text. Mentions of users that do not exist are not indexed, and therefore will not be
+// rendered as links.
+func indexEntities(ctx context.Context, tx dbtx, owner entityOwner, ID string, text string) error {
+
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE %s = ?`, owner.tagTable, owner.key), ID)
+
+	if err != nil {
+		return fmt.Errorf("error clearing hashtags: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE %s = ?`, owner.mentionTable, owner.key), ID)
+
+	if err != nil {
+		return fmt.Errorf("error clearing mentions: %w", err)
+	}
+
+	entities := components.ParseEntities(text)
+
+	for _, tag := range components.Tags(entities) {
+
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (%s, tag) VALUES (?, ?) ON CONFLICT DO NOTHING`, owner.tagTable, owner.key), ID, tag)
+
+		if err != nil {
+			return fmt.Errorf("error indexing hashtag %s: %w", tag, err)
+		}
+	}
+
+	for _, name := range components.Mentions(entities) {
+
+		var userID string
+
+		err = tx.QueryRowContext(ctx, `SELECT ID FROM users WHERE name = ?`, name).Scan(&userID)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("error validating mention of %s: %w", name, err)
+		}
+
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (%s, user_ID) VALUES (?, ?) ON CONFLICT DO NOTHING`, owner.mentionTable, owner.key), ID, userID)
+
+		if err != nil {
+			return fmt.Errorf("error indexing mention of %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// entitiesOf parses `text` for the response payload, dropping the mentions that were not validated when the post or
+// comment `ID` was written.
+func (db *appdbimpl) entitiesOf(ctx context.Context, owner entityOwner, ID string, text string) (entities []components.Entity, err error) {
+
+	parsed := components.ParseEntities(text)
+
+	if len(components.Mentions(parsed)) == 0 {
+		return parsed, nil
+	}
+
+	res, err := db.c.QueryContext(ctx, fmt.Sprintf(`SELECT u.name FROM %s AS m, users AS u
+	WHERE m.%s = ? AND u.ID = m.user_ID AND u.deactivated_at IS NULL`,
+		owner.mentionTable, owner.key), ID)
+
+	if err != nil {
+		return nil, fmt.Errorf("error getting mentions: %w", err)
+	}
+
+	defer func() {
+		err := res.Close()
+		if err != nil {
+			logger(ctx).Errorf("error closing result set: %v", err)
+		}
+	}()
+
+	mentioned := map[string]bool{}
+
+	for res.Next() {
+
+		var name string
+
+		err = res.Scan(&name)
+
+		if err != nil {
+			return nil, fmt.Errorf("error scanning mention: %w", err)
+		}
+
+		mentioned[name] = true
+	}
+
+	if res.Err() != nil {
+		return nil, fmt.Errorf("error getting next mention: %w", res.Err())
+	}
+
+	entities = []components.Entity{}
+
+	for _, e := range parsed {
+		if e.Type != components.EntityMention || mentioned[e.Value] {
+			entities = append(entities, e)
+		}
+	}
+
+	return entities, nil
+}
+
+func (db *appdbimpl) GetTagPhotos(ctx context.Context, tag string, username string, from, offset int) (photos string, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
+
+	userID, err := db.GetUserID(ctx, username)
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error getting user ID: %w", err)
+	}
+
+	// Posts whose author banned the requester are not listed, nor are those archived by others, as in the stream; and as
+	// there, they are ordered by ID too, so that pages don't overlap
+	rows, err := db.c.QueryContext(ctx, `SELECT p.post_ID, p.poster_ID, p.description, p.creation_date, p.archived_at IS NOT NULL
+	FROM posts AS p, post_tags AS t
+	WHERE t.tag = ? AND t.post_ID = p.post_ID
+	AND (p.archived_at IS NULL OR p.poster_ID = ?) AND p.deleted_at IS NULL
+	AND p.poster_ID NOT IN (
+		SELECT banisher FROM bans WHERE banished = ?
+		UNION SELECT ID FROM users WHERE deactivated_at IS NOT NULL
+	) ORDER BY p.creation_date DESC, p.post_ID LIMIT ? OFFSET ?`, components.NormalizeTag(tag), userID, userID, offset, from)
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error getting tagged photos: %w", err)
+	}
+
+	posts, err := db.scanPosts(ctx, rows)
+
+	if err != nil {
+		return components.InternalServerError, err
+	}
+
+	data, err := json.MarshalIndent(components.Stream{Posts: posts}, "", "	")
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error converting tagged photos to JSON: %w", err)
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+)
+
+func TestConformanceTagPhotosPaging(t *testing.T) {
+	conform(t, func(t *testing.T, db *appdbimpl) {
+		ctx := context.Background()
+
+		_, err := db.PostUserID(ctx, "alice")
+		if err != nil {
+			t.Fatalf("creating alice: %v", err)
+		}
+
+		// posted within the same second, most of them with the same creation date
+		const posts, page = 7, 2
+		for i := 0; i < posts; i++ {
+			photoID := fmt.Sprintf("tagged-%d", i)
+			removePhotos(t, photoID)
+			_, err := db.UploadPhoto(ctx, "alice", testPhoto(t, "#Coffee time"), photoID)
+			if err != nil {
+				t.Fatalf("posting %s: %v", photoID, err)
+			}
+		}
+
+		seen := map[string]bool{}
+		for from := 0; from < posts; from += page {
+			res, err := db.GetTagPhotos(ctx, "coffee", "alice", from, page)
+			if err != nil {
+				t.Fatalf("getting the page from %d: %v", from, err)
+			}
+			var stream components.Stream
+			if err := json.Unmarshal([]byte(res), &stream); err != nil {
+				t.Fatalf("decoding %s: %v", res, err)
+			}
+			for _, p := range stream.Posts {
+				if seen[p.Photo_ID.Hash] {
+					t.Errorf("%s is on two pages", p.Photo_ID.Hash)
+				}
+				seen[p.Photo_ID.Hash] = true
+			}
+		}
+		if len(seen) != posts {
+			t.Errorf("%d posts over the pages, want %d", len(seen), posts)
+		}
+	})
+}
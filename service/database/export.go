@@ -0,0 +1,660 @@
+package database
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+)
+
+// ExportDir is the directory where the archives of the export jobs are stored, one ZIP file per job.
+const ExportDir = "/tmp/exports"
+
+// ExportPath returns the path of the archive of the export job `jobID`.
+func ExportPath(jobID string) string {
+	return filepath.Join(ExportDir, jobID+".zip")
+}
+
+// An export archive holds the personal data of a user as JSON files, in the same format as the API responses, and the
+// original images of their posts in photos/. The data are read one file at a time, not as a single snapshot.
+
+func (db *appdbimpl) CreateExport(ctx context.Context, username string, retention time.Duration) (job string, err error) {
+
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
+
+	userID, err := db.GetUserID(ctx, username)
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error getting user ID: %w", err)
+	}
+
+	newID, err := randomID()
+
+	if err != nil {
+		return components.InternalServerError, err
+	}
+
+	var jobID string
+
+	err = db.inTx(ctx, func(tx txconn) error {
+
+		// an export still to be built already has every datum of the user
+		err := tx.QueryRowContext(ctx, `SELECT job_ID FROM export_jobs WHERE user_ID = ? AND finished_at IS NULL`,
+			userID).Scan(&jobID)
+
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		jobID = newID
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO export_jobs (job_ID, user_ID, status, created_at) VALUES (?, ?, ?, ?)`,
+			jobID, userID, components.ExportPending, time.Now().UTC().Format(time.RFC3339))
+
+		return err
+	})
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error creating export job: %w", err)
+	}
+
+	return db.exportJob(ctx, username, jobID, retention)
+}
+
+func (db *appdbimpl) GetExport(ctx context.Context, username string, jobID string, retention time.Duration) (job string, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
+
+	return db.exportJob(ctx, username, jobID, retention)
+}
+
+// exportJob returns the export job `jobID` of `username` as JSON.
+func (db *appdbimpl) exportJob(ctx context.Context, username string, jobID string, retention time.Duration) (job string, err error) {
+
+	var status, createdAt string
+	var finishedAt sql.NullString
+
+	err = db.c.QueryRowContext(ctx, `SELECT e.status, e.created_at, e.finished_at FROM export_jobs AS e, users AS u
+	WHERE e.job_ID = ? AND u.ID = e.user_ID AND u.name = ?`, jobID, username).Scan(&status, &createdAt, &finishedAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return components.NotFoundError, fmt.Errorf("export job %s of %s does not exist", jobID, username)
+	}
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error getting export job: %w", err)
+	}
+
+	created, err := time.Parse(time.RFC3339, createdAt)
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error parsing creation time of export job %s: %w", jobID, err)
+	}
+
+	exportJob := components.ExportJob{
+		Job_ID:    components.SHA256hash{Hash: jobID},
+		Status:    status,
+		CreatedAt: components.JSONTime(created),
+	}
+
+	if finishedAt.Valid {
+
+		finished, err := time.Parse(time.RFC3339, finishedAt.String)
+
+		if err != nil {
+			return components.InternalServerError, fmt.Errorf("error parsing end time of export job %s: %w", jobID, err)
+		}
+
+		expires := components.JSONTime(finished.Add(retention))
+		exportJob.FinishedAt = (*components.JSONTime)(&finished)
+
+		if status == components.ExportDone {
+			exportJob.ExpiresAt = &expires
+		}
+	}
+
+	data, err := exportJob.ToJSON()
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error converting export job to JSON: %w", err)
+	}
+
+	return string(data), nil
+}
+
+func (db *appdbimpl) ExportReady(ctx context.Context, username string, jobID string) (errstring string, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
+
+	var status string
+
+	err = db.c.QueryRowContext(ctx, `SELECT e.status FROM export_jobs AS e, users AS u
+	WHERE e.job_ID = ? AND u.ID = e.user_ID AND u.name = ?`, jobID, username).Scan(&status)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return components.NotFoundError, fmt.Errorf("export job %s of %s does not exist", jobID, username)
+	}
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error getting export job: %w", err)
+	}
+
+	if status != components.ExportDone {
+		return components.ConflictError, fmt.Errorf("export job %s is %s", jobID, status)
+	}
+
+	return "", nil
+}
+
+func (db *appdbimpl) RunNextExport(ctx context.Context) (ran bool, err error) {
+
+	var jobID, userID string
+
+	// a job left running belongs to a run interrupted by a restart, and is built again
+	claim := func(ctx context.Context) error {
+
+		ctx, cancel := db.writing(ctx)
+		defer cancel()
+
+		err := db.c.QueryRowContext(ctx, `SELECT job_ID, user_ID FROM export_jobs WHERE finished_at IS NULL
+		ORDER BY created_at LIMIT 1`).Scan(&jobID, &userID)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = db.c.ExecContext(ctx, `UPDATE export_jobs SET status = ? WHERE job_ID = ?`, components.ExportRunning, jobID)
+
+		return err
+	}
+
+	err = claim(ctx)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("error getting next export job: %w", err)
+	}
+
+	err = db.writeExport(ctx, userID, ExportPath(jobID))
+
+	// left unfinished, to be resumed on the next start
+	if ctx.Err() != nil {
+		return true, ctx.Err()
+	}
+
+	status := components.ExportDone
+
+	if err != nil {
+		status = components.ExportFailed
+		err = fmt.Errorf("error building export %s: %w", jobID, err)
+	}
+
+	finish := func(ctx context.Context) error {
+
+		ctx, cancel := db.writing(ctx)
+		defer cancel()
+
+		_, err := db.c.ExecContext(ctx, `UPDATE export_jobs SET status = ?, finished_at = ? WHERE job_ID = ?`,
+			status, time.Now().UTC().Format(time.RFC3339), jobID)
+
+		return err
+	}
+
+	if finishErr := finish(ctx); finishErr != nil && err == nil {
+		err = fmt.Errorf("error finishing export %s: %w", jobID, finishErr)
+	}
+
+	return true, err
+}
+
+// writeExport builds the archive with the personal data of the user `userID` at `path`, through a temporary file so
+// that `path` is never left incomplete.
+func (db *appdbimpl) writeExport(ctx context.Context, userID string, path string) error {
+
+	err := os.MkdirAll(ExportDir, 0755)
+
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", ExportDir, err)
+	}
+
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+
+	if err != nil {
+		return fmt.Errorf("error creating archive: %w", err)
+	}
+
+	err = db.zipUserData(ctx, userID, f)
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+func (db *appdbimpl) zipUserData(ctx context.Context, userID string, w io.Writer) error {
+
+	username, err := db.GetUsername(ctx, userID)
+
+	if err != nil {
+		return err
+	}
+
+	posts, err := db.exportedPosts(ctx, userID)
+
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+
+	documents := []struct {
+		name string
+		data func() (string, error)
+	}{
+		{"profile.json", func() (string, error) { return db.GetUserProfile(ctx, username) }},
+		{"posts.json", func() (string, error) {
+			return marshalExport(struct {
+				Posts []exportedPost `json:"posts"`
+			}{posts})
+		}},
+		{"comments.json", func() (string, error) { return db.exportedComments(ctx, userID) }},
+		{"likes.json", func() (string, error) { return db.exportedLikes(ctx, userID) }},
+		{"followers.json", func() (string, error) { return db.GetUserFollowers(ctx, username) }},
+		{"following.json", func() (string, error) { return db.GetUserFollowing(ctx, username) }},
+		{"bans.json", func() (string, error) { return db.GetUserBans(ctx, username) }},
+	}
+
+	for _, doc := range documents {
+
+		data, err := doc.data()
+
+		if err != nil {
+			return fmt.Errorf("error exporting %s: %w", doc.name, err)
+		}
+
+		dw, err := zw.Create(doc.name)
+
+		if err == nil {
+			_, err = io.WriteString(dw, data)
+		}
+
+		if err != nil {
+			return fmt.Errorf("error writing %s: %w", doc.name, err)
+		}
+	}
+
+	for _, post := range posts {
+		for _, m := range post.Media {
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			err = zipImage(zw, m.Media_ID.Hash)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = zw.Close()
+
+	if err != nil {
+		return fmt.Errorf("error writing archive: %w", err)
+	}
+
+	return nil
+}
+
+// zipImage adds the image `mediaID` to `zw`, stored as is since PNG files are compressed already.
+func zipImage(zw *zip.Writer, mediaID string) error {
+
+	f, err := os.Open(PhotoPath(mediaID))
+
+	// purged together with its post in the meantime
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("error opening image %s: %w", mediaID, err)
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	info, err := f.Stat()
+
+	if err != nil {
+		return fmt.Errorf("error reading image %s: %w", mediaID, err)
+	}
+
+	iw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     "photos/" + mediaID + ".png",
+		Method:   zip.Store,
+		Modified: info.ModTime(),
+	})
+
+	if err == nil {
+		_, err = io.Copy(iw, f)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error writing image %s: %w", mediaID, err)
+	}
+
+	return nil
+}
+
+// exportedPost is a post in an export archive, deleted ones included.
+type exportedPost struct {
+	components.Post
+	DeletedAt *components.JSONTime `json:"deleted_at,omitempty"`
+}
+
+// exportedPosts returns every post of the user `userID`, archived and deleted ones included, oldest first. The read
+// timeout applies to each post, not to the whole list.
+func (db *appdbimpl) exportedPosts(ctx context.Context, userID string) (posts []exportedPost, err error) {
+
+	listCtx, cancel := db.reading(ctx)
+	defer cancel()
+
+	rows, err := db.c.QueryContext(listCtx, `SELECT post_ID, deleted_at FROM posts WHERE poster_ID = ? ORDER BY creation_date`,
+		userID)
+
+	if err != nil {
+		return nil, fmt.Errorf("error getting posts: %w", err)
+	}
+
+	type row struct {
+		ID        string
+		DeletedAt sql.NullString
+	}
+
+	var postRows []row
+
+	for rows.Next() {
+
+		var r row
+
+		err = rows.Scan(&r.ID, &r.DeletedAt)
+
+		if err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("error scanning post: %w", err)
+		}
+
+		postRows = append(postRows, r)
+	}
+
+	err = rows.Close()
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error getting posts: %w", err)
+	}
+
+	posts = []exportedPost{}
+
+	for _, r := range postRows {
+
+		postCtx, cancel := db.reading(ctx)
+		post, err := db.getPost(postCtx, r.ID)
+		cancel()
+
+		// purged in the meantime
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		exported := exportedPost{Post: post}
+
+		if r.DeletedAt.Valid {
+
+			deleted, err := time.Parse(time.RFC3339, r.DeletedAt.String)
+
+			if err != nil {
+				return nil, fmt.Errorf("error parsing deletion time of %s: %w", r.ID, err)
+			}
+
+			exported.DeletedAt = (*components.JSONTime)(&deleted)
+		}
+
+		posts = append(posts, exported)
+	}
+
+	return posts, nil
+}
+
+// exportedComments returns the comments written by the user `userID` as JSON, on any post.
+func (db *appdbimpl) exportedComments(ctx context.Context, userID string) (comments string, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
+
+	rows, err := db.c.QueryContext(ctx, `SELECT c.comment_ID, u.name, c.content, c.creation_date, c.post_code
+	FROM comments AS c, users AS u WHERE c.user_code = ? AND u.ID = c.user_code ORDER BY c.creation_date`, userID)
+
+	if err != nil {
+		return "", fmt.Errorf("error getting comments: %w", err)
+	}
+
+	var list []components.Comment
+
+	for rows.Next() {
+
+		var comment components.Comment
+
+		err = rows.Scan(&comment.Comment_ID.Hash, &comment.Username.Uname, &comment.Body, &comment.CreationTime, &comment.Parent.Hash)
+
+		if err != nil {
+			_ = rows.Close()
+			return "", fmt.Errorf("error scanning comment: %w", err)
+		}
+
+		list = append(list, comment)
+	}
+
+	err = rows.Close()
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("error getting comments: %w", err)
+	}
+
+	exported := struct {
+		Comments []components.Comment `json:"comments"`
+	}{
+		Comments: []components.Comment{},
+	}
+
+	for _, comment := range list {
+
+		comment.Entities, err = db.entitiesOf(ctx, commentEntityOwner, comment.Comment_ID.Hash, comment.Body)
+
+		if err != nil {
+			return "", fmt.Errorf("error getting comment entities: %w", err)
+		}
+
+		exported.Comments = append(exported.Comments, comment)
+	}
+
+	return marshalExport(exported)
+}
+
+// exportedLikes returns the posts liked by the user `userID` as JSON.
+func (db *appdbimpl) exportedLikes(ctx context.Context, userID string) (likes string, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
+
+	rows, err := db.c.QueryContext(ctx, `SELECT l.post_ID, u.name FROM likes AS l, posts AS p, users AS u
+	WHERE l.liker = ? AND p.post_ID = l.post_ID AND u.ID = p.poster_ID`, userID)
+
+	if err != nil {
+		return "", fmt.Errorf("error getting likes: %w", err)
+	}
+
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			logger(ctx).Errorf("error closing result set: %v", err)
+		}
+	}()
+
+	type like struct {
+		Photo_ID    components.SHA256hash `json:"photo_id"`
+		Author_Name components.User       `json:"author_name"`
+	}
+
+	exported := struct {
+		Likes []like `json:"likes"`
+	}{
+		Likes: []like{},
+	}
+
+	for rows.Next() {
+
+		var l like
+
+		err = rows.Scan(&l.Photo_ID.Hash, &l.Author_Name.Uname)
+
+		if err != nil {
+			return "", fmt.Errorf("error scanning like: %w", err)
+		}
+
+		exported.Likes = append(exported.Likes, l)
+	}
+
+	if rows.Err() != nil {
+		return "", fmt.Errorf("error getting next like: %w", rows.Err())
+	}
+
+	return marshalExport(exported)
+}
+
+func marshalExport(v interface{}) (string, error) {
+
+	data, err := json.MarshalIndent(v, "", "	")
+
+	if err != nil {
+		return "", fmt.Errorf("error converting to JSON: %w", err)
+	}
+
+	return string(data), nil
+}
+
+func (db *appdbimpl) PurgeExpiredExports(ctx context.Context, before time.Time) (purged int, err error) {
+
+	rows, err := db.c.QueryContext(ctx, `SELECT job_ID FROM export_jobs WHERE finished_at IS NOT NULL AND finished_at < ?`,
+		before.UTC().Format(time.RFC3339))
+
+	if err != nil {
+		return 0, fmt.Errorf("error getting expired exports: %w", err)
+	}
+
+	var IDs []string
+
+	for rows.Next() {
+
+		var ID string
+
+		err = rows.Scan(&ID)
+
+		if err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("error scanning expired export: %w", err)
+		}
+
+		IDs = append(IDs, ID)
+	}
+
+	err = rows.Close()
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("error getting expired exports: %w", err)
+	}
+
+	for _, ID := range IDs {
+
+		wctx, cancel := db.writing(ctx)
+		_, err = db.c.ExecContext(wctx, `DELETE FROM export_jobs WHERE job_ID = ?`, ID)
+		cancel()
+
+		if err != nil {
+			return purged, fmt.Errorf("error purging export %s: %w", ID, err)
+		}
+
+		err = os.Remove(ExportPath(ID))
+
+		if err != nil && !os.IsNotExist(err) {
+			return purged, fmt.Errorf("error removing archive of export %s: %w", ID, err)
+		}
+
+		purged++
+	}
+
+	return purged, nil
+}
+
+// randomID returns a new random ID, in the same format as the SHA-256 ones.
+func randomID() (string, error) {
+
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+
+	if err != nil {
+		return "", fmt.Errorf("error generating ID: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,329 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/metrics"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/tracing"
+)
+
+var queryDuration = metrics.NewHistogram("db_query_duration_seconds",
+	"Duration of the operations of the database, by AppDatabase method.", metrics.DurationBuckets, "method")
+
+// Instrument returns `db` with each of its methods traced in a span (`db.<method>`) and its duration recorded in the
+// db_query_duration_seconds histogram. A method added to AppDatabase but not here is still available, just not
+// measured.
+func Instrument(db AppDatabase) AppDatabase {
+	return instrumented{AppDatabase: db}
+}
+
+type instrumented struct {
+	AppDatabase
+}
+
+// observe starts the span of `method`, and returns its context and the function that ends it.
+func observe(ctx context.Context, method string) (context.Context, func()) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "db."+method)
+
+	return ctx, func() {
+		span.End()
+		queryDuration.Observe(time.Since(start).Seconds(), method)
+	}
+}
+
+func (db instrumented) GetName(ctx context.Context) (string, error) {
+	ctx, done := observe(ctx, "GetName")
+	defer done()
+	return db.AppDatabase.GetName(ctx)
+}
+
+func (db instrumented) SetName(ctx context.Context, name string) error {
+	ctx, done := observe(ctx, "SetName")
+	defer done()
+	return db.AppDatabase.SetName(ctx, name)
+}
+
+func (db instrumented) Ping(ctx context.Context) error {
+	ctx, done := observe(ctx, "Ping")
+	defer done()
+	return db.AppDatabase.Ping(ctx)
+}
+
+func (db instrumented) PendingMigrations(ctx context.Context) (pending int, err error) {
+	ctx, done := observe(ctx, "PendingMigrations")
+	defer done()
+	return db.AppDatabase.PendingMigrations(ctx)
+}
+
+func (db instrumented) PostUserID(ctx context.Context, userName string) (ID string, err error) {
+	ctx, done := observe(ctx, "PostUserID")
+	defer done()
+	return db.AppDatabase.PostUserID(ctx, userName)
+}
+
+func (db instrumented) GetUserID(ctx context.Context, name string) (ID string, err error) {
+	ctx, done := observe(ctx, "GetUserID")
+	defer done()
+	return db.AppDatabase.GetUserID(ctx, name)
+}
+
+func (db instrumented) GetUsername(ctx context.Context, ID string) (username string, err error) {
+	ctx, done := observe(ctx, "GetUsername")
+	defer done()
+	return db.AppDatabase.GetUsername(ctx, ID)
+}
+
+func (db instrumented) SearchUserByName(ctx context.Context, name string, searcher string) (matches string, err error) {
+	ctx, done := observe(ctx, "SearchUserByName")
+	defer done()
+	return db.AppDatabase.SearchUserByName(ctx, name, searcher)
+}
+
+func (db instrumented) Search(ctx context.Context, text string, kind string, searcher string, from, offset int) (results string, err error) {
+	ctx, done := observe(ctx, "Search")
+	defer done()
+	return db.AppDatabase.Search(ctx, text, kind, searcher, from, offset)
+}
+
+func (db instrumented) CheckUserExists(ctx context.Context, ID string) (exists bool, err error) {
+	ctx, done := observe(ctx, "CheckUserExists")
+	defer done()
+	return db.AppDatabase.CheckUserExists(ctx, ID)
+}
+
+func (db instrumented) CheckPhotoExists(ctx context.Context, ID string) (exists bool, err error) {
+	ctx, done := observe(ctx, "CheckPhotoExists")
+	defer done()
+	return db.AppDatabase.CheckPhotoExists(ctx, ID)
+}
+
+func (db instrumented) CheckUsernameExists(ctx context.Context, username string) (exists bool, err error) {
+	ctx, done := observe(ctx, "CheckUsernameExists")
+	defer done()
+	return db.AppDatabase.CheckUsernameExists(ctx, username)
+}
+
+func (db instrumented) GetUserPhotos(ctx context.Context, ID string, archived bool) (photos string, err error) {
+	ctx, done := observe(ctx, "GetUserPhotos")
+	defer done()
+	return db.AppDatabase.GetUserPhotos(ctx, ID, archived)
+}
+
+func (db instrumented) GetUserFollowers(ctx context.Context, username string) (followers string, err error) {
+	ctx, done := observe(ctx, "GetUserFollowers")
+	defer done()
+	return db.AppDatabase.GetUserFollowers(ctx, username)
+}
+
+func (db instrumented) GetUserFollowing(ctx context.Context, username string) (following string, err error) {
+	ctx, done := observe(ctx, "GetUserFollowing")
+	defer done()
+	return db.AppDatabase.GetUserFollowing(ctx, username)
+}
+
+func (db instrumented) GetPhotoLikes(ctx context.Context, ID string) (likes string, err error) {
+	ctx, done := observe(ctx, "GetPhotoLikes")
+	defer done()
+	return db.AppDatabase.GetPhotoLikes(ctx, ID)
+}
+
+func (db instrumented) GetPhotoComments(ctx context.Context, ID string) (comments string, err error) {
+	ctx, done := observe(ctx, "GetPhotoComments")
+	defer done()
+	return db.AppDatabase.GetPhotoComments(ctx, ID)
+}
+
+func (db instrumented) GetUserBans(ctx context.Context, ID string) (bans string, err error) {
+	ctx, done := observe(ctx, "GetUserBans")
+	defer done()
+	return db.AppDatabase.GetUserBans(ctx, ID)
+}
+
+func (db instrumented) FollowUser(ctx context.Context, follower string, followed string) (errstring string, err error) {
+	ctx, done := observe(ctx, "FollowUser")
+	defer done()
+	return db.AppDatabase.FollowUser(ctx, follower, followed)
+}
+
+func (db instrumented) UnfollowUser(ctx context.Context, follower string, followed string) (errstring string, err error) {
+	ctx, done := observe(ctx, "UnfollowUser")
+	defer done()
+	return db.AppDatabase.UnfollowUser(ctx, follower, followed)
+}
+
+func (db instrumented) Validate(ctx context.Context, username string, token string) (is_valid bool, err error) {
+	ctx, done := observe(ctx, "Validate")
+	defer done()
+	return db.AppDatabase.Validate(ctx, username, token)
+}
+
+func (db instrumented) Authenticate(ctx context.Context, token string) (ID string, err error) {
+	ctx, done := observe(ctx, "Authenticate")
+	defer done()
+	return db.AppDatabase.Authenticate(ctx, token)
+}
+
+func (db instrumented) BanUser(ctx context.Context, bannedID string, bannerID string) (errstring string, err error) {
+	ctx, done := observe(ctx, "BanUser")
+	defer done()
+	return db.AppDatabase.BanUser(ctx, bannedID, bannerID)
+}
+
+func (db instrumented) UnbanUser(ctx context.Context, bannedID string, bannerID string) (errstring string, err error) {
+	ctx, done := observe(ctx, "UnbanUser")
+	defer done()
+	return db.AppDatabase.UnbanUser(ctx, bannedID, bannerID)
+}
+
+func (db instrumented) LikePhoto(ctx context.Context, likerID string, photoID string) (errstring string, err error) {
+	ctx, done := observe(ctx, "LikePhoto")
+	defer done()
+	return db.AppDatabase.LikePhoto(ctx, likerID, photoID)
+}
+
+func (db instrumented) UnlikePhoto(ctx context.Context, likerID string, photoID string) (errstring string, err error) {
+	ctx, done := observe(ctx, "UnlikePhoto")
+	defer done()
+	return db.AppDatabase.UnlikePhoto(ctx, likerID, photoID)
+}
+
+func (db instrumented) CommentPhoto(ctx context.Context, username string, photoID string, comment components.Comment) (errstring string, err error) {
+	ctx, done := observe(ctx, "CommentPhoto")
+	defer done()
+	return db.AppDatabase.CommentPhoto(ctx, username, photoID, comment)
+}
+
+func (db instrumented) UncommentPhoto(ctx context.Context, username string, photoID string, comment_id string) (errstring string, err error) {
+	ctx, done := observe(ctx, "UncommentPhoto")
+	defer done()
+	return db.AppDatabase.UncommentPhoto(ctx, username, photoID, comment_id)
+}
+
+func (db instrumented) UploadPhoto(ctx context.Context, username string, photo components.Photo, photo_ID string) (errstring string, err error) {
+	ctx, done := observe(ctx, "UploadPhoto")
+	defer done()
+	return db.AppDatabase.UploadPhoto(ctx, username, photo, photo_ID)
+}
+
+func (db instrumented) DeletePhoto(ctx context.Context, username string, photoID string) (errstring string, err error) {
+	ctx, done := observe(ctx, "DeletePhoto")
+	defer done()
+	return db.AppDatabase.DeletePhoto(ctx, username, photoID)
+}
+
+func (db instrumented) GetDeletedPhotos(ctx context.Context, username string, retention time.Duration) (photos string, err error) {
+	ctx, done := observe(ctx, "GetDeletedPhotos")
+	defer done()
+	return db.AppDatabase.GetDeletedPhotos(ctx, username, retention)
+}
+
+func (db instrumented) RestorePhoto(ctx context.Context, username string, photoID string) (errstring string, err error) {
+	ctx, done := observe(ctx, "RestorePhoto")
+	defer done()
+	return db.AppDatabase.RestorePhoto(ctx, username, photoID)
+}
+
+func (db instrumented) PurgeDeletedPhotos(ctx context.Context, before time.Time) (purged int, err error) {
+	ctx, done := observe(ctx, "PurgeDeletedPhotos")
+	defer done()
+	return db.AppDatabase.PurgeDeletedPhotos(ctx, before)
+}
+
+func (db instrumented) RemoveOrphanImages(ctx context.Context, before time.Time) (removed int, err error) {
+	ctx, done := observe(ctx, "RemoveOrphanImages")
+	defer done()
+	return db.AppDatabase.RemoveOrphanImages(ctx, before)
+}
+
+func (db instrumented) Reauthenticate(ctx context.Context, username string, token string, ttl time.Duration) (reauthentication string, err error) {
+	ctx, done := observe(ctx, "Reauthenticate")
+	defer done()
+	return db.AppDatabase.Reauthenticate(ctx, username, token, ttl)
+}
+
+func (db instrumented) DeactivateUser(ctx context.Context, username string, reauth string) (errstring string, err error) {
+	ctx, done := observe(ctx, "DeactivateUser")
+	defer done()
+	return db.AppDatabase.DeactivateUser(ctx, username, reauth)
+}
+
+func (db instrumented) PurgeDeactivatedUsers(ctx context.Context, before time.Time) (purged int, err error) {
+	ctx, done := observe(ctx, "PurgeDeactivatedUsers")
+	defer done()
+	return db.AppDatabase.PurgeDeactivatedUsers(ctx, before)
+}
+
+func (db instrumented) CreateExport(ctx context.Context, username string, retention time.Duration) (job string, err error) {
+	ctx, done := observe(ctx, "CreateExport")
+	defer done()
+	return db.AppDatabase.CreateExport(ctx, username, retention)
+}
+
+func (db instrumented) GetExport(ctx context.Context, username string, jobID string, retention time.Duration) (job string, err error) {
+	ctx, done := observe(ctx, "GetExport")
+	defer done()
+	return db.AppDatabase.GetExport(ctx, username, jobID, retention)
+}
+
+func (db instrumented) ExportReady(ctx context.Context, username string, jobID string) (errstring string, err error) {
+	ctx, done := observe(ctx, "ExportReady")
+	defer done()
+	return db.AppDatabase.ExportReady(ctx, username, jobID)
+}
+
+func (db instrumented) RunNextExport(ctx context.Context) (ran bool, err error) {
+	ctx, done := observe(ctx, "RunNextExport")
+	defer done()
+	return db.AppDatabase.RunNextExport(ctx)
+}
+
+func (db instrumented) PurgeExpiredExports(ctx context.Context, before time.Time) (purged int, err error) {
+	ctx, done := observe(ctx, "PurgeExpiredExports")
+	defer done()
+	return db.AppDatabase.PurgeExpiredExports(ctx, before)
+}
+
+func (db instrumented) UpdatePhoto(ctx context.Context, username string, photoID string, update components.PhotoUpdate) (photo string, err error) {
+	ctx, done := observe(ctx, "UpdatePhoto")
+	defer done()
+	return db.AppDatabase.UpdatePhoto(ctx, username, photoID, update)
+}
+
+func (db instrumented) SetAltText(ctx context.Context, username string, photoID string, mediaID string, altText string) (errstring string, err error) {
+	ctx, done := observe(ctx, "SetAltText")
+	defer done()
+	return db.AppDatabase.SetAltText(ctx, username, photoID, mediaID, altText)
+}
+
+func (db instrumented) ChangeUsername(ctx context.Context, username string, ID string) (errstring string, err error) {
+	ctx, done := observe(ctx, "ChangeUsername")
+	defer done()
+	return db.AppDatabase.ChangeUsername(ctx, username, ID)
+}
+
+func (db instrumented) GetUserProfile(ctx context.Context, username string) (profile string, err error) {
+	ctx, done := observe(ctx, "GetUserProfile")
+	defer done()
+	return db.AppDatabase.GetUserProfile(ctx, username)
+}
+
+func (db instrumented) UpdateProfile(ctx context.Context, username string, update components.ProfileUpdate) (profile string, err error) {
+	ctx, done := observe(ctx, "UpdateProfile")
+	defer done()
+	return db.AppDatabase.UpdateProfile(ctx, username, update)
+}
+
+func (db instrumented) GetStream(ctx context.Context, username string, from, offset int) (stream string, err error) {
+	ctx, done := observe(ctx, "GetStream")
+	defer done()
+	return db.AppDatabase.GetStream(ctx, username, from, offset)
+}
+
+func (db instrumented) GetTagPhotos(ctx context.Context, tag string, username string, from, offset int) (photos string, err error) {
+	ctx, done := observe(ctx, "GetTagPhotos")
+	defer done()
+	return db.AppDatabase.GetTagPhotos(ctx, tag, username, from, offset)
+}
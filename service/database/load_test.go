@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentLikesAndUploads loads the database as a busy server would, with writers and readers at once: with a
+// single writer connection taking the write lock upfront, and read-only connections for the rest, no operation may fail
+// with SQLITE_BUSY ("database is locked").
+func TestConcurrentLikesAndUploads(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	const users, uploads, likes, readers = 8, 10, 20, 4
+
+	for i := 0; i < users; i++ {
+		_, err := db.PostUserID(ctx, fmt.Sprintf("user%d", i))
+		if err != nil {
+			t.Fatalf("creating user%d: %v", i, err)
+		}
+	}
+
+	const target = "load-target"
+	removePhotos(t, target)
+	_, err := db.UploadPhoto(ctx, "user0", testPhoto(t, "like me"), target)
+	if err != nil {
+		t.Fatalf("posting: %v", err)
+	}
+
+	var mu sync.Mutex
+	var failures []string
+	fail := func(format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		failures = append(failures, fmt.Sprintf(format, args...))
+	}
+
+	// every user uploads, every user likes and unlikes the target over and over (ending with a like), and a few
+	// readers look at the likes and at the stream meanwhile
+	concurrently(2*users+readers, func(i int) {
+		switch {
+		case i < users:
+			name := fmt.Sprintf("user%d", i)
+			for j := 0; j < uploads; j++ {
+				photoID := fmt.Sprintf("load-%d-%d", i, j)
+				removePhotos(t, photoID)
+				ret, err := db.UploadPhoto(ctx, name, testPhoto(t, "photo"), photoID)
+				if err != nil {
+					fail("%s uploading %s: %s, %v", name, photoID, ret, err)
+				}
+			}
+
+		case i < 2*users:
+			likerID, err := db.GetUserID(ctx, fmt.Sprintf("user%d", i-users))
+			if err != nil {
+				fail("getting the ID of user%d: %v", i-users, err)
+				return
+			}
+			for j := 0; j < likes; j++ {
+				ret, err := db.UnlikePhoto(ctx, likerID, target)
+				if err != nil {
+					fail("user%d unliking: %s, %v", i-users, ret, err)
+				}
+				ret, err = db.LikePhoto(ctx, likerID, target)
+				if err != nil {
+					fail("user%d liking: %s, %v", i-users, ret, err)
+				}
+			}
+
+		default:
+			for j := 0; j < likes; j++ {
+				ret, err := db.GetPhotoLikes(ctx, target)
+				if err != nil {
+					fail("reading the likes: %s, %v", ret, err)
+				}
+				ret, err = db.GetStream(ctx, "user0", 0, 10)
+				if err != nil {
+					fail("reading the stream: %s, %v", ret, err)
+				}
+			}
+		}
+	})
+
+	for _, f := range failures {
+		t.Error(f)
+	}
+
+	if n := count(t, db, `SELECT COUNT(*) FROM posts`); n != 1+users*uploads {
+		t.Errorf("%d posts, want %d", n, 1+users*uploads)
+	}
+	if n := count(t, db, `SELECT COUNT(*) FROM likes WHERE post_ID = ?`, target); n != users {
+		t.Errorf("%d likes, want %d", n, users)
+	}
+}
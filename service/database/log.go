@@ -0,0 +1,18 @@
+package database
+
+import (
+	"context"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+// logger returns the logger of the operations of `ctx`, with the ID of the request they serve, if any.
+func logger(ctx context.Context) logrus.FieldLogger {
+
+	if reqID := tracing.RequestID(ctx); reqID != "" {
+		return logrus.WithField("reqid", reqID)
+	}
+
+	return logrus.StandardLogger()
+}
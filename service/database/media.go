@@ -0,0 +1,326 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"unicode/utf8"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// PhotoDir is the directory where the images of the posts are stored, one PNG file per media item.
+const PhotoDir = "/tmp/photos"
+
+var photoBytesWritten = metrics.NewCounter("photo_store_written_bytes_total",
+	"Bytes of the images written to the photo store.")
+
+// MaxMediaPerPost is the maximum number of media items in a carousel post
+const MaxMediaPerPost = 10
+
+// PhotoPath returns the path of the image file of the media item `ID`.
+func PhotoPath(ID string) string {
+	return filepath.Join(PhotoDir, ID+".png")
+}
+
+// mediaID returns the ID of the media item at `position` in the post `postID`. The first item shares the ID of the
+// post, so that posts created before carousels existed (and links to them) keep working.
+func mediaID(postID string, position int) string {
+
+	if position == 0 {
+		return postID
+	}
+
+	h := sha256.New()
+	h.Write([]byte(postID + ":" + strconv.Itoa(position)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// decodeMedia decodes every item of the upload before anything is written, so that a single bad image rejects the
+// whole post. A photo without the `media` list is a legacy single-image upload.
+func decodeMedia(photo components.Photo) (images []image.Image, err error) {
+
+	items := photo.Media
+
+	if len(items) == 0 {
+		items = []components.MediaUpload{{Data: photo.Data}}
+	}
+
+	if len(items) > MaxMediaPerPost {
+		return nil, fmt.Errorf("too many media items: %d (max %d)", len(items), MaxMediaPerPost)
+	}
+
+	for i, item := range items {
+
+		if utf8.RuneCountInString(item.AltText) > components.MaxAltTextLength {
+			return nil, fmt.Errorf("alt text of media item %d longer than %d characters", i, components.MaxAltTextLength)
+		}
+
+		data, err := base64.StdEncoding.DecodeString(item.Data)
+
+		if err != nil {
+			return nil, fmt.Errorf("error decoding media item %d: %w", i, err)
+		}
+
+		img, err := png.Decode(bytes.NewReader(data))
+
+		if err != nil {
+			return nil, fmt.Errorf("error decoding PNG of media item %d: %w", i, err)
+		}
+
+		images = append(images, img)
+	}
+
+	return images, nil
+}
+
+// stagedPath returns the path where the new image of the media item `ID` is written before its post is committed.
+// Staged files that are never published (e.g., after a crash) are removed by RemoveOrphanImages.
+func stagedPath(ID string) string {
+	return filepath.Join(PhotoDir, ID+".staged.png")
+}
+
+// stageImages encodes `images` to the staged files of the media items of `postID`, leaving the current images (if the
+// post is being replaced) untouched until publishImages. If any write fails, the files already staged are removed.
+func stageImages(postID string, images []image.Image) error {
+
+	err := os.MkdirAll(PhotoDir, 0755)
+
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", PhotoDir, err)
+	}
+
+	for i, img := range images {
+
+		err = writeImage(stagedPath(mediaID(postID, i)), img)
+
+		if err != nil {
+			discardImages(postID, i)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// publishImages moves the first `count` staged images of `postID` in place, once their post has been committed.
+func publishImages(postID string, count int) error {
+
+	for _, ID := range mediaIDs(postID, count) {
+
+		err := os.Rename(stagedPath(ID), PhotoPath(ID))
+
+		if err != nil {
+			return fmt.Errorf("error publishing image of media %s: %w", ID, err)
+		}
+	}
+
+	return nil
+}
+
+// discardImages removes the first `count` staged images of `postID`, after their post failed to be committed.
+func discardImages(postID string, count int) {
+
+	for _, ID := range mediaIDs(postID, count) {
+
+		err := os.Remove(stagedPath(ID))
+
+		if err != nil && !os.IsNotExist(err) {
+			logrus.Errorf("error removing staged image of media %s: %v", ID, err)
+		}
+	}
+}
+
+func writeImage(path string, img image.Image) error {
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+
+	if err != nil {
+		return fmt.Errorf("error creating file: %w", err)
+	}
+
+	err = png.Encode(f, img)
+
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("error encoding PNG: %w", err)
+	}
+
+	if info, err := f.Stat(); err == nil {
+		photoBytesWritten.Add(float64(info.Size()))
+	}
+
+	return f.Close()
+}
+
+// mediaIDs returns the IDs of the first `count` media items of `postID`.
+func mediaIDs(postID string, count int) []string {
+
+	IDs := make([]string, count)
+
+	for i := range IDs {
+		IDs[i] = mediaID(postID, i)
+	}
+
+	return IDs
+}
+
+// removeImages deletes the files of the media items `IDs`, files already missing are not an error.
+func removeImages(IDs []string) {
+
+	for _, ID := range IDs {
+
+		err := os.Remove(PhotoPath(ID))
+
+		if err != nil && !os.IsNotExist(err) {
+			logrus.Errorf("error removing image of media %s: %v", ID, err)
+		}
+	}
+}
+
+// postMedia returns the media items of `postID`, in order.
+func (db *appdbimpl) postMedia(ctx context.Context, postID string) (media []components.Media, err error) {
+
+	res, err := db.c.QueryContext(ctx, `SELECT m.media_ID, m.alt_text,
+		COALESCE(md.dominant_color, ''), COALESCE(md.width, 0), COALESCE(md.height, 0)
+	FROM media AS m LEFT JOIN photo_metadata AS md ON md.media_ID = m.media_ID
+	WHERE m.post_ID = ? ORDER BY m.position`, postID)
+
+	if err != nil {
+		return nil, fmt.Errorf("error getting media: %w", err)
+	}
+
+	defer func() {
+		err := res.Close()
+		if err != nil {
+			logger(ctx).Errorf("error closing result set: %v", err)
+		}
+	}()
+
+	media = []components.Media{}
+
+	for res.Next() {
+
+		var item components.Media
+
+		err = res.Scan(&item.Media_ID.Hash, &item.AltText, &item.DominantColor, &item.Width, &item.Height)
+
+		if err != nil {
+			return nil, fmt.Errorf("error scanning media: %w", err)
+		}
+
+		if item.Height > 0 {
+			item.AspectRatio = float64(item.Width) / float64(item.Height)
+		}
+
+		media = append(media, item)
+	}
+
+	if res.Err() != nil {
+		return nil, fmt.Errorf("error getting next media: %w", res.Err())
+	}
+
+	return media, nil
+}
+
+// dominantColor returns the most frequent color of `img` as a "#rrggbb" string. Colors are grouped in buckets of 4 bits
+// per channel, the result is the average of the most populated bucket; large images are sampled on a grid of at most
+// 128x128 pixels. Transparent pixels are ignored.
+func dominantColor(img image.Image) string {
+
+	const grid = 128
+
+	b := img.Bounds()
+
+	stepX := (b.Dx() + grid - 1) / grid
+	stepY := (b.Dy() + grid - 1) / grid
+
+	type bucket struct {
+		count   int
+		r, g, b uint64
+	}
+
+	buckets := map[uint32]*bucket{}
+	var best *bucket
+
+	for y := b.Min.Y; y < b.Max.Y; y += stepY {
+		for x := b.Min.X; x < b.Max.X; x += stepX {
+
+			r, g, bl, a := img.At(x, y).RGBA()
+
+			if a == 0 {
+				continue
+			}
+
+			// RGBA() returns 16 bit channels
+			key := (r>>12)<<8 | (g>>12)<<4 | bl>>12
+
+			bk, ok := buckets[key]
+
+			if !ok {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+
+			bk.count++
+			bk.r += uint64(r >> 8)
+			bk.g += uint64(g >> 8)
+			bk.b += uint64(bl >> 8)
+
+			if best == nil || bk.count > best.count {
+				best = bk
+			}
+		}
+	}
+
+	if best == nil {
+		return "#000000"
+	}
+
+	n := uint64(best.count)
+
+	return fmt.Sprintf("#%02x%02x%02x", best.r/n, best.g/n, best.b/n)
+}
+
+func (db *appdbimpl) SetAltText(ctx context.Context, username string, photoID string, itemID string, altText string) (errstring string, err error) {
+
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
+
+	userID, err := db.GetUserID(ctx, username)
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error getting user ID: %w", err)
+	}
+
+	res, err := db.c.ExecContext(ctx, `UPDATE media SET alt_text = ?
+	WHERE media_ID = ? AND post_ID = ? AND post_ID IN (
+		SELECT post_ID FROM posts WHERE poster_ID = ? AND deleted_at IS NULL
+	)`, altText, itemID, photoID, userID)
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error updating alt text: %w", err)
+	}
+
+	updated, err := res.RowsAffected()
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error updating alt text: %w", err)
+	}
+
+	if updated == 0 {
+		return components.NotFoundError, fmt.Errorf("media %s of photo %s of %s does not exist", itemID, photoID, username)
+	}
+
+	return "", nil
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Changes that cannot be written as idempotent statements in migration.sql (e.g., ALTER TABLE) go in
+// migrations/sqlite3/NNN-description.sql, one file per schema version. The version of a database is stored in its
+// `user_version` pragma, and every file past it is applied, in order, on start.
+//
+// PostgreSQL databases have no migration.sql: their whole schema comes from migrations/postgres, and their version is
+// the highest one recorded in the `schema_version` table (created by the first migration). Every change to the schema
+// needs a migration for both dialects, but for the full-text search index, which only SQLite has (see search.sql).
+
+//go:embed migrations/sqlite3/*.sql migrations/postgres/*.sql
+var versionedMigrations embed.FS
+
+// migrationFiles returns the names of the versioned migrations of `dialect`, the one at index i brings the schema to
+// version i+1.
+func migrationFiles(dialect Dialect) ([]string, error) {
+
+	entries, err := fs.ReadDir(versionedMigrations, migrationDir(dialect))
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing migrations: %w", err)
+	}
+
+	// ReadDir sorts by name, so the numeric prefix gives the order
+	names := make([]string, len(entries))
+
+	for i, e := range entries {
+		names[i] = e.Name()
+	}
+
+	return names, nil
+}
+
+func migrationDir(dialect Dialect) string {
+	return "migrations/" + string(dialect)
+}
+
+// schemaVersion returns the version of the schema of `db`, 0 if no versioned migration has been applied yet.
+func schemaVersion(ctx context.Context, db *sql.DB, dialect Dialect) (version int, err error) {
+
+	if dialect == Postgres {
+
+		var exists bool
+
+		err = db.QueryRowContext(ctx, `SELECT to_regclass('schema_version') IS NOT NULL`).Scan(&exists)
+
+		if err == nil && exists {
+			err = db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+		}
+
+	} else {
+		err = db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("error reading schema version: %w", err)
+	}
+
+	return version, nil
+}
+
+// migrate applies the versioned migrations of `dialect` that are newer than the schema of `db`, each one in its own
+// transaction together with the version bump, so that a failed migration can be retried on the next start.
+func migrate(db *sql.DB, dialect Dialect) error {
+
+	names, err := migrationFiles(dialect)
+
+	if err != nil {
+		return err
+	}
+
+	version, err := schemaVersion(context.Background(), db, dialect)
+
+	if err != nil {
+		return err
+	}
+
+	if version > len(names) {
+		return fmt.Errorf("database schema version %d is newer than this build (%d)", version, len(names))
+	}
+
+	for i := version; i < len(names); i++ {
+
+		script, err := versionedMigrations.ReadFile(migrationDir(dialect) + "/" + names[i])
+
+		if err != nil {
+			return fmt.Errorf("error reading migration %s: %w", names[i], err)
+		}
+
+		logrus.Infof("applying migration %s", names[i])
+
+		tx, err := db.Begin()
+
+		if err != nil {
+			return fmt.Errorf("error starting migration %s: %w", names[i], err)
+		}
+
+		_, err = tx.Exec(string(script))
+
+		if err == nil && dialect == Postgres {
+			_, err = tx.Exec(fmt.Sprintf(`INSERT INTO schema_version (version) VALUES (%d)`, i+1))
+		} else if err == nil {
+			// PRAGMA does not accept placeholders
+			_, err = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1))
+		}
+
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("error applying migration %s: %w", names[i], err)
+		}
+
+		err = tx.Commit()
+
+		if err != nil {
+			return fmt.Errorf("error committing migration %s: %w", names[i], err)
+		}
+	}
+
+	return nil
+}
+
+// PendingMigrations returns how many versioned migrations of this build have not been applied to the database yet, as
+// happens when another instance is migrating it (or failed to).
+func (db *appdbimpl) PendingMigrations(ctx context.Context) (pending int, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
+
+	names, err := migrationFiles(db.c.dialect)
+
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := schemaVersion(ctx, db.c.reader(), db.c.dialect)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if version > len(names) {
+		return 0, fmt.Errorf("database schema version %d is newer than this build (%d)", version, len(names))
+	}
+
+	return len(names) - version, nil
+}
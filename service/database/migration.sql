@@ -46,9 +46,76 @@ CREATE TABLE IF NOT EXISTS comments (
 	FOREIGN KEY (user_code) REFERENCES users(ID) ON DELETE CASCADE ON UPDATE CASCADE
 );
 
+CREATE TABLE IF NOT EXISTS post_tags (
+	post_ID string NOT NULL,
+	tag string NOT NULL,
+	PRIMARY KEY (post_ID, tag),
+	FOREIGN KEY (post_ID) REFERENCES posts(post_ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
 
+CREATE INDEX IF NOT EXISTS post_tags_by_tag ON post_tags (tag);
 
+CREATE TABLE IF NOT EXISTS comment_tags (
+	comment_ID string NOT NULL,
+	tag string NOT NULL,
+	PRIMARY KEY (comment_ID, tag),
+	FOREIGN KEY (comment_ID) REFERENCES comments(comment_ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
 
+CREATE TABLE IF NOT EXISTS post_mentions (
+	post_ID string NOT NULL,
+	user_ID string NOT NULL,
+	PRIMARY KEY (post_ID, user_ID),
+	FOREIGN KEY (post_ID) REFERENCES posts(post_ID) ON DELETE CASCADE ON UPDATE CASCADE,
+	FOREIGN KEY (user_ID) REFERENCES users(ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
 
+CREATE TABLE IF NOT EXISTS comment_mentions (
+	comment_ID string NOT NULL,
+	user_ID string NOT NULL,
+	PRIMARY KEY (comment_ID, user_ID),
+	FOREIGN KEY (comment_ID) REFERENCES comments(comment_ID) ON DELETE CASCADE ON UPDATE CASCADE,
+	FOREIGN KEY (user_ID) REFERENCES users(ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
 
+CREATE TABLE IF NOT EXISTS profiles (
+	user_ID string PRIMARY KEY NOT NULL,
+	display_name string NOT NULL DEFAULT '',
+	bio string NOT NULL DEFAULT '',
+	website string NOT NULL DEFAULT '',
+	avatar string,
+	FOREIGN KEY (user_ID) REFERENCES users(ID) ON DELETE CASCADE ON UPDATE CASCADE,
+	FOREIGN KEY (avatar) REFERENCES posts(post_ID) ON DELETE SET NULL ON UPDATE CASCADE
+);
 
+CREATE TABLE IF NOT EXISTS media (
+	media_ID string PRIMARY KEY NOT NULL,
+	post_ID string NOT NULL,
+	position integer NOT NULL,
+	alt_text string NOT NULL DEFAULT '',
+	UNIQUE (post_ID, position),
+	FOREIGN KEY (post_ID) REFERENCES posts(post_ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- Posts created before carousels have a single image, named after the post
+INSERT OR IGNORE INTO media (media_ID, post_ID, position)
+	SELECT post_ID, post_ID, 0 FROM posts WHERE post_ID NOT IN (SELECT post_ID FROM media);
+
+CREATE TABLE IF NOT EXISTS photo_metadata (
+	media_ID string PRIMARY KEY NOT NULL,
+	dominant_color string NOT NULL,
+	width integer NOT NULL,
+	height integer NOT NULL,
+	FOREIGN KEY (media_ID) REFERENCES media(media_ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- Exports of the personal data of the users, built one at a time in the background. Times are RFC 3339 UTC strings;
+-- finished_at is NULL until the archive is built (or the export fails)
+CREATE TABLE IF NOT EXISTS export_jobs (
+	job_ID string PRIMARY KEY NOT NULL,
+	user_ID string NOT NULL,
+	status string NOT NULL,
+	created_at datetime NOT NULL,
+	finished_at datetime,
+	FOREIGN KEY (user_ID) REFERENCES users(ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
@@ -0,0 +1,118 @@
+-- The whole schema as of SQLite migration 002, see migration.sql for the SQLite one
+CREATE TABLE schema_version (
+	version integer NOT NULL
+);
+
+CREATE TABLE example_table (
+	id integer NOT NULL PRIMARY KEY,
+	name text
+);
+
+CREATE TABLE users (
+	ID text PRIMARY KEY NOT NULL,
+	name text UNIQUE NOT NULL
+);
+
+CREATE TABLE bans (
+	banisher text NOT NULL,
+	banished text NOT NULL,
+	FOREIGN KEY (banisher) REFERENCES users(ID) ON DELETE CASCADE ON UPDATE CASCADE,
+	FOREIGN KEY (banished) REFERENCES users(ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE followers (
+	follower text NOT NULL,
+	followed text NOT NULL,
+	FOREIGN KEY (follower) REFERENCES users(ID) ON DELETE CASCADE ON UPDATE CASCADE,
+	FOREIGN KEY (followed) REFERENCES users(ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- Archived posts are hidden from everyone but their author, deleted posts from everyone until the janitor purges
+-- them; NULL means not archived (deleted)
+CREATE TABLE posts (
+	post_ID text PRIMARY KEY,
+	poster_ID text NOT NULL,
+	description text,
+	creation_date timestamptz,
+	archived_at timestamptz,
+	deleted_at timestamptz,
+	FOREIGN KEY (poster_ID) REFERENCES users(ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX posts_by_deletion ON posts (deleted_at) WHERE deleted_at IS NOT NULL;
+
+CREATE TABLE likes (
+	post_ID text NOT NULL,
+	liker text NOT NULL,
+	FOREIGN KEY (post_ID) REFERENCES posts(post_ID) ON DELETE CASCADE ON UPDATE CASCADE,
+	FOREIGN KEY (liker) REFERENCES users(ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE comments (
+	comment_ID text PRIMARY KEY,
+	post_code text NOT NULL,
+	user_code text NOT NULL,
+	content text,
+	creation_date timestamptz,
+	FOREIGN KEY (post_code) REFERENCES posts(post_ID) ON DELETE CASCADE ON UPDATE CASCADE,
+	FOREIGN KEY (user_code) REFERENCES users(ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE post_tags (
+	post_ID text NOT NULL,
+	tag text NOT NULL,
+	PRIMARY KEY (post_ID, tag),
+	FOREIGN KEY (post_ID) REFERENCES posts(post_ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX post_tags_by_tag ON post_tags (tag);
+
+CREATE TABLE comment_tags (
+	comment_ID text NOT NULL,
+	tag text NOT NULL,
+	PRIMARY KEY (comment_ID, tag),
+	FOREIGN KEY (comment_ID) REFERENCES comments(comment_ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE post_mentions (
+	post_ID text NOT NULL,
+	user_ID text NOT NULL,
+	PRIMARY KEY (post_ID, user_ID),
+	FOREIGN KEY (post_ID) REFERENCES posts(post_ID) ON DELETE CASCADE ON UPDATE CASCADE,
+	FOREIGN KEY (user_ID) REFERENCES users(ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE comment_mentions (
+	comment_ID text NOT NULL,
+	user_ID text NOT NULL,
+	PRIMARY KEY (comment_ID, user_ID),
+	FOREIGN KEY (comment_ID) REFERENCES comments(comment_ID) ON DELETE CASCADE ON UPDATE CASCADE,
+	FOREIGN KEY (user_ID) REFERENCES users(ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE profiles (
+	user_ID text PRIMARY KEY NOT NULL,
+	display_name text NOT NULL DEFAULT '',
+	bio text NOT NULL DEFAULT '',
+	website text NOT NULL DEFAULT '',
+	avatar text,
+	FOREIGN KEY (user_ID) REFERENCES users(ID) ON DELETE CASCADE ON UPDATE CASCADE,
+	FOREIGN KEY (avatar) REFERENCES posts(post_ID) ON DELETE SET NULL ON UPDATE CASCADE
+);
+
+CREATE TABLE media (
+	media_ID text PRIMARY KEY NOT NULL,
+	post_ID text NOT NULL,
+	position integer NOT NULL,
+	alt_text text NOT NULL DEFAULT '',
+	UNIQUE (post_ID, position),
+	FOREIGN KEY (post_ID) REFERENCES posts(post_ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE photo_metadata (
+	media_ID text PRIMARY KEY NOT NULL,
+	dominant_color text NOT NULL,
+	width integer NOT NULL,
+	height integer NOT NULL,
+	FOREIGN KEY (media_ID) REFERENCES media(media_ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
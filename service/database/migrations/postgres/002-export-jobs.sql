@@ -0,0 +1,9 @@
+-- Exports of the personal data of the users, see migration.sql
+CREATE TABLE export_jobs (
+	job_ID text PRIMARY KEY NOT NULL,
+	user_ID text NOT NULL,
+	status text NOT NULL,
+	created_at timestamptz NOT NULL,
+	finished_at timestamptz,
+	FOREIGN KEY (user_ID) REFERENCES users(ID) ON DELETE CASCADE ON UPDATE CASCADE
+);
@@ -0,0 +1,2 @@
+-- Users that asked to delete their account, see migrations/sqlite3/003-deactivate-users.sql
+ALTER TABLE users ADD COLUMN deactivated_at timestamptz;
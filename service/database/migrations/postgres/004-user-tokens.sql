@@ -0,0 +1,5 @@
+-- The session token of a user, see migrations/sqlite3/004-user-tokens.sql
+ALTER TABLE users ADD COLUMN token text;
+UPDATE users SET token = encode(sha256((random()::text || clock_timestamp()::text || ID)::bytea), 'hex');
+ALTER TABLE users ALTER COLUMN token SET NOT NULL;
+CREATE UNIQUE INDEX users_token ON users (token);
@@ -0,0 +1,3 @@
+-- The reauthentication token of a user, see migrations/sqlite3/006-reauthentication.sql
+ALTER TABLE users ADD COLUMN reauth_token text;
+ALTER TABLE users ADD COLUMN reauth_expires_at timestamptz;
@@ -0,0 +1,2 @@
+-- Archived posts are hidden from everyone but their author, NULL means not archived
+ALTER TABLE posts ADD COLUMN archived_at datetime;
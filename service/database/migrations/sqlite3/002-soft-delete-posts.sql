@@ -0,0 +1,4 @@
+-- Deleted posts are kept, hidden from everyone, until the janitor purges them; NULL means not deleted
+ALTER TABLE posts ADD COLUMN deleted_at datetime;
+
+CREATE INDEX IF NOT EXISTS posts_by_deletion ON posts (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,3 @@
+-- Users that asked to delete their account are hidden from everyone, and cannot log in, until the janitor purges them;
+-- NULL means active
+ALTER TABLE users ADD COLUMN deactivated_at datetime;
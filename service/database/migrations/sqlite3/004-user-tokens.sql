@@ -0,0 +1,5 @@
+-- The session token of a user is random, rather than its ID (the SHA-256 of its name, which anyone can compute): a new
+-- account with the name of a purged one gets a new token, and the tokens of the old account stop validating
+ALTER TABLE users ADD COLUMN token text;
+UPDATE users SET token = lower(hex(randomblob(32)));
+CREATE UNIQUE INDEX users_token ON users (token);
@@ -0,0 +1,27 @@
+-- The full-text search index (see search.sql) is keyed by these columns rather than by the implicit rowid, which VACUUM
+-- may renumber in tables without an INTEGER PRIMARY KEY, desyncing the index. The search triggers are dropped, so that
+-- initSearch creates them again, assigns the keys and rebuilds the index. PostgreSQL has no such index.
+ALTER TABLE users ADD COLUMN search_key integer;
+CREATE UNIQUE INDEX users_search_key ON users (search_key);
+ALTER TABLE profiles ADD COLUMN search_key integer;
+CREATE UNIQUE INDEX profiles_search_key ON profiles (search_key);
+ALTER TABLE posts ADD COLUMN search_key integer;
+CREATE UNIQUE INDEX posts_search_key ON posts (search_key);
+ALTER TABLE comments ADD COLUMN search_key integer;
+CREATE UNIQUE INDEX comments_search_key ON comments (search_key);
+DROP TRIGGER IF EXISTS users_fts_replace;
+DROP TRIGGER IF EXISTS users_fts_insert;
+DROP TRIGGER IF EXISTS users_fts_update;
+DROP TRIGGER IF EXISTS users_fts_delete;
+DROP TRIGGER IF EXISTS profiles_fts_replace;
+DROP TRIGGER IF EXISTS profiles_fts_insert;
+DROP TRIGGER IF EXISTS profiles_fts_update;
+DROP TRIGGER IF EXISTS profiles_fts_delete;
+DROP TRIGGER IF EXISTS posts_fts_replace;
+DROP TRIGGER IF EXISTS posts_fts_insert;
+DROP TRIGGER IF EXISTS posts_fts_update;
+DROP TRIGGER IF EXISTS posts_fts_delete;
+DROP TRIGGER IF EXISTS comments_fts_replace;
+DROP TRIGGER IF EXISTS comments_fts_insert;
+DROP TRIGGER IF EXISTS comments_fts_update;
+DROP TRIGGER IF EXISTS comments_fts_delete;
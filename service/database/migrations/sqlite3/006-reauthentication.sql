@@ -0,0 +1,4 @@
+-- The reauthentication token of a user, a proof that it logged in again shortly before an operation that cannot be
+-- undone, such as deleting its account: valid once, until reauth_expires_at (an RFC 3339 UTC string)
+ALTER TABLE users ADD COLUMN reauth_token text;
+ALTER TABLE users ADD COLUMN reauth_expires_at datetime;
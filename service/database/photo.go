@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+)
+
+// getPost returns the post `photoID`, whoever its author and whether it is archived or not.
+func (db *appdbimpl) getPost(ctx context.Context, photoID string) (post components.Post, err error) {
+
+	rows, err := db.c.QueryContext(ctx, `SELECT p.post_ID, p.poster_ID, p.description, p.creation_date, p.archived_at IS NOT NULL
+	FROM posts AS p WHERE p.post_ID = ?`, photoID)
+
+	if err != nil {
+		return post, fmt.Errorf("error getting photo: %w", err)
+	}
+
+	posts, err := db.scanPosts(ctx, rows)
+
+	if err != nil {
+		return post, err
+	}
+
+	if len(posts) == 0 {
+		return post, sql.ErrNoRows
+	}
+
+	return posts[0], nil
+}
+
+func (db *appdbimpl) UpdatePhoto(ctx context.Context, username string, photoID string, update components.PhotoUpdate) (photo string, err error) {
+
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
+
+	userID, err := db.GetUserID(ctx, username)
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error getting user ID: %w", err)
+	}
+
+	errstring := components.InternalServerError
+
+	err = db.inTx(ctx, func(tx txconn) error {
+
+		var poster string
+
+		err := tx.QueryRowContext(ctx, `SELECT poster_ID FROM posts WHERE post_ID = ? AND deleted_at IS NULL`, photoID).Scan(&poster)
+
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && poster != userID) {
+			errstring = components.NotFoundError
+			return fmt.Errorf("photo %s of %s does not exist", photoID, username)
+		}
+
+		if err != nil {
+			return fmt.Errorf("error getting photo: %w", err)
+		}
+
+		if update.Description != nil {
+
+			_, err = tx.ExecContext(ctx, `UPDATE posts SET description = ? WHERE post_ID = ?`, *update.Description, photoID)
+
+			if err != nil {
+				return fmt.Errorf("error updating description: %w", err)
+			}
+
+			err = indexEntities(ctx, tx, postEntityOwner, photoID, *update.Description)
+
+			if err != nil {
+				return fmt.Errorf("error indexing photo description: %w", err)
+			}
+		}
+
+		for _, m := range update.Media {
+
+			res, err := tx.ExecContext(ctx, `UPDATE media SET alt_text = ? WHERE media_ID = ? AND post_ID = ?`, m.AltText, m.Media_ID.Hash, photoID)
+
+			if err != nil {
+				return fmt.Errorf("error updating alt text: %w", err)
+			}
+
+			updated, err := res.RowsAffected()
+
+			if err != nil {
+				return fmt.Errorf("error updating alt text: %w", err)
+			}
+
+			if updated == 0 {
+				errstring = components.BadRequestError
+				return fmt.Errorf("media %s is not part of photo %s", m.Media_ID.Hash, photoID)
+			}
+		}
+
+		if update.Archived != nil {
+
+			// archiving an archived post keeps the original date
+			_, err = tx.ExecContext(ctx, `UPDATE posts SET archived_at = CASE WHEN ? THEN COALESCE(archived_at, ?) ELSE NULL END
+			WHERE post_ID = ?`, *update.Archived, time.Now().Format(time.RFC3339), photoID)
+
+			if err != nil {
+				return fmt.Errorf("error updating archive state: %w", err)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return errstring, err
+	}
+
+	post, err := db.getPost(ctx, photoID)
+
+	if err != nil {
+		return components.InternalServerError, err
+	}
+
+	data, err := json.MarshalIndent(post, "", "	")
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error converting photo to JSON: %w", err)
+	}
+
+	return string(data), nil
+}
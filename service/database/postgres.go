@@ -0,0 +1,21 @@
+package database
+
+import (
+	"fmt"
+)
+
+// newPostgres returns the AppDatabase for the PostgreSQL connections in `cfg`, bringing the schema up to date. There is
+// no full-text index on PostgreSQL: search uses the LIKE fallback.
+func newPostgres(cfg Config) (AppDatabase, error) {
+
+	err := migrate(cfg.DB, Postgres)
+
+	if err != nil {
+		return nil, fmt.Errorf("error migrating database: %w", err)
+	}
+
+	return &appdbimpl{
+		c:        conn{DB: cfg.DB, readers: cfg.Readers, dialect: Postgres},
+		timeouts: cfg.Timeouts,
+	}, nil
+}
@@ -0,0 +1,197 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+)
+
+// postIDs returns the sorted IDs of the posts in `res`, a JSON list of posts under "posts" (or an error body).
+func postIDs(t *testing.T, res string, err error) string {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("getting posts: %s, %v", res, err)
+	}
+	var stream components.Stream
+	if err := json.Unmarshal([]byte(res), &stream); err != nil {
+		t.Fatalf("decoding %s: %v", res, err)
+	}
+	ids := []string{}
+	for _, p := range stream.Posts {
+		ids = append(ids, p.Photo_ID.Hash)
+	}
+	// posts are ordered by date, which may be the same second
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func TestConformanceStream(t *testing.T) {
+	conform(t, func(t *testing.T, db *appdbimpl) {
+		ctx := context.Background()
+
+		for _, name := range []string{"alice", "bob", "carol", "dave"} {
+			login(t, db, name)
+		}
+		removePhotos(t, "alice-1", "alice-2", "bob-1", "dave-1")
+		for _, post := range []struct{ author, photoID string }{
+			{"alice", "alice-1"}, {"alice", "alice-2"}, {"bob", "bob-1"}, {"dave", "dave-1"},
+		} {
+			_, err := db.UploadPhoto(ctx, post.author, testPhoto(t, "hi"), post.photoID)
+			if err != nil {
+				t.Fatalf("posting %s: %v", post.photoID, err)
+			}
+		}
+
+		steps := []struct {
+			what string
+			fn   func() (string, error)
+		}{
+			{"bob follows alice", func() (string, error) { return db.FollowUser(ctx, "bob", "alice") }},
+			{"carol follows alice", func() (string, error) { return db.FollowUser(ctx, "carol", "alice") }},
+			{"carol follows dave", func() (string, error) { return db.FollowUser(ctx, "carol", "dave") }},
+			{"alice bans carol", func() (string, error) { return db.BanUser(ctx, "alice", "carol") }},
+			{"alice archives alice-2", func() (string, error) {
+				archived := true
+				return db.UpdatePhoto(ctx, "alice", "alice-2", components.PhotoUpdate{Archived: &archived})
+			}},
+		}
+		for _, step := range steps {
+			if res, err := step.fn(); err != nil {
+				t.Fatalf("%s: %s, %v", step.what, res, err)
+			}
+		}
+
+		stream := func(name string) string {
+			res, err := db.GetStream(ctx, name, 0, 10)
+			return postIDs(t, res, err)
+		}
+
+		tests := []struct {
+			name, want string
+		}{
+			// the own posts, archived or not
+			{"alice", "alice-1,alice-2"},
+			// the posts of the followed users, but not the archived ones
+			{"bob", "alice-1,bob-1"},
+			// not those of the users that banned the reader
+			{"carol", "dave-1"},
+			{"dave", "dave-1"},
+		}
+		for _, tt := range tests {
+			if got := stream(tt.name); got != tt.want {
+				t.Errorf("stream of %s: %s, want %s", tt.name, got, tt.want)
+			}
+		}
+
+		aliceID, err := db.GetUserID(ctx, "alice")
+		if err != nil {
+			t.Fatalf("getting the ID of alice: %v", err)
+		}
+		res, err := db.GetUserPhotos(ctx, aliceID, false)
+		if got := postIDs(t, res, err); got != "alice-1" {
+			t.Errorf("photos of alice for others: %s", got)
+		}
+		res, err = db.GetUserPhotos(ctx, aliceID, true)
+		if got := postIDs(t, res, err); got != "alice-1,alice-2" {
+			t.Errorf("photos of alice for alice: %s", got)
+		}
+
+		// unfollowing and unbanning change the streams at once
+		if res, err := db.UnfollowUser(ctx, "bob", "alice"); err != nil {
+			t.Fatalf("bob unfollowing alice: %s, %v", res, err)
+		}
+		if res, err := db.UnbanUser(ctx, "alice", "carol"); err != nil {
+			t.Fatalf("alice unbanning carol: %s, %v", res, err)
+		}
+		if got := stream("bob"); got != "bob-1" {
+			t.Errorf("stream of bob after unfollowing alice: %s", got)
+		}
+		if got := stream("carol"); got != "alice-1,dave-1" {
+			t.Errorf("stream of carol after the unban: %s", got)
+		}
+	})
+}
+
+func TestConformanceSoftDelete(t *testing.T) {
+	conform(t, func(t *testing.T, db *appdbimpl) {
+		ctx := context.Background()
+
+		login(t, db, "alice")
+		login(t, db, "bob")
+		if res, err := db.FollowUser(ctx, "bob", "alice"); err != nil {
+			t.Fatalf("bob following alice: %s, %v", res, err)
+		}
+		removePhotos(t, "kept", "deleted")
+		for _, photoID := range []string{"kept", "deleted"} {
+			_, err := db.UploadPhoto(ctx, "alice", testPhoto(t, "hi"), photoID)
+			if err != nil {
+				t.Fatalf("posting %s: %v", photoID, err)
+			}
+		}
+
+		stream := func() string {
+			res, err := db.GetStream(ctx, "bob", 0, 10)
+			return postIDs(t, res, err)
+		}
+		deleted := func() string {
+			res, err := db.GetDeletedPhotos(ctx, "alice", time.Hour)
+			return postIDs(t, res, err)
+		}
+
+		if res, err := db.DeletePhoto(ctx, "bob", "deleted"); err != nil || stream() != "deleted,kept" {
+			t.Errorf("bob deleted a photo of alice: %s, %v", res, err)
+		}
+
+		if res, err := db.DeletePhoto(ctx, "alice", "deleted"); err != nil {
+			t.Fatalf("deleting: %s, %v", res, err)
+		}
+		if got := stream(); got != "kept" {
+			t.Errorf("stream after deleting: %s", got)
+		}
+		if got := deleted(); got != "deleted" {
+			t.Errorf("deleted photos: %s", got)
+		}
+
+		if res, err := db.RestorePhoto(ctx, "alice", "deleted"); err != nil {
+			t.Fatalf("restoring: %s, %v", res, err)
+		}
+		if got := stream(); got != "deleted,kept" {
+			t.Errorf("stream after restoring: %s", got)
+		}
+		if res, _ := db.RestorePhoto(ctx, "alice", "deleted"); res != components.NotFoundError {
+			t.Errorf("restoring a photo that is not deleted: %s", res)
+		}
+
+		// the purge erases the photos deleted before the retention period, and only those
+		if res, err := db.DeletePhoto(ctx, "alice", "deleted"); err != nil {
+			t.Fatalf("deleting again: %s, %v", res, err)
+		}
+		purged, err := db.PurgeDeletedPhotos(ctx, time.Now().Add(-time.Hour))
+		if err != nil || purged != 0 {
+			t.Errorf("purging the photos deleted an hour ago: %d, %v", purged, err)
+		}
+		purged, err = db.PurgeDeletedPhotos(ctx, time.Now().Add(time.Second))
+		if err != nil || purged != 1 {
+			t.Errorf("purging the photos deleted until now: %d, %v", purged, err)
+		}
+
+		if n := count(t, db, `SELECT COUNT(*) FROM media WHERE post_ID = ?`, "deleted"); n != 0 {
+			t.Errorf("%d media items of a purged photo", n)
+		}
+		if got := deleted(); got != "" {
+			t.Errorf("deleted photos after the purge: %s", got)
+		}
+		if res, _ := db.RestorePhoto(ctx, "alice", "deleted"); res != components.NotFoundError {
+			t.Errorf("restoring a purged photo: %s", res)
+		}
+		if got := stream(); got != "kept" {
+			t.Errorf("stream after the purge: %s", got)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+)
+
+// getProfile reads the profile of `username`, users that never edited it get an empty one with the default avatar.
+// An avatar whose photo has been deleted is reported as the default one.
+func (db *appdbimpl) getProfile(ctx context.Context, username string) (profile components.Profile, err error) {
+
+	err = db.c.QueryRowContext(ctx, `SELECT u.name, COALESCE(pr.display_name, ''), COALESCE(pr.bio, ''), COALESCE(pr.website, ''),
+		COALESCE(pt.post_ID, ?)
+	FROM users AS u
+	LEFT JOIN profiles AS pr ON pr.user_ID = u.ID
+	LEFT JOIN posts AS pt ON pt.post_ID = pr.avatar AND pt.poster_ID = u.ID AND pt.deleted_at IS NULL
+	WHERE u.name = ? AND u.deactivated_at IS NULL`, components.DefaultAvatar, username).Scan(
+		&profile.Username, &profile.DisplayName, &profile.Bio, &profile.Website, &profile.Avatar.Hash)
+
+	return profile, err
+}
+
+func (db *appdbimpl) GetUserProfile(ctx context.Context, username string) (profile string, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
+
+	prof, err := db.getProfile(ctx, username)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return components.NotFoundError, fmt.Errorf("user %s does not exist", username)
+	}
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error getting profile: %w", err)
+	}
+
+	data, err := prof.ToJSON()
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error converting profile to JSON: %w", err)
+	}
+
+	return string(data), nil
+}
+
+func (db *appdbimpl) UpdateProfile(ctx context.Context, username string, update components.ProfileUpdate) (profile string, err error) {
+
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
+
+	userID, err := db.GetUserID(ctx, username)
+
+	if err != nil {
+		return components.NotFoundError, fmt.Errorf("error getting user ID: %w", err)
+	}
+
+	errstring := components.InternalServerError
+
+	// the rename and the profile fields change together, or not at all
+	err = db.inTx(ctx, func(tx txconn) error {
+
+		if update.Uname != nil && *update.Uname != username {
+
+			userID, err = renameUser(ctx, tx, userID, *update.Uname)
+
+			if errors.Is(err, errUsernameTaken) {
+				errstring = components.ConflictError
+			} else if errors.Is(err, errUserRenamed) {
+				errstring = components.NotFoundError
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		// not an INSERT OR IGNORE, that would fire the search index triggers even when ignored
+		_, err := tx.ExecContext(ctx, `INSERT INTO profiles (user_ID) SELECT ? WHERE NOT EXISTS (
+			SELECT * FROM profiles WHERE user_ID = ?
+		)`, userID, userID)
+
+		if err != nil {
+			return fmt.Errorf("error creating profile: %w", err)
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE profiles SET
+			display_name = COALESCE(?, display_name),
+			bio = COALESCE(?, bio),
+			website = COALESCE(?, website)
+		WHERE user_ID = ?`, update.DisplayName, update.Bio, update.Website, userID)
+
+		if err != nil {
+			return fmt.Errorf("error updating profile: %w", err)
+		}
+
+		if update.Avatar == nil {
+			return nil
+		}
+
+		var avatar interface{}
+
+		if update.Avatar.Hash != "" && update.Avatar.Hash != components.DefaultAvatar {
+
+			// the avatar must be one of the user's own photos
+			var count int
+
+			err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM posts WHERE post_ID = ? AND poster_ID = ? AND deleted_at IS NULL`,
+				update.Avatar.Hash, userID).Scan(&count)
+
+			if err != nil {
+				return fmt.Errorf("error checking avatar photo: %w", err)
+			}
+
+			if count == 0 {
+				errstring = components.BadRequestError
+				return fmt.Errorf("photo %s is not a photo of %s", update.Avatar.Hash, username)
+			}
+
+			avatar = update.Avatar.Hash
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE profiles SET avatar = ? WHERE user_ID = ?`, avatar, userID)
+
+		if err != nil {
+			return fmt.Errorf("error updating avatar: %w", err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return errstring, err
+	}
+
+	if update.Uname != nil {
+		username = *update.Uname
+	}
+
+	prof, err := db.getProfile(ctx, username)
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error getting updated profile: %w", err)
+	}
+
+	// the user ID changes together with the username, so it is always sent back; the session token does not
+	data, err := json.MarshalIndent(struct {
+		UserID  components.SHA256hash `json:"user_id"`
+		Profile components.Profile    `json:"profile"`
+	}{
+		UserID:  components.SHA256hash{Hash: userID},
+		Profile: prof,
+	}, "", "	")
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error converting profile to JSON: %w", err)
+	}
+
+	return string(data), nil
+}
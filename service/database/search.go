@@ -0,0 +1,392 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+	"github.com/sirupsen/logrus"
+)
+
+// The full-text search index needs SQLite's FTS5 extension, which `mattn/go-sqlite3` compiles in only with the
+// `sqlite_fts5` build tag (e.g., `go build -tags sqlite_fts5 ./cmd/webapi`). Without it, search falls back to
+// (unranked, unindexed) LIKE queries.
+
+//go:embed search.sql
+var searchMigration string
+
+// Kinds of results accepted by Search
+const (
+	SearchUsers  = "users"
+	SearchPhotos = "photos"
+)
+
+// initSearch creates the full-text search index and the triggers that keep it in sync, and returns whether FTS5 is
+// available. The index is rebuilt from scratch when any trigger is missing, i.e. on the first start with FTS5, after a
+// start without it (which drops them, as they would fail on every write), after new indexes have been added or after a
+// migration dropped them.
+func initSearch(db *sql.DB) (fts bool, err error) {
+
+	var triggers int
+
+	err = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name GLOB '*_fts_*'`).Scan(&triggers)
+
+	if err != nil {
+		return false, fmt.Errorf("error checking search triggers: %w", err)
+	}
+
+	// the virtual tables may exist even if the module is missing, so we cannot just try to create them
+	err = db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts)
+
+	if err != nil {
+		return false, fmt.Errorf("error checking FTS5 support: %w", err)
+	}
+
+	if !fts {
+
+		logrus.Warn("SQLite was built without FTS5, search will use slow LIKE queries (build with -tags sqlite_fts5)")
+
+		for _, table := range []string{"users", "profiles", "posts", "comments"} {
+			for _, event := range []string{"replace", "insert", "update", "delete"} {
+
+				_, err = db.Exec(fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_fts_%s`, table, event))
+
+				if err != nil {
+					return false, fmt.Errorf("error dropping search trigger: %w", err)
+				}
+			}
+		}
+
+		return false, nil
+	}
+
+	_, err = db.Exec(searchMigration)
+
+	if err != nil {
+		return false, fmt.Errorf("error creating search index: %w", err)
+	}
+
+	if triggers < strings.Count(searchMigration, "CREATE TRIGGER") {
+
+		logrus.Info("rebuilding the full-text search index")
+
+		// the rows written without the triggers have no search key: every row gets a new one, cleared first as the keys
+		// are unique
+		_, err = db.Exec(`UPDATE users SET search_key = NULL;
+		UPDATE users SET search_key = rowid;
+		DELETE FROM users_fts;
+		INSERT INTO users_fts (rowid, name) SELECT search_key, name FROM users;
+		UPDATE profiles SET search_key = NULL;
+		UPDATE profiles SET search_key = rowid;
+		DELETE FROM profiles_fts;
+		INSERT INTO profiles_fts (rowid, display_name) SELECT search_key, display_name FROM profiles;
+		UPDATE posts SET search_key = NULL;
+		UPDATE posts SET search_key = rowid;
+		DELETE FROM posts_fts;
+		INSERT INTO posts_fts (rowid, description) SELECT search_key, description FROM posts;
+		UPDATE comments SET search_key = NULL;
+		UPDATE comments SET search_key = rowid;
+		DELETE FROM comments_fts;
+		INSERT INTO comments_fts (rowid, content) SELECT search_key, content FROM comments;`)
+
+		if err != nil {
+			return false, fmt.Errorf("error rebuilding search index: %w", err)
+		}
+	}
+
+	return true, nil
+}
+
+// ftsQuery turns free text typed by a user into an FTS5 query, every word must match as a prefix of an indexed word.
+// Any FTS5 syntax in the input is discarded. It returns "" if there is nothing to search for.
+func ftsQuery(text string) string {
+
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, w := range words {
+		words[i] = `"` + w + `"*`
+	}
+
+	return strings.Join(words, " ")
+}
+
+// likeEscaper escapes the wildcards of LIKE, and the escape character itself, see likePattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// likePattern returns the pattern of a LIKE query (with `ESCAPE '\'`) matching the values that contain `text` as is:
+// a '%' or '_' typed by a user is not a wildcard.
+func likePattern(text string) string {
+	return "%" + likeEscaper.Replace(text) + "%"
+}
+
+// searchUsers returns the users whose username or display name match `text`, best matches first, hiding those that
+// banned `searcherID`.
+func (db *appdbimpl) searchUsers(ctx context.Context, text string, searcherID string, from, offset int) (users []components.User, err error) {
+
+	users = []components.User{}
+
+	var res *sql.Rows
+
+	if db.fts {
+
+		query := ftsQuery(text)
+
+		if query == "" {
+			return users, nil
+		}
+
+		res, err = db.c.QueryContext(ctx, `SELECT u.name
+		FROM (
+			SELECT u.ID AS user_ID, users_fts.rank AS score
+			FROM users_fts, users AS u WHERE users_fts MATCH ? AND u.search_key = users_fts.rowid
+			UNION ALL
+			SELECT pr.user_ID, profiles_fts.rank
+			FROM profiles_fts, profiles AS pr WHERE profiles_fts MATCH ? AND pr.search_key = profiles_fts.rowid
+		) AS m, users AS u
+		WHERE u.ID = m.user_ID AND u.deactivated_at IS NULL
+		AND u.ID NOT IN (
+			SELECT banisher FROM bans WHERE banished = ?
+		) GROUP BY u.ID ORDER BY MIN(m.score), u.name LIMIT ? OFFSET ?`, query, query, searcherID, offset, from)
+
+	} else {
+
+		pattern := likePattern(text)
+
+		res, err = db.c.QueryContext(ctx, `SELECT u.name FROM users AS u LEFT JOIN profiles AS pr ON pr.user_ID = u.ID
+		WHERE (lower(u.name) LIKE lower(?) ESCAPE '\' OR lower(pr.display_name) LIKE lower(?) ESCAPE '\')
+		AND u.deactivated_at IS NULL
+		AND u.ID NOT IN (
+			SELECT banisher FROM bans WHERE banished = ?
+		) ORDER BY `+db.c.dialect.position("lower(u.name)", "lower(?)")+`, length(u.name), u.name LIMIT ? OFFSET ?`,
+			pattern, pattern, searcherID, text, offset, from)
+
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error searching users: %w", err)
+	}
+
+	defer func() {
+		err := res.Close()
+		if err != nil {
+			logger(ctx).Errorf("error closing result set: %v", err)
+		}
+	}()
+
+	for res.Next() {
+
+		user := components.User{}
+
+		err = res.Scan(&user.Uname)
+
+		if err != nil {
+			return nil, fmt.Errorf("error scanning user: %w", err)
+		}
+
+		users = append(users, user)
+	}
+
+	if res.Err() != nil {
+		return nil, fmt.Errorf("error getting next user: %w", res.Err())
+	}
+
+	return users, nil
+}
+
+// searchPhotos returns the posts whose description or comments match `text`, best matches first, hiding those of
+// users that banned `searcherID` and those archived by others.
+func (db *appdbimpl) searchPhotos(ctx context.Context, text string, searcherID string, from, offset int) (posts []components.Post, err error) {
+
+	var res *sql.Rows
+
+	if db.fts {
+
+		query := ftsQuery(text)
+
+		if query == "" {
+			return []components.Post{}, nil
+		}
+
+		// a post is ranked by its best match, be it the description or any of its comments
+		res, err = db.c.QueryContext(ctx, `SELECT p.post_ID, p.poster_ID, p.description, p.creation_date, p.archived_at IS NOT NULL
+		FROM (
+			SELECT p.post_ID AS post_ID, posts_fts.rank AS score
+			FROM posts_fts, posts AS p WHERE posts_fts MATCH ? AND p.search_key = posts_fts.rowid
+			UNION ALL
+			SELECT c.post_code, comments_fts.rank
+			FROM comments_fts, comments AS c WHERE comments_fts MATCH ? AND c.search_key = comments_fts.rowid
+		) AS m, posts AS p
+		WHERE p.post_ID = m.post_ID
+		AND (p.archived_at IS NULL OR p.poster_ID = ?) AND p.deleted_at IS NULL
+		AND p.poster_ID NOT IN (
+			SELECT banisher FROM bans WHERE banished = ?
+			UNION SELECT ID FROM users WHERE deactivated_at IS NOT NULL
+		) GROUP BY p.post_ID ORDER BY MIN(m.score), p.creation_date DESC, p.post_ID LIMIT ? OFFSET ?`, query, query, searcherID, searcherID, offset, from)
+
+	} else {
+
+		pattern := likePattern(text)
+
+		res, err = db.c.QueryContext(ctx, `SELECT p.post_ID, p.poster_ID, p.description, p.creation_date, p.archived_at IS NOT NULL
+		FROM posts AS p
+		WHERE (lower(p.description) LIKE lower(?) ESCAPE '\' OR EXISTS (
+			SELECT * FROM comments AS c WHERE c.post_code = p.post_ID AND lower(c.content) LIKE lower(?) ESCAPE '\'
+		)) AND (p.archived_at IS NULL OR p.poster_ID = ?) AND p.deleted_at IS NULL
+		AND p.poster_ID NOT IN (
+			SELECT banisher FROM bans WHERE banished = ?
+			UNION SELECT ID FROM users WHERE deactivated_at IS NOT NULL
+		) ORDER BY p.creation_date DESC, p.post_ID LIMIT ? OFFSET ?`, pattern, pattern, searcherID, searcherID, offset, from)
+
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error searching photos: %w", err)
+	}
+
+	return db.scanPosts(ctx, res)
+}
+
+// scanPosts reads (and closes) a result set of `post_ID, poster_ID, description, creation_date, archived` rows,
+// resolving the author names, the description entities and the media items. These take queries of their own, made once
+// the result set is closed: it holds its connection until then.
+func (db *appdbimpl) scanPosts(ctx context.Context, rows *sql.Rows) (posts []components.Post, err error) {
+
+	var scanned []components.Post
+
+	for rows.Next() {
+
+		var post components.Post
+
+		err = rows.Scan(&post.Photo_ID.Hash, &post.Author_Name.Uname, &post.Description, &post.CreationTime, &post.Archived)
+
+		if err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+
+		scanned = append(scanned, post)
+	}
+
+	err = rows.Close()
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error getting next post: %w", err)
+	}
+
+	posts = []components.Post{}
+
+	for _, post := range scanned {
+
+		post.Author_Name.Uname, err = db.GetUsername(ctx, post.Author_Name.Uname)
+
+		if err != nil {
+			return nil, fmt.Errorf("error getting author name: %w", err)
+		}
+
+		post.Entities, err = db.entitiesOf(ctx, postEntityOwner, post.Photo_ID.Hash, post.Description)
+
+		if err != nil {
+			return nil, fmt.Errorf("error getting description entities: %w", err)
+		}
+
+		post.Media, err = db.postMedia(ctx, post.Photo_ID.Hash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		posts = append(posts, post)
+	}
+
+	return posts, nil
+}
+
+func (db *appdbimpl) SearchUserByName(ctx context.Context, name string, searcher string) (matches string, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
+
+	searcherID, err := db.GetUserID(ctx, searcher)
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error getting searcher ID: %w", err)
+	}
+
+	users, err := db.searchUsers(ctx, name, searcherID, 0, 255)
+
+	if err != nil {
+		return components.InternalServerError, err
+	}
+
+	// this endpoint has always replied with a bare list
+	data, err := json.Marshal(users)
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error converting users to JSON: %w", err)
+	}
+
+	return string(data), nil
+}
+
+func (db *appdbimpl) Search(ctx context.Context, text string, kind string, searcher string, from, offset int) (results string, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
+
+	searcherID, err := db.GetUserID(ctx, searcher)
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error getting searcher ID: %w", err)
+	}
+
+	var data []byte
+
+	switch kind {
+	case SearchUsers:
+
+		users, err := db.searchUsers(ctx, text, searcherID, from, offset)
+
+		if err != nil {
+			return components.InternalServerError, err
+		}
+
+		data, err = json.MarshalIndent(struct {
+			Users []components.User `json:"users"`
+		}{Users: users}, "", "	")
+
+		if err != nil {
+			return components.InternalServerError, fmt.Errorf("error converting users to JSON: %w", err)
+		}
+
+	case SearchPhotos:
+
+		posts, err := db.searchPhotos(ctx, text, searcherID, from, offset)
+
+		if err != nil {
+			return components.InternalServerError, err
+		}
+
+		data, err = json.MarshalIndent(components.Stream{Posts: posts}, "", "	")
+
+		if err != nil {
+			return components.InternalServerError, fmt.Errorf("error converting photos to JSON: %w", err)
+		}
+
+	default:
+		return components.BadRequestError, errors.New("unknown search type " + kind)
+	}
+
+	return string(data), nil
+}
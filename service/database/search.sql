@@ -0,0 +1,80 @@
+CREATE VIRTUAL TABLE IF NOT EXISTS users_fts USING fts5(name, tokenize = "unicode61 remove_diacritics 2");
+
+CREATE VIRTUAL TABLE IF NOT EXISTS profiles_fts USING fts5(display_name, tokenize = "unicode61 remove_diacritics 2");
+
+CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(description, tokenize = "unicode61 remove_diacritics 2");
+
+CREATE VIRTUAL TABLE IF NOT EXISTS comments_fts USING fts5(content, tokenize = "unicode61 remove_diacritics 2");
+
+-- Index rows are keyed by the search_key of the indexed row (see migrations/sqlite3/005-search-keys.sql), not by its
+-- rowid, which VACUUM may renumber as these tables have no INTEGER PRIMARY KEY. A new row takes the next key. The BEFORE
+-- INSERT triggers clean up the rows that an INSERT OR REPLACE is about to drop, since the REPLACE conflict resolution
+-- does not fire DELETE triggers.
+
+CREATE TRIGGER IF NOT EXISTS users_fts_replace BEFORE INSERT ON users BEGIN
+	DELETE FROM users_fts WHERE rowid IN (SELECT search_key FROM users WHERE ID = new.ID OR name = new.name);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_insert AFTER INSERT ON users BEGIN
+	UPDATE users SET search_key = (SELECT IFNULL(MAX(search_key), 0) + 1 FROM users) WHERE rowid = new.rowid;
+	INSERT OR REPLACE INTO users_fts (rowid, name) SELECT search_key, name FROM users WHERE rowid = new.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_update AFTER UPDATE OF name ON users BEGIN
+	INSERT OR REPLACE INTO users_fts (rowid, name) VALUES (new.search_key, new.name);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_delete AFTER DELETE ON users BEGIN
+	DELETE FROM users_fts WHERE rowid = old.search_key;
+END;
+
+CREATE TRIGGER IF NOT EXISTS profiles_fts_replace BEFORE INSERT ON profiles BEGIN
+	DELETE FROM profiles_fts WHERE rowid IN (SELECT search_key FROM profiles WHERE user_ID = new.user_ID);
+END;
+
+CREATE TRIGGER IF NOT EXISTS profiles_fts_insert AFTER INSERT ON profiles BEGIN
+	UPDATE profiles SET search_key = (SELECT IFNULL(MAX(search_key), 0) + 1 FROM profiles) WHERE rowid = new.rowid;
+	INSERT OR REPLACE INTO profiles_fts (rowid, display_name) SELECT search_key, display_name FROM profiles WHERE rowid = new.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS profiles_fts_update AFTER UPDATE OF display_name ON profiles BEGIN
+	INSERT OR REPLACE INTO profiles_fts (rowid, display_name) VALUES (new.search_key, new.display_name);
+END;
+
+CREATE TRIGGER IF NOT EXISTS profiles_fts_delete AFTER DELETE ON profiles BEGIN
+	DELETE FROM profiles_fts WHERE rowid = old.search_key;
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_replace BEFORE INSERT ON posts BEGIN
+	DELETE FROM posts_fts WHERE rowid IN (SELECT search_key FROM posts WHERE post_ID = new.post_ID);
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_insert AFTER INSERT ON posts BEGIN
+	UPDATE posts SET search_key = (SELECT IFNULL(MAX(search_key), 0) + 1 FROM posts) WHERE rowid = new.rowid;
+	INSERT OR REPLACE INTO posts_fts (rowid, description) SELECT search_key, description FROM posts WHERE rowid = new.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_update AFTER UPDATE OF description ON posts BEGIN
+	INSERT OR REPLACE INTO posts_fts (rowid, description) VALUES (new.search_key, new.description);
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_delete AFTER DELETE ON posts BEGIN
+	DELETE FROM posts_fts WHERE rowid = old.search_key;
+END;
+
+CREATE TRIGGER IF NOT EXISTS comments_fts_replace BEFORE INSERT ON comments BEGIN
+	DELETE FROM comments_fts WHERE rowid IN (SELECT search_key FROM comments WHERE comment_ID = new.comment_ID);
+END;
+
+CREATE TRIGGER IF NOT EXISTS comments_fts_insert AFTER INSERT ON comments BEGIN
+	UPDATE comments SET search_key = (SELECT IFNULL(MAX(search_key), 0) + 1 FROM comments) WHERE rowid = new.rowid;
+	INSERT OR REPLACE INTO comments_fts (rowid, content) SELECT search_key, content FROM comments WHERE rowid = new.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS comments_fts_update AFTER UPDATE OF content ON comments BEGIN
+	INSERT OR REPLACE INTO comments_fts (rowid, content) VALUES (new.search_key, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS comments_fts_delete AFTER DELETE ON comments BEGIN
+	DELETE FROM comments_fts WHERE rowid = old.search_key;
+END;
@@ -1,7 +1,12 @@
 package database
 
+import "context"
+
 // SetName is an example that shows you how to execute insert/update
-func (db *appdbimpl) SetName(name string) error {
-	_, err := db.c.Exec("INSERT INTO example_table (id, name) VALUES (1, ?)", name)
+func (db *appdbimpl) SetName(ctx context.Context, name string) error {
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
+
+	_, err := db.c.ExecContext(ctx, "INSERT INTO example_table (id, name) VALUES (1, ?)", name)
 	return err
 }
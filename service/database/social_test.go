@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+)
+
+// userNames returns the names of the users in `res`, a JSON list of users under `key` (or an error body).
+func userNames(t *testing.T, key string, res string, err error) string {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("getting users: %s, %v", res, err)
+	}
+	var lists map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(res), &lists); err != nil {
+		t.Fatalf("decoding %s: %v", res, err)
+	}
+	var users []components.User
+	if err := json.Unmarshal(lists[key], &users); err != nil {
+		t.Fatalf("decoding %s of %s: %v", key, res, err)
+	}
+	names := []string{}
+	for _, u := range users {
+		names = append(names, u.Uname)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestConformanceFollowsAndBans(t *testing.T) {
+	conform(t, func(t *testing.T, db *appdbimpl) {
+		ctx := context.Background()
+
+		for _, name := range []string{"alice", "bob", "carol"} {
+			login(t, db, name)
+		}
+		steps := []struct {
+			what string
+			fn   func() (string, error)
+		}{
+			{"alice follows bob", func() (string, error) { return db.FollowUser(ctx, "alice", "bob") }},
+			{"carol follows bob", func() (string, error) { return db.FollowUser(ctx, "carol", "bob") }},
+			{"alice follows carol", func() (string, error) { return db.FollowUser(ctx, "alice", "carol") }},
+			{"bob bans alice", func() (string, error) { return db.BanUser(ctx, "bob", "alice") }},
+			{"bob bans carol", func() (string, error) { return db.BanUser(ctx, "bob", "carol") }},
+		}
+		for _, step := range steps {
+			if res, err := step.fn(); err != nil {
+				t.Fatalf("%s: %s, %v", step.what, res, err)
+			}
+		}
+
+		lists := func() map[string]string {
+			followers, err := db.GetUserFollowers(ctx, "bob")
+			following, err2 := db.GetUserFollowing(ctx, "alice")
+			bans, err3 := db.GetUserBans(ctx, "bob")
+			return map[string]string{
+				"followers of bob":  userNames(t, "follow-list", followers, err),
+				"followed by alice": userNames(t, "follow-list", following, err2),
+				"banned by bob":     userNames(t, "users", bans, err3),
+			}
+		}
+
+		check := func(when string, want map[string]string) {
+			t.Helper()
+			// the lists are in no particular order
+			for list, got := range lists() {
+				names := strings.Split(got, ",")
+				sort.Strings(names)
+				if got := strings.Join(names, ","); got != want[list] {
+					t.Errorf("%s, %s: %s, want %s", when, list, got, want[list])
+				}
+			}
+		}
+
+		check("at first", map[string]string{
+			"followers of bob": "alice,carol", "followed by alice": "bob,carol", "banned by bob": "alice,carol",
+		})
+
+		if res, err := db.UnfollowUser(ctx, "carol", "bob"); err != nil {
+			t.Fatalf("carol unfollowing bob: %s, %v", res, err)
+		}
+		if res, err := db.UnbanUser(ctx, "bob", "alice"); err != nil {
+			t.Fatalf("bob unbanning alice: %s, %v", res, err)
+		}
+		check("after unfollowing and unbanning", map[string]string{
+			"followers of bob": "alice", "followed by alice": "bob,carol", "banned by bob": "carol",
+		})
+
+		// a deactivated user is hidden from every list
+		token := login(t, db, "carol")
+		reauth := reauthenticate(t, db, "carol", token, time.Minute)
+		if res, err := db.DeactivateUser(ctx, "carol", reauth); err != nil {
+			t.Fatalf("deactivating carol: %s, %v", res, err)
+		}
+		check("after deactivating carol", map[string]string{
+			"followers of bob": "alice", "followed by alice": "bob", "banned by bob": "",
+		})
+	})
+}
@@ -0,0 +1,7 @@
+package database
+
+import "expvar"
+
+// stats counts the statements run by every AppDatabase, published by expvar as "database": "queries" (reads),
+// "execs" (writes) and "transactions".
+var stats = expvar.NewMap("database")
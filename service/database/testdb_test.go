@@ -0,0 +1,84 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestDB returns a database in a new SQLite file, opened as the webapi command opens it: a single writer
+// connection, whose transactions take the write lock upfront (`_txlock=immediate`), and a pool of read-only ones.
+func newTestDB(t *testing.T) *appdbimpl {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "test.db")
+	params := "_foreign_keys=1&_busy_timeout=5000&_synchronous=NORMAL"
+
+	writer, err := sql.Open("sqlite3", "file:"+filename+"?"+params+"&_journal_mode=WAL&_txlock=immediate")
+	if err != nil {
+		t.Fatalf("opening the writer: %v", err)
+	}
+	writer.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = writer.Close() })
+
+	readers, err := sql.Open("sqlite3", "file:"+filename+"?"+params+"&mode=ro")
+	if err != nil {
+		t.Fatalf("opening the readers: %v", err)
+	}
+	readers.SetMaxOpenConns(4)
+	t.Cleanup(func() { _ = readers.Close() })
+
+	db, err := New(Config{
+		DB:       writer,
+		Readers:  readers,
+		Dialect:  SQLite,
+		Timeouts: Timeouts{Read: 30 * time.Second, Write: 30 * time.Second},
+	})
+	if err != nil {
+		t.Fatalf("creating the database: %v", err)
+	}
+	return db.(*appdbimpl)
+}
+
+// testPhoto returns a post with a single, tiny image, and `description`.
+func testPhoto(t *testing.T, description string) components.Photo {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{R: 0xc0, G: 0xff, B: 0xee, A: 0xff})
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatalf("encoding the image: %v", err)
+	}
+	return components.Photo{Data: base64.StdEncoding.EncodeToString(buf.Bytes()), Desc: description}
+}
+
+// removePhotos removes the images of the posts `photoIDs`, made with testPhoto, once the test is over.
+func removePhotos(t *testing.T, photoIDs ...string) {
+	t.Cleanup(func() {
+		removeImages(photoIDs)
+	})
+}
+
+// count runs the COUNT query `query` on the writer.
+func count(t *testing.T, db *appdbimpl, query string, args ...interface{}) int {
+	t.Helper()
+
+	var n int
+	err := db.c.DB.QueryRow(db.c.dialect.rebind(query), args...).Scan(&n)
+	if err != nil {
+		t.Fatalf("counting %q: %v", query, err)
+	}
+	return n
+}
@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// Timeouts bound the duration of every AppDatabase operation, on top of the deadline (if any) of the context passed
+// by the caller. A zero value means no bound.
+type Timeouts struct {
+	// Read bounds the operations that only read data
+	Read time.Duration
+
+	// Write bounds the operations that change data, including the reads they make along the way
+	Write time.Duration
+}
+
+// reading returns a context for a read-only operation, bounded by the read timeout.
+func (db *appdbimpl) reading(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, db.timeouts.Read)
+}
+
+// writing returns a context for an operation that changes data, bounded by the write timeout.
+func (db *appdbimpl) writing(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, db.timeouts.Write)
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
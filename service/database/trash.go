@@ -0,0 +1,337 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+)
+
+// Deleted posts keep their rows and images until PurgeDeletedPhotos erases them. The deletion time is stored as an
+// RFC 3339 UTC string, so that it can be compared as text.
+
+func (db *appdbimpl) GetDeletedPhotos(ctx context.Context, username string, retention time.Duration) (photos string, err error) {
+
+	ctx, cancel := db.reading(ctx)
+	defer cancel()
+
+	userID, err := db.GetUserID(ctx, username)
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error getting user ID: %w", err)
+	}
+
+	rows, err := db.c.QueryContext(ctx, `SELECT post_ID, deleted_at FROM posts
+	WHERE poster_ID = ? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC`, userID)
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error getting deleted photos: %w", err)
+	}
+
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			logger(ctx).Errorf("error closing result set: %v", err)
+		}
+	}()
+
+	type deletion struct {
+		ID string
+		At time.Time
+	}
+
+	var deletions []deletion
+
+	for rows.Next() {
+
+		var d deletion
+		var deleted_at string
+
+		err = rows.Scan(&d.ID, &deleted_at)
+
+		if err != nil {
+			return components.InternalServerError, fmt.Errorf("error scanning deleted photo: %w", err)
+		}
+
+		d.At, err = time.Parse(time.RFC3339, deleted_at)
+
+		if err != nil {
+			return components.InternalServerError, fmt.Errorf("error parsing deletion time of %s: %w", d.ID, err)
+		}
+
+		deletions = append(deletions, d)
+	}
+
+	if rows.Err() != nil {
+		return components.InternalServerError, fmt.Errorf("error getting next deleted photo: %w", rows.Err())
+	}
+
+	deleted := struct {
+		Posts []components.DeletedPost `json:"posts"`
+	}{
+		Posts: []components.DeletedPost{},
+	}
+
+	for _, d := range deletions {
+
+		post, err := db.getPost(ctx, d.ID)
+
+		if err != nil {
+			return components.InternalServerError, err
+		}
+
+		deleted.Posts = append(deleted.Posts, components.DeletedPost{
+			Post:      post,
+			DeletedAt: components.JSONTime(d.At),
+			PurgeAt:   components.JSONTime(d.At.Add(retention)),
+		})
+	}
+
+	data, err := json.MarshalIndent(deleted, "", "	")
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error converting deleted photos to JSON: %w", err)
+	}
+
+	return string(data), nil
+}
+
+func (db *appdbimpl) RestorePhoto(ctx context.Context, username string, photoID string) (errstring string, err error) {
+
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
+
+	userID, err := db.GetUserID(ctx, username)
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error getting user ID: %w", err)
+	}
+
+	res, err := db.c.ExecContext(ctx, `UPDATE posts SET deleted_at = NULL
+	WHERE post_ID = ? AND poster_ID = ? AND deleted_at IS NOT NULL`, photoID, userID)
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error restoring photo: %w", err)
+	}
+
+	restored, err := res.RowsAffected()
+
+	if err != nil {
+		return components.InternalServerError, fmt.Errorf("error restoring photo: %w", err)
+	}
+
+	if restored == 0 {
+		return components.NotFoundError, fmt.Errorf("photo %s of %s is not among the deleted ones", photoID, username)
+	}
+
+	return "", nil
+}
+
+func (db *appdbimpl) PurgeDeletedPhotos(ctx context.Context, before time.Time) (purged int, err error) {
+
+	rows, err := db.c.QueryContext(ctx, `SELECT post_ID FROM posts WHERE deleted_at IS NOT NULL AND deleted_at < ?`,
+		before.UTC().Format(time.RFC3339))
+
+	if err != nil {
+		return 0, fmt.Errorf("error getting expired photos: %w", err)
+	}
+
+	var IDs []string
+
+	for rows.Next() {
+
+		var ID string
+
+		err = rows.Scan(&ID)
+
+		if err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("error scanning expired photo: %w", err)
+		}
+
+		IDs = append(IDs, ID)
+	}
+
+	err = rows.Close()
+
+	if err == nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("error getting expired photos: %w", err)
+	}
+
+	for _, ID := range IDs {
+
+		erased, err := db.purgePhoto(ctx, ID, before)
+
+		if err != nil {
+			return purged, err
+		}
+
+		if erased {
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// purgePhoto erases the post `photoID`, if it is still deleted since before `before`, with everything attached to it.
+// The images are removed only after the rows are gone: if that fails, RemoveOrphanImages will take care of them. The
+// write timeout applies to each photo, not to the whole purge.
+func (db *appdbimpl) purgePhoto(ctx context.Context, photoID string, before time.Time) (erased bool, err error) {
+
+	ctx, cancel := db.writing(ctx)
+	defer cancel()
+
+	var media []string
+
+	err = db.inTx(ctx, func(tx txconn) error {
+
+		// read before the post is deleted, which may cascade to its media
+		rows, err := tx.QueryContext(ctx, `SELECT media_ID FROM media WHERE post_ID = ?`, photoID)
+
+		if err != nil {
+			return fmt.Errorf("error getting media of photo %s: %w", photoID, err)
+		}
+
+		for rows.Next() {
+
+			var ID string
+
+			err = rows.Scan(&ID)
+
+			if err != nil {
+				_ = rows.Close()
+				return fmt.Errorf("error scanning media of photo %s: %w", photoID, err)
+			}
+
+			media = append(media, ID)
+		}
+
+		err = rows.Close()
+
+		if err == nil {
+			err = rows.Err()
+		}
+
+		if err != nil {
+			return fmt.Errorf("error getting media of photo %s: %w", photoID, err)
+		}
+
+		// the post may have been restored in the meantime
+		res, err := tx.ExecContext(ctx, `DELETE FROM posts WHERE post_ID = ? AND deleted_at IS NOT NULL AND deleted_at < ?`,
+			photoID, before.UTC().Format(time.RFC3339))
+
+		if err != nil {
+			return fmt.Errorf("error purging photo %s: %w", photoID, err)
+		}
+
+		deleted, err := res.RowsAffected()
+
+		if err != nil {
+			return fmt.Errorf("error purging photo %s: %w", photoID, err)
+		}
+
+		if deleted == 0 {
+			return nil
+		}
+
+		erased = true
+
+		// foreign keys may not be enforced, so what would cascade is deleted explicitly
+		for _, stmt := range []string{
+			`DELETE FROM photo_metadata WHERE media_ID IN (SELECT media_ID FROM media WHERE post_ID = ?)`,
+			`DELETE FROM media WHERE post_ID = ?`,
+			`DELETE FROM likes WHERE post_ID = ?`,
+			`DELETE FROM comment_tags WHERE comment_ID IN (SELECT comment_ID FROM comments WHERE post_code = ?)`,
+			`DELETE FROM comment_mentions WHERE comment_ID IN (SELECT comment_ID FROM comments WHERE post_code = ?)`,
+			`DELETE FROM comments WHERE post_code = ?`,
+			`DELETE FROM post_tags WHERE post_ID = ?`,
+			`DELETE FROM post_mentions WHERE post_ID = ?`,
+		} {
+			_, err = tx.ExecContext(ctx, stmt, photoID)
+
+			if err != nil {
+				return fmt.Errorf("error purging photo %s: %w", photoID, err)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil || !erased {
+		return false, err
+	}
+
+	removeImages(media)
+
+	return true, nil
+}
+
+func (db *appdbimpl) RemoveOrphanImages(ctx context.Context, before time.Time) (removed int, err error) {
+
+	entries, err := os.ReadDir(PhotoDir)
+
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("error listing %s: %w", PhotoDir, err)
+	}
+
+	for _, e := range entries {
+
+		ID := strings.TrimSuffix(e.Name(), ".png")
+
+		// the default picture is not the image of any post
+		if e.IsDir() || ID == e.Name() || ID == components.DefaultAvatar {
+			continue
+		}
+
+		info, err := e.Info()
+
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return removed, fmt.Errorf("error reading %s: %w", e.Name(), err)
+		}
+
+		// images are written before their post is committed, recent ones may belong to an upload in progress
+		if !info.ModTime().Before(before) {
+			continue
+		}
+
+		var count int
+
+		err = db.c.QueryRowContext(ctx, `SELECT COUNT(*) FROM media WHERE media_ID = ?`, ID).Scan(&count)
+
+		if err != nil {
+			return removed, fmt.Errorf("error checking media %s: %w", ID, err)
+		}
+
+		if count > 0 {
+			continue
+		}
+
+		err = os.Remove(filepath.Join(PhotoDir, e.Name()))
+
+		if err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("error removing orphan image %s: %w", e.Name(), err)
+		}
+
+		removed++
+	}
+
+	return removed, nil
+}
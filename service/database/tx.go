@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// dbtx is implemented by both conn and txconn, so that the helpers shared by several operations (e.g., indexEntities)
+// can run inside the transaction of the caller.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// inTx runs `fn` in a transaction bound to `ctx`, which is committed if `fn` returns nil and rolled back otherwise (or
+// if `fn` panics, or `ctx` is done). Every operation made of more than one statement must go through here, so that a
+// failure halfway never leaves a partial state behind.
+func (db *appdbimpl) inTx(ctx context.Context, fn func(tx txconn) error) (err error) {
+
+	tx, err := db.c.BeginTx(ctx, nil)
+
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	stats.Add("transactions", 1)
+
+	err = fn(txconn{Tx: tx, dialect: db.c.dialect})
+
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
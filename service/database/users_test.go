@@ -0,0 +1,241 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+)
+
+// concurrently calls `fn` from `n` goroutines at once, and waits for them.
+func concurrently(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			fn(i)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+}
+
+func TestConformanceConcurrentLogins(t *testing.T) {
+	conform(t, func(t *testing.T, db *appdbimpl) {
+		ctx := context.Background()
+
+		const logins, names = 64, 4
+		sessions := make([]string, logins)
+		errs := make([]error, logins)
+
+		concurrently(logins, func(i int) {
+			sessions[i], errs[i] = db.PostUserID(ctx, fmt.Sprintf("user%d", i%names))
+		})
+
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("login %d: %v", i, err)
+			} else if sessions[i] != sessions[i%names] {
+				t.Errorf("login %d got a session %s, login %d got %s", i, sessions[i], i%names, sessions[i%names])
+			}
+		}
+
+		if n := count(t, db, `SELECT COUNT(*) FROM users`); n != names {
+			t.Errorf("%d users after the logins of %d names", n, names)
+		}
+		if n := count(t, db, `SELECT COUNT(DISTINCT token) FROM users`); n != names {
+			t.Errorf("%d tokens for %d users", n, names)
+		}
+	})
+}
+
+// referencesTo returns how many rows refer to the user `userID`, by column (see userReferences).
+func referencesTo(t *testing.T, db *appdbimpl, userID string) map[string]int {
+	refs := map[string]int{}
+	for _, ref := range userReferences {
+		refs[ref.table+"."+ref.column] = count(t, db,
+			fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s = ?`, ref.table, ref.column), userID)
+	}
+	return refs
+}
+
+// checkNoDanglingReferences fails the test if any row refers to a user that does not exist.
+func checkNoDanglingReferences(t *testing.T, db *appdbimpl) {
+	t.Helper()
+
+	for _, ref := range userReferences {
+		n := count(t, db, fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s NOT IN (SELECT ID FROM users)`,
+			ref.table, ref.column))
+		if n > 0 {
+			t.Errorf("%d rows of %s refer to missing users by %s", n, ref.table, ref.column)
+		}
+	}
+}
+
+// newUserWithReferences creates `name` and `other`, with a row referring to `name` in every table of userReferences,
+// and returns the ID of `name`.
+func newUserWithReferences(t *testing.T, db *appdbimpl, name string, other string) string {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, u := range []string{name, other} {
+		_, err := db.PostUserID(ctx, u)
+		if err != nil {
+			t.Fatalf("creating %s: %v", u, err)
+		}
+	}
+	userID, err := db.GetUserID(ctx, name)
+	if err != nil {
+		t.Fatalf("getting the ID of %s: %v", name, err)
+	}
+
+	own, others := "post-of-"+name, "post-of-"+other
+	removePhotos(t, own, others)
+
+	steps := []struct {
+		what string
+		fn   func() (string, error)
+	}{
+		{"following", func() (string, error) { return db.FollowUser(ctx, name, other) }},
+		{"being followed", func() (string, error) { return db.FollowUser(ctx, other, name) }},
+		{"banning", func() (string, error) { return db.BanUser(ctx, name, other) }},
+		{"being banned", func() (string, error) { return db.BanUser(ctx, other, name) }},
+		{"posting", func() (string, error) { return db.UploadPhoto(ctx, name, testPhoto(t, "hi"), own) }},
+		{"being mentioned", func() (string, error) {
+			return db.UploadPhoto(ctx, other, testPhoto(t, "hi @"+name), others)
+		}},
+		{"liking", func() (string, error) { return db.LikePhoto(ctx, userID, others) }},
+		{"commenting", func() (string, error) {
+			return db.CommentPhoto(ctx, name, others, components.Comment{
+				Comment_ID: components.SHA256hash{Hash: "comment-of-" + name},
+				Parent:     components.SHA256hash{Hash: others},
+				Body:       "@" + name + " was here",
+			})
+		}},
+		{"a profile", func() (string, error) {
+			display := "The " + name
+			return db.UpdateProfile(ctx, name, components.ProfileUpdate{DisplayName: &display})
+		}},
+		{"an export", func() (string, error) { return db.CreateExport(ctx, name, time.Hour) }},
+	}
+	for _, step := range steps {
+		_, err := step.fn()
+		if err != nil {
+			t.Fatalf("%s %s: %v", name, step.what, err)
+		}
+	}
+
+	for ref, n := range referencesTo(t, db, userID) {
+		if n == 0 {
+			t.Fatalf("no row of %s refers to %s", ref, name)
+		}
+	}
+	return userID
+}
+
+func TestConformanceConcurrentRenames(t *testing.T) {
+	conform(t, func(t *testing.T, db *appdbimpl) {
+		ctx := context.Background()
+
+		oldID := newUserWithReferences(t, db, "alice", "bob")
+		before := referencesTo(t, db, oldID)
+
+		// every rename starts from the same name, only one can find it
+		const renames = 16
+		results := make([]string, renames)
+		errs := make([]error, renames)
+
+		concurrently(renames, func(i int) {
+			results[i], errs[i] = db.ChangeUsername(ctx, "alice", fmt.Sprintf("alice%d", i))
+		})
+
+		renamed := -1
+		for i, err := range errs {
+			if err == nil {
+				if renamed >= 0 {
+					t.Errorf("both alice%d and alice%d renamed alice", renamed, i)
+				}
+				renamed = i
+			} else if results[i] != components.NotFoundError {
+				t.Errorf("alice%d: %s, %v", i, results[i], err)
+			}
+		}
+		if renamed < 0 {
+			t.Fatalf("no rename succeeded: %v", errs[0])
+		}
+
+		newName := fmt.Sprintf("alice%d", renamed)
+		if n := count(t, db, `SELECT COUNT(*) FROM users`); n != 2 {
+			t.Errorf("%d users after renaming alice, want 2", n)
+		}
+		newID, err := db.GetUserID(ctx, newName)
+		if err != nil {
+			t.Fatalf("getting the ID of %s: %v", newName, err)
+		}
+
+		after := referencesTo(t, db, newID)
+		for ref, n := range before {
+			if after[ref] != n {
+				t.Errorf("%d rows of %s refer to %s, %d referred to alice", after[ref], ref, newName, n)
+			}
+		}
+		if left := referencesTo(t, db, oldID); !isZero(left) {
+			t.Errorf("rows still refer to alice: %v", left)
+		}
+		checkNoDanglingReferences(t, db)
+	})
+}
+
+func TestConformanceConcurrentRenamesToOneName(t *testing.T) {
+	conform(t, func(t *testing.T, db *appdbimpl) {
+		ctx := context.Background()
+
+		// several users race for the same name, only one can take it
+		const renames = 8
+		for i := 0; i < renames; i++ {
+			newUserWithReferences(t, db, fmt.Sprintf("user%d", i), fmt.Sprintf("friend%d", i))
+		}
+
+		results := make([]string, renames)
+		errs := make([]error, renames)
+
+		concurrently(renames, func(i int) {
+			results[i], errs[i] = db.ChangeUsername(ctx, fmt.Sprintf("user%d", i), "taken")
+		})
+
+		taken := 0
+		for i, err := range errs {
+			if err == nil {
+				taken++
+			} else if results[i] != components.ConflictError {
+				t.Errorf("user%d: %s, %v", i, results[i], err)
+			}
+		}
+		if taken != 1 {
+			t.Errorf("%d users took the name", taken)
+		}
+
+		if n := count(t, db, `SELECT COUNT(*) FROM users`); n != 2*renames {
+			t.Errorf("%d users after the renames, want %d", n, 2*renames)
+		}
+		if n := count(t, db, `SELECT COUNT(*) FROM users WHERE name = 'taken'`); n != 1 {
+			t.Errorf("%d users named taken", n)
+		}
+		checkNoDanglingReferences(t, db)
+	})
+}
+
+func isZero(counts map[string]int) bool {
+	for _, n := range counts {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,201 @@
+/*
+Package logfile is a log file that rotates itself: once it grows over a size, or gets older than an age, it is renamed
+with the time of the rotation (e.g., `webapi.log` becomes `webapi-20240131T235959.000.log`) and a new one is started.
+Only the most recent rotated files are kept.
+
+A File is an io.Writer, safe for concurrent use, meant to be the output of a logger.
+*/
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/globaltime"
+)
+
+// timeFormat is the format of the time in the names of the rotated files, which sort in chronological order
+const timeFormat = "20060102T150405.000"
+
+// openFile opens the log files, replaced in the tests
+var openFile = os.OpenFile
+
+// Config describes when a File rotates, and how many rotated files are kept
+type Config struct {
+	// Path is the path of the log file
+	Path string
+
+	// MaxSize is the size, in bytes, past which the file is rotated; 0 disables the rotation by size
+	MaxSize int64
+
+	// MaxAge is how long a file is written to before it is rotated; 0 disables the rotation by age. The age of a file
+	// found when opening is counted from then.
+	MaxAge time.Duration
+
+	// MaxBackups is how many rotated files are kept, the oldest ones are removed; 0 keeps them all
+	MaxBackups int
+}
+
+// File is a log file that rotates as set in its Config
+type File struct {
+	cfg Config
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	started time.Time
+}
+
+// Open opens (or creates) the log file `cfg.Path`, appending to it.
+func Open(cfg Config) (*File, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("log file path is required")
+	}
+	if cfg.MaxSize < 0 || cfg.MaxAge < 0 || cfg.MaxBackups < 0 {
+		return nil, fmt.Errorf("log file rotation settings must not be negative")
+	}
+
+	lf := &File{cfg: cfg}
+
+	err := lf.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return lf, nil
+}
+
+// Write writes `p` to the file, rotating it first if `p` would make it too large, or if it is too old. A single write
+// is never split between two files. If the rotation fails, `p` is still written to the current file, which is rotated
+// at the next write, and the error of the rotation is returned.
+func (lf *File) Write(p []byte) (n int, err error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if lf.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	tooLarge := lf.cfg.MaxSize > 0 && lf.size > 0 && lf.size+int64(len(p)) > lf.cfg.MaxSize
+	tooOld := lf.cfg.MaxAge > 0 && globaltime.Since(lf.started) >= lf.cfg.MaxAge
+
+	var rotateErr error
+	if tooLarge || tooOld {
+		rotateErr = lf.rotate()
+	}
+
+	n, err = lf.f.Write(p)
+	lf.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// Close closes the file, later writes fail.
+func (lf *File) Close() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if lf.f == nil {
+		return nil
+	}
+
+	err := lf.f.Close()
+	lf.f = nil
+	return err
+}
+
+// open opens the file at Path, in place of the current one (if any), which is closed.
+func (lf *File) open() error {
+	err := os.MkdirAll(filepath.Dir(lf.cfg.Path), 0755)
+	if err != nil {
+		return fmt.Errorf("error creating log directory: %w", err)
+	}
+
+	f, err := openFile(lf.cfg.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("error reading log file: %w", err)
+	}
+
+	if lf.f != nil {
+		_ = lf.f.Close()
+	}
+	lf.f = f
+	lf.size = info.Size()
+	lf.started = globaltime.Now()
+	return nil
+}
+
+// rotate renames the current file with the time, starts a new one and removes the rotated files in excess. The
+// current file stays open until the new one is: if that fails, it gets its name back, and is still written to.
+func (lf *File) rotate() error {
+	prefix, ext := lf.nameParts()
+
+	// a file rotated within the same millisecond must not be replaced: the time is moved forward instead
+	now := globaltime.Now().UTC()
+	rotated := prefix + now.Format(timeFormat) + ext
+	for _, err := os.Lstat(rotated); err == nil; _, err = os.Lstat(rotated) {
+		now = now.Add(time.Millisecond)
+		rotated = prefix + now.Format(timeFormat) + ext
+	}
+
+	err := os.Rename(lf.cfg.Path, rotated)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error rotating log file: %w", err)
+	}
+
+	err = lf.open()
+	if err != nil {
+		_ = os.Rename(rotated, lf.cfg.Path)
+		return err
+	}
+
+	lf.prune(prefix, ext)
+	return nil
+}
+
+// prune removes the oldest rotated files, keeping MaxBackups of them. Failures are ignored, the files will be removed
+// at the next rotation.
+func (lf *File) prune(prefix string, ext string) {
+	if lf.cfg.MaxBackups == 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(prefix + "*" + ext)
+	if err != nil {
+		return
+	}
+
+	var rotated []string
+	for _, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(m, prefix), ext)
+		if _, err := time.Parse(timeFormat, stamp); err == nil {
+			rotated = append(rotated, m)
+		}
+	}
+
+	sort.Strings(rotated)
+
+	for len(rotated) > lf.cfg.MaxBackups {
+		_ = os.Remove(rotated[0])
+		rotated = rotated[1:]
+	}
+}
+
+// nameParts splits the path of the file around the place of the time of the rotated files: `dir/name-` and `.ext`.
+func (lf *File) nameParts() (prefix string, ext string) {
+	ext = filepath.Ext(lf.cfg.Path)
+	return strings.TrimSuffix(lf.cfg.Path, ext) + "-", ext
+}
@@ -0,0 +1,233 @@
+package logfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/globaltime"
+)
+
+// fixClock stops the clock at an arbitrary time, and returns a function that moves it forward.
+func fixClock(t *testing.T) (advance func(time.Duration)) {
+	t.Helper()
+
+	globaltime.FixedTime = time.Date(2024, 1, 31, 23, 59, 59, 0, time.UTC)
+	t.Cleanup(func() { globaltime.FixedTime = time.Time{} })
+
+	return func(d time.Duration) {
+		globaltime.FixedTime = globaltime.FixedTime.Add(d)
+	}
+}
+
+// openTestFile opens a log file `webapi.log` of `cfg` in a temporary directory.
+func openTestFile(t *testing.T, cfg Config) *File {
+	t.Helper()
+
+	cfg.Path = filepath.Join(t.TempDir(), "webapi.log")
+	lf, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("opening %s: %v", cfg.Path, err)
+	}
+	t.Cleanup(func() { _ = lf.Close() })
+	return lf
+}
+
+func write(t *testing.T, lf *File, line string) {
+	t.Helper()
+
+	n, err := lf.Write([]byte(line))
+	if err != nil || n != len(line) {
+		t.Fatalf("writing %q: %d, %v", line, n, err)
+	}
+}
+
+// contents returns the contents of the files next to the log file, by name.
+func contents(t *testing.T, lf *File) map[string]string {
+	t.Helper()
+
+	dir := filepath.Dir(lf.cfg.Path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("reading %s: %v", dir, err)
+	}
+	files := map[string]string{}
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatalf("reading %s: %v", e.Name(), err)
+		}
+		files[e.Name()] = string(data)
+	}
+	return files
+}
+
+func checkFiles(t *testing.T, lf *File, want map[string]string) {
+	t.Helper()
+
+	got := contents(t, lf)
+	if len(got) != len(want) {
+		t.Errorf("files %v, want %v", got, want)
+		return
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("%s is %q, want %q (files: %v)", name, got[name], content, got)
+		}
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	tests := []Config{
+		{},
+		{Path: "webapi.log", MaxSize: -1},
+		{Path: "webapi.log", MaxAge: -time.Second},
+		{Path: "webapi.log", MaxBackups: -1},
+	}
+	for _, cfg := range tests {
+		if lf, err := Open(cfg); err == nil {
+			_ = lf.Close()
+			t.Errorf("Open(%+v) succeeded", cfg)
+		}
+	}
+}
+
+func TestRotateBySize(t *testing.T) {
+	// every rotation happens at the same time, the names of the rotated files are one millisecond apart
+	fixClock(t)
+	lf := openTestFile(t, Config{MaxSize: 10})
+
+	write(t, lf, "first\n")
+	write(t, lf, "abc\n")
+
+	// 10 bytes would be too many: the line goes to a new file, whole
+	write(t, lf, "second\n")
+
+	// a line larger than MaxSize is written to an empty file anyway
+	write(t, lf, "a line too long for any file\n")
+	write(t, lf, "third\n")
+
+	checkFiles(t, lf, map[string]string{
+		"webapi-20240131T235959.000.log": "first\nabc\n",
+		"webapi-20240131T235959.001.log": "second\n",
+		"webapi-20240131T235959.002.log": "a line too long for any file\n",
+		"webapi.log":                     "third\n",
+	})
+}
+
+func TestRotateByAge(t *testing.T) {
+	advance := fixClock(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "webapi.log")
+
+	// the age of a file found when opening is counted from then
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatalf("writing %s: %v", path, err)
+	}
+	lf, err := Open(Config{Path: path, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("opening %s: %v", path, err)
+	}
+	t.Cleanup(func() { _ = lf.Close() })
+
+	advance(59 * time.Minute)
+	write(t, lf, "first\n")
+	advance(time.Minute)
+	write(t, lf, "second\n")
+	advance(59 * time.Minute)
+	write(t, lf, "third\n")
+
+	checkFiles(t, lf, map[string]string{
+		"webapi-20240201T005959.000.log": "old\nfirst\n",
+		"webapi.log":                     "second\nthird\n",
+	})
+}
+
+func TestPrune(t *testing.T) {
+	advance := fixClock(t)
+	lf := openTestFile(t, Config{MaxSize: 1, MaxBackups: 2})
+
+	// files that only look like rotated ones are left alone
+	dir := filepath.Dir(lf.cfg.Path)
+	for _, name := range []string{"webapi-notes.log", "webapi-2024.log", "other-20240101T000000.000.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+
+	for _, line := range []string{"1", "2", "3", "4", "5"} {
+		write(t, lf, line)
+		advance(time.Second)
+	}
+
+	checkFiles(t, lf, map[string]string{
+		"webapi-20240201T000002.000.log": "3",
+		"webapi-20240201T000003.000.log": "4",
+		"webapi.log":                     "5",
+		"webapi-notes.log":               "webapi-notes.log",
+		"webapi-2024.log":                "webapi-2024.log",
+		"other-20240101T000000.000.log":  "other-20240101T000000.000.log",
+	})
+
+	// no pruning without MaxBackups
+	all := openTestFile(t, Config{MaxSize: 1})
+	for _, line := range []string{"1", "2", "3", "4", "5"} {
+		write(t, all, line)
+		advance(time.Second)
+	}
+	if files := contents(t, all); len(files) != 5 {
+		t.Errorf("files %v, want all the 4 rotated ones and the log", files)
+	}
+}
+
+func TestFailedReopen(t *testing.T) {
+	advance := fixClock(t)
+	lf := openTestFile(t, Config{MaxSize: 10})
+
+	write(t, lf, "before\n")
+	advance(time.Second)
+
+	errOpen := errors.New("too many open files")
+	openFile = func(string, int, os.FileMode) (*os.File, error) {
+		return nil, errOpen
+	}
+	defer func() { openFile = os.OpenFile }()
+
+	// the line is not lost: it goes to the current file, which keeps its name
+	n, err := lf.Write([]byte("during\n"))
+	if !errors.Is(err, errOpen) || n != len("during\n") {
+		t.Errorf("writing while the new file can't be opened: %d, %v", n, err)
+	}
+	checkFiles(t, lf, map[string]string{"webapi.log": "before\nduring\n"})
+
+	// the rotation is tried again at the next write
+	openFile = os.OpenFile
+	advance(time.Second)
+	write(t, lf, "after\n")
+
+	checkFiles(t, lf, map[string]string{
+		"webapi-20240201T000001.000.log": "before\nduring\n",
+		"webapi.log":                     "after\n",
+	})
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	lf := openTestFile(t, Config{})
+
+	write(t, lf, "line\n")
+	if err := lf.Close(); err != nil {
+		t.Fatalf("closing: %v", err)
+	}
+	if err := lf.Close(); err != nil {
+		t.Errorf("closing twice: %v", err)
+	}
+	if _, err := lf.Write([]byte("late\n")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("writing after closing: %v", err)
+	}
+	if got := contents(t, lf)["webapi.log"]; !strings.HasSuffix(got, "line\n") || strings.Contains(got, "late") {
+		t.Errorf("webapi.log is %q", got)
+	}
+}
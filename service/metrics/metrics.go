@@ -0,0 +1,240 @@
+/*
+Package metrics is a minimal registry of counters and histograms, exposed in the Prometheus text format by Handler,
+together with the metrics of the Go runtime.
+
+Metrics are created once, usually as package variables, and can have labels: the values of the labels are given, in
+order, to each Add or Observe.
+
+	var requests = metrics.NewCounter("http_requests_total", "Requests handled.", "route")
+
+	requests.Add(1, "/users/:user_name")
+
+Only what the service needs of the format is supported: no summaries, no timestamps, no exemplars.
+*/
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DurationBuckets are the default upper bounds, in seconds, of the histograms of durations
+var DurationBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// family is a metric with all of its series
+type family interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []family
+)
+
+func register(f family) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, f)
+}
+
+// Counter is a value that only goes up, one per combination of label values
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labels []string
+	value  float64
+}
+
+// NewCounter registers a new counter, whose name should end in `_total`.
+func NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, series: map[string]*counterSeries{}}
+	register(c)
+	return c
+}
+
+// Add adds `v` (not negative) to the series of `labelValues`.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := seriesKey(c.labels, labelValues)
+
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{labels: labelValues}
+		c.series[key] = s
+	}
+
+	s.value += v
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.labels, "", ""), formatValue(s.value))
+	}
+}
+
+// Histogram counts observations in buckets, one set of buckets per combination of label values
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+
+	// counts holds the observations of each bucket, not cumulative; the last one is +Inf
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers a new histogram, with the upper bounds `buckets` in increasing order.
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+	register(h)
+	return h
+}
+
+// Observe records `v` in the series of `labelValues`.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := seriesKey(h.labels, labelValues)
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labels: labelValues, counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+
+	s.counts[sort.SearchFloat64s(h.buckets, v)]++
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = formatValue(h.buckets[i])
+			}
+
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labels, "le", le), cumulative)
+		}
+
+		_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labels, "", ""), formatValue(s.sum))
+		_, _ = fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labels, "", ""), s.count)
+	}
+}
+
+// Handler returns the handler of the scrapes, which writes every metric registered so far, and those of the runtime.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		registryMu.Lock()
+		families := append([]family(nil), registry...)
+		registryMu.Unlock()
+
+		writeRuntime(w)
+
+		for _, f := range families {
+			f.write(w)
+		}
+	})
+}
+
+// seriesKey returns the key of the series of `values`, which must be as many as `labels`.
+func seriesKey(labels []string, values []string) string {
+	if len(values) != len(labels) {
+		panic(fmt.Sprintf("metrics: %d label values given for labels %v", len(values), labels))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+
+	switch series := m.(type) {
+	case map[string]*counterSeries:
+		for k := range series {
+			keys = append(keys, k)
+		}
+	case map[string]*histogramSeries:
+		for k := range series {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func writeHeader(w io.Writer, name string, help string, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// formatLabels returns the `{name="value",...}` part of a sample, with the label `extra` (if not empty) last.
+func formatLabels(labels []string, values []string, extra string, extraValue string) string {
+	if len(labels) == 0 && extra == "" {
+		return ""
+	}
+
+	escape := strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	pairs := make([]string, 0, len(labels)+1)
+
+	for i, label := range labels {
+		pairs = append(pairs, label+`="`+escape.Replace(values[i])+`"`)
+	}
+
+	if extra != "" {
+		pairs = append(pairs, extra+`="`+escape.Replace(extraValue)+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
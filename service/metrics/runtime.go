@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// writeRuntime writes the metrics of the Go runtime, named as those of the official client so that the usual
+// dashboards work. The memory statistics are read once per scrape.
+func writeRuntime(w io.Writer) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	for _, m := range []struct {
+		name  string
+		help  string
+		kind  string
+		value float64
+	}{
+		{"go_goroutines", "Number of goroutines that currently exist.", "gauge", float64(runtime.NumGoroutine())},
+		{"go_threads", "Number of OS threads created.", "gauge", float64(threads())},
+		{"go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", "gauge", float64(ms.Alloc)},
+		{"go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", "counter", float64(ms.TotalAlloc)},
+		{"go_memstats_sys_bytes", "Number of bytes obtained from system.", "gauge", float64(ms.Sys)},
+		{"go_memstats_heap_alloc_bytes", "Number of heap bytes allocated and still in use.", "gauge", float64(ms.HeapAlloc)},
+		{"go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", "gauge", float64(ms.HeapInuse)},
+		{"go_memstats_heap_objects", "Number of allocated objects.", "gauge", float64(ms.HeapObjects)},
+		{"go_memstats_mallocs_total", "Total number of mallocs.", "counter", float64(ms.Mallocs)},
+		{"go_memstats_frees_total", "Total number of frees.", "counter", float64(ms.Frees)},
+		{"go_memstats_next_gc_bytes", "Number of heap bytes when next garbage collection will take place.", "gauge", float64(ms.NextGC)},
+		{"go_gc_cycles_total", "Number of completed GC cycles.", "counter", float64(ms.NumGC)},
+		{"go_gc_pause_seconds_total", "Total time spent in GC stop-the-world pauses.", "counter", float64(ms.PauseTotalNs) / 1e9},
+	} {
+		writeHeader(w, m.name, m.help, m.kind)
+		_, _ = fmt.Fprintf(w, "%s %s\n", m.name, formatValue(m.value))
+	}
+}
+
+func threads() int {
+	n, _ := runtime.ThreadCreateProfile(nil)
+	return n
+}
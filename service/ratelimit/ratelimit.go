@@ -0,0 +1,149 @@
+/*
+Package ratelimit limits how often a client can do something, with a token bucket per client: each request takes a
+token, and the bucket is refilled at a steady rate up to its capacity, so that short bursts are allowed while the
+average rate is bounded.
+
+Buckets live in memory, keyed by any string (e.g., a client IP): those that have been idle long enough to be full
+again are evicted from time to time, as they are no different from new ones.
+*/
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/globaltime"
+)
+
+// sweepInterval is how often a Limiter evicts its idle buckets
+const sweepInterval = time.Minute
+
+// Budget is a number of requests per period: a bucket holds up to Requests tokens, and gets them back over Period
+type Budget struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseBudget parses a budget written as `<requests>/<period>`, e.g. `30/1m`.
+func ParseBudget(s string) (Budget, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return Budget{}, fmt.Errorf("invalid budget %q, it must be <requests>/<period>", s)
+	}
+
+	requests, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return Budget{}, fmt.Errorf("invalid number of requests in budget %q: %w", s, err)
+	}
+
+	period, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return Budget{}, fmt.Errorf("invalid period in budget %q: %w", s, err)
+	}
+
+	b := Budget{Requests: requests, Period: period}
+	if !b.Valid() {
+		return Budget{}, fmt.Errorf("invalid budget %q, requests and period must be positive", s)
+	}
+
+	return b, nil
+}
+
+// Valid returns whether both the requests and the period are positive.
+func (b Budget) Valid() bool {
+	return b.Requests > 0 && b.Period > 0
+}
+
+func (b Budget) String() string {
+	return fmt.Sprintf("%d/%s", b.Requests, b.Period)
+}
+
+// refill returns the time needed to get back one token.
+func (b Budget) refill() time.Duration {
+	return b.Period / time.Duration(b.Requests)
+}
+
+// Limiter is a set of token buckets with the same Budget, safe for concurrent use
+type Limiter struct {
+	budget Budget
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New returns a Limiter of `budget`, which must be valid.
+func New(budget Budget) *Limiter {
+	return &Limiter{budget: budget, buckets: map[string]*bucket{}, lastSweep: globaltime.Now()}
+}
+
+// Budget returns the budget of the limiter.
+func (l *Limiter) Budget() Budget {
+	return l.budget
+}
+
+// Allow takes a token from the bucket of each of `keys`, if all of them have one. Otherwise, none is taken, the
+// request must be rejected, and `retryAfter` is when all of them will have a token again.
+func (l *Limiter) Allow(keys ...string) (ok bool, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := globaltime.Now()
+
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	capacity := float64(l.budget.Requests)
+
+	buckets := make([]*bucket, len(keys))
+	for i, key := range keys {
+		b, found := l.buckets[key]
+		if !found {
+			b = &bucket{tokens: capacity, last: now}
+			l.buckets[key] = b
+		}
+
+		b.tokens += float64(now.Sub(b.last)) / float64(l.budget.refill())
+		if b.tokens > capacity {
+			b.tokens = capacity
+		}
+		b.last = now
+
+		if b.tokens < 1 {
+			if wait := time.Duration((1 - b.tokens) * float64(l.budget.refill())); wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+		buckets[i] = b
+	}
+
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// sweep evicts the buckets that are full again by `now`.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		missing := float64(l.budget.Requests) - b.tokens
+		if now.Sub(b.last) >= time.Duration(missing*float64(l.budget.refill())) {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.lastSweep = now
+}
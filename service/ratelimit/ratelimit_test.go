@@ -0,0 +1,173 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/globaltime"
+)
+
+// fixClock stops the clock of the limiters at an arbitrary time, and returns a function that moves it forward.
+func fixClock(t *testing.T) (advance func(time.Duration)) {
+	t.Helper()
+
+	globaltime.FixedTime = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	t.Cleanup(func() { globaltime.FixedTime = time.Time{} })
+
+	return func(d time.Duration) {
+		globaltime.FixedTime = globaltime.FixedTime.Add(d)
+	}
+}
+
+func TestParseBudget(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  Budget
+		valid bool
+	}{
+		{"30/1m", Budget{Requests: 30, Period: time.Minute}, true},
+		{" 10 / 1h30m ", Budget{Requests: 10, Period: 90 * time.Minute}, true},
+		{"1/500ms", Budget{Requests: 1, Period: 500 * time.Millisecond}, true},
+		{"", Budget{}, false},
+		{"30", Budget{}, false},
+		{"30/", Budget{}, false},
+		{"/1m", Budget{}, false},
+		{"thirty/1m", Budget{}, false},
+		{"30/1", Budget{}, false},
+		{"0/1m", Budget{}, false},
+		{"-1/1m", Budget{}, false},
+		{"30/0s", Budget{}, false},
+		{"30/-1m", Budget{}, false},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseBudget(tt.in)
+		if tt.valid && (err != nil || got != tt.want) {
+			t.Errorf("ParseBudget(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("ParseBudget(%q) = %v, want an error", tt.in, got)
+		}
+	}
+
+	if b, _ := ParseBudget("30/1m"); b.String() != "30/1m0s" {
+		t.Errorf("String() = %s", b)
+	}
+}
+
+func TestAllowBurstAndRefill(t *testing.T) {
+	advance := fixClock(t)
+	l := New(Budget{Requests: 3, Period: 3 * time.Second})
+
+	// a new client has the whole burst
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("alice"); !ok {
+			t.Fatalf("request %d of the burst rejected", i+1)
+		}
+	}
+	ok, retryAfter := l.Allow("alice")
+	if ok || retryAfter != time.Second {
+		t.Fatalf("request over budget: %v, retry after %s, want a rejection for 1s", ok, retryAfter)
+	}
+
+	// a rejected request takes no token
+	advance(500 * time.Millisecond)
+	if ok, retryAfter := l.Allow("alice"); ok || retryAfter != 500*time.Millisecond {
+		t.Errorf("half a token later: %v, retry after %s", ok, retryAfter)
+	}
+	advance(500 * time.Millisecond)
+	if ok, _ := l.Allow("alice"); !ok {
+		t.Error("rejected after the refill of a token")
+	}
+	if ok, _ := l.Allow("alice"); ok {
+		t.Error("allowed twice after the refill of a token")
+	}
+
+	// the bucket does not fill up past its capacity
+	advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("alice"); !ok {
+			t.Fatalf("request %d of the burst after an hour rejected", i+1)
+		}
+	}
+	if ok, _ := l.Allow("alice"); ok {
+		t.Error("the bucket filled up past its capacity")
+	}
+
+	// the other clients have their own bucket
+	if ok, _ := l.Allow("bob"); !ok {
+		t.Error("bob rejected for the requests of alice")
+	}
+}
+
+func TestAllowAllKeysOrNone(t *testing.T) {
+	advance := fixClock(t)
+	l := New(Budget{Requests: 2, Period: 2 * time.Minute})
+
+	if ok, _ := l.Allow("ip:1", "user:alice"); !ok {
+		t.Fatal("first request rejected")
+	}
+	if ok, _ := l.Allow("ip:1"); !ok {
+		t.Fatal("second request of the IP rejected")
+	}
+
+	// the IP is over budget, so the user does not spend its last token
+	ok, retryAfter := l.Allow("ip:1", "user:alice")
+	if ok || retryAfter != time.Minute {
+		t.Fatalf("request over the budget of the IP: %v, retry after %s", ok, retryAfter)
+	}
+	if ok, _ := l.Allow("ip:2", "user:alice"); !ok {
+		t.Fatal("the user spent a token on a rejected request")
+	}
+
+	// retryAfter is the wait of the emptiest bucket
+	advance(30 * time.Second)
+	l.Allow("ip:3")
+	l.Allow("ip:3")
+	if ok, retryAfter := l.Allow("ip:1", "ip:3", "ip:2"); ok || retryAfter != time.Minute {
+		t.Errorf("request over two budgets: %v, retry after %s, want 1m", ok, retryAfter)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	advance := fixClock(t)
+	l := New(Budget{Requests: 2, Period: 10 * time.Minute})
+
+	l.Allow("idle")
+	l.Allow("busy")
+	l.Allow("busy")
+
+	// "idle" is full again after 5 minutes, "busy" after 10
+	advance(6 * time.Minute)
+	l.Allow("new")
+	if _, found := l.buckets["idle"]; found {
+		t.Error("a full bucket was not evicted")
+	}
+	if _, found := l.buckets["busy"]; !found {
+		t.Error("a bucket that is not full was evicted")
+	}
+
+	advance(10 * time.Minute)
+	l.Allow("new")
+	if len(l.buckets) != 1 {
+		t.Errorf("%d buckets after all the others are full again, want 1", len(l.buckets))
+	}
+}
+
+func TestNoSweepBeforeInterval(t *testing.T) {
+	advance := fixClock(t)
+	l := New(Budget{Requests: 1, Period: time.Second})
+
+	l.Allow("a")
+	advance(sweepInterval / 2)
+	l.Allow("b")
+	if _, found := l.buckets["a"]; !found {
+		t.Error("swept before sweepInterval")
+	}
+
+	advance(sweepInterval / 2)
+	l.Allow("c")
+	if _, found := l.buckets["a"]; found {
+		t.Error("not swept after sweepInterval")
+	}
+}
@@ -0,0 +1,213 @@
+package seed
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"strings"
+)
+
+// Options describe a generated dataset. The counts are averages: each user and photo gets a random number of items,
+// uniformly distributed between zero and twice the average.
+type Options struct {
+	// Seed makes the dataset reproducible, the same options give the same dataset
+	Seed int64
+
+	// Users is the number of users
+	Users int
+
+	// Photos is the average number of photos per user
+	Photos int
+
+	// Follows is the average number of users followed by each user. Who is followed follows a power law: a few users
+	// are followed by many, most by a few.
+	Follows int
+
+	// Comments is the average number of comments per photo
+	Comments int
+
+	// Likes is the average number of likes per photo
+	Likes int
+}
+
+// Sizes of the generated images, small enough to import thousands of them quickly
+const (
+	imageWidth  = 96
+	imageHeight = 72
+	imageBlocks = 6
+)
+
+var words = strings.Fields(`coffee morning light city walk sea mountain friends dinner sunset cat dog book music
+	train rain street garden bike summer winter road river market night lake window bread party snow forest`)
+
+var tags = strings.Fields(`travel food nature art photography sunday tbt home family weekend`)
+
+// Generate returns a random dataset as described by `opts`.
+func Generate(opts Options) (Dataset, error) {
+
+	if opts.Users < 1 || opts.Photos < 0 || opts.Follows < 0 || opts.Comments < 0 || opts.Likes < 0 {
+		return Dataset{}, fmt.Errorf("invalid options %+v", opts)
+	}
+
+	r := rand.New(rand.NewSource(opts.Seed))
+
+	ds := Dataset{Users: make([]User, opts.Users)}
+
+	for i := range ds.Users {
+		ds.Users[i] = User{
+			Name:        fmt.Sprintf("user%05d", i),
+			DisplayName: capitalize(words[r.Intn(len(words))]) + " " + capitalize(words[r.Intn(len(words))]),
+			Bio:         sentence(r, ds.Users[:i], 6),
+		}
+	}
+
+	// the lower the index, the more popular the user
+	var popular *rand.Zipf
+
+	if opts.Users > 1 {
+		popular = rand.NewZipf(r, 1.1, 2, uint64(opts.Users-1))
+	}
+
+	for i := range ds.Users {
+
+		u := &ds.Users[i]
+
+		if popular != nil {
+
+			followed := map[int]bool{}
+			want := upTo(r, 2*opts.Follows)
+
+			// bounded, since the most popular users are drawn over and over
+			for attempt := 0; len(followed) < want && attempt < 4*want; attempt++ {
+
+				j := int(popular.Uint64())
+
+				if j != i && !followed[j] {
+					followed[j] = true
+					u.Follows = append(u.Follows, ds.Users[j].Name)
+				}
+			}
+		}
+
+		photos := upTo(r, 2*opts.Photos)
+
+		for p := 0; p < photos; p++ {
+
+			photo := Photo{
+				ID:          fmt.Sprintf("%s-%d", u.Name, p),
+				Description: sentence(r, ds.Users, 8),
+				AltText:     words[r.Intn(len(words))] + " and " + words[r.Intn(len(words))],
+			}
+
+			var err error
+
+			photo.png, err = randomImage(r)
+
+			if err != nil {
+				return Dataset{}, err
+			}
+
+			liked := map[string]bool{}
+
+			for l := upTo(r, 2*opts.Likes); l > 0; l-- {
+
+				liker := ds.Users[r.Intn(len(ds.Users))].Name
+
+				if !liked[liker] {
+					liked[liker] = true
+					photo.Likes = append(photo.Likes, liker)
+				}
+			}
+
+			for c := upTo(r, 2*opts.Comments); c > 0; c-- {
+				photo.Comments = append(photo.Comments, Comment{
+					Author: ds.Users[r.Intn(len(ds.Users))].Name,
+					Body:   sentence(r, ds.Users, 5),
+				})
+			}
+
+			u.Photos = append(u.Photos, photo)
+		}
+	}
+
+	return ds, nil
+}
+
+// upTo returns a random number between 0 and `max`, both included.
+func upTo(r *rand.Rand, max int) int {
+	return r.Intn(max + 1)
+}
+
+func capitalize(word string) string {
+	return strings.ToUpper(word[:1]) + word[1:]
+}
+
+// sentence returns `n` random words, with the chance of a hashtag and of a mention of one of `users`.
+func sentence(r *rand.Rand, users []User, n int) string {
+
+	parts := make([]string, 0, n+2)
+
+	for i := 0; i < n; i++ {
+		parts = append(parts, words[r.Intn(len(words))])
+	}
+
+	if r.Intn(2) == 0 {
+		parts = append(parts, "#"+tags[r.Intn(len(tags))])
+	}
+
+	if len(users) > 0 && r.Intn(4) == 0 {
+		parts = append(parts, "@"+users[r.Intn(len(users))].Name)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// randomImage returns a PNG made of blocks of random colors, around a random base color.
+func randomImage(r *rand.Rand) ([]byte, error) {
+
+	img := image.NewRGBA(image.Rect(0, 0, imageWidth, imageHeight))
+
+	base := color.RGBA{R: uint8(r.Intn(256)), G: uint8(r.Intn(256)), B: uint8(r.Intn(256)), A: 255}
+	size := imageWidth / imageBlocks
+
+	for by := 0; by*size < imageHeight; by++ {
+		for bx := 0; bx < imageBlocks; bx++ {
+
+			c := color.RGBA{R: shade(r, base.R), G: shade(r, base.G), B: shade(r, base.B), A: 255}
+
+			for y := by * size; y < (by+1)*size && y < imageHeight; y++ {
+				for x := bx * size; x < (bx+1)*size; x++ {
+					img.SetRGBA(x, y, c)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+
+	err := png.Encode(&buf, img)
+
+	if err != nil {
+		return nil, fmt.Errorf("error encoding image: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func shade(r *rand.Rand, v uint8) uint8 {
+
+	s := int(v) + r.Intn(81) - 40
+
+	if s < 0 {
+		return 0
+	}
+
+	if s > 255 {
+		return 255
+	}
+
+	return uint8(s)
+}
@@ -0,0 +1,153 @@
+package seed
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
+	"github.com/sirupsen/logrus"
+)
+
+// Stats counts what Import wrote
+type Stats struct {
+	Users    int
+	Photos   int
+	Follows  int
+	Likes    int
+	Comments int
+}
+
+// Import writes the dataset `ds` to `db`: users first (with their profiles), then photos, follows, likes and comments.
+// Users that already exist are reused, but the import is meant for an empty database: photos, follows, likes and
+// comments are always written anew.
+func Import(ctx context.Context, db database.AppDatabase, ds Dataset, logger logrus.FieldLogger) (stats Stats, err error) {
+
+	err = ds.Validate()
+
+	if err != nil {
+		return stats, err
+	}
+
+	IDs := map[string]string{}
+
+	for _, u := range ds.Users {
+
+		_, err = db.PostUserID(ctx, u.Name)
+
+		if err == nil {
+			IDs[u.Name], err = db.GetUserID(ctx, u.Name)
+		}
+
+		if err == nil && (u.DisplayName != "" || u.Bio != "") {
+			_, err = db.UpdateProfile(ctx, u.Name, components.ProfileUpdate{DisplayName: &u.DisplayName, Bio: &u.Bio})
+		}
+
+		if err != nil {
+			return stats, fmt.Errorf("error creating user %s: %w", u.Name, err)
+		}
+
+		stats.Users++
+	}
+
+	logger.Infof("seed: %d users created", stats.Users)
+
+	for _, u := range ds.Users {
+		for _, p := range u.Photos {
+
+			if p.png == nil {
+				// seeded by the ID, so that a description always gives the same images
+				h := sha256.Sum256([]byte(p.ID))
+
+				p.png, err = randomImage(rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(h[:])))))
+
+				if err != nil {
+					return stats, err
+				}
+			}
+
+			_, err = db.UploadPhoto(ctx, u.Name, components.Photo{
+				Desc: p.Description,
+				Media: []components.MediaUpload{{
+					Data:    base64.StdEncoding.EncodeToString(p.png),
+					AltText: p.AltText,
+				}},
+			}, hash(p.ID))
+
+			if err != nil {
+				return stats, fmt.Errorf("error uploading photo %s: %w", p.ID, err)
+			}
+
+			stats.Photos++
+
+			if stats.Photos%1000 == 0 {
+				logger.Infof("seed: %d photos uploaded", stats.Photos)
+			}
+		}
+	}
+
+	logger.Infof("seed: %d photos uploaded", stats.Photos)
+
+	for _, u := range ds.Users {
+		for _, f := range u.Follows {
+
+			_, err = db.FollowUser(ctx, u.Name, f)
+
+			if err != nil {
+				return stats, fmt.Errorf("error following %s by %s: %w", f, u.Name, err)
+			}
+
+			stats.Follows++
+		}
+	}
+
+	for _, u := range ds.Users {
+		for _, p := range u.Photos {
+
+			for _, l := range p.Likes {
+
+				_, err = db.LikePhoto(ctx, IDs[l], hash(p.ID))
+
+				if err != nil {
+					return stats, fmt.Errorf("error liking photo %s by %s: %w", p.ID, l, err)
+				}
+
+				stats.Likes++
+			}
+
+			for i, c := range p.Comments {
+
+				commentID := hash(fmt.Sprintf("%s:%d", p.ID, i))
+
+				_, err = db.CommentPhoto(ctx, c.Author, hash(p.ID), components.Comment{
+					Comment_ID:   components.SHA256hash{Hash: commentID},
+					Body:         c.Body,
+					CreationTime: components.JSONTime(time.Now().UTC()),
+					Parent:       components.SHA256hash{Hash: hash(p.ID)},
+				})
+
+				if err != nil {
+					return stats, fmt.Errorf("error commenting photo %s by %s: %w", p.ID, c.Author, err)
+				}
+
+				stats.Comments++
+			}
+		}
+	}
+
+	logger.Infof("seed: %d follows, %d likes and %d comments written", stats.Follows, stats.Likes, stats.Comments)
+
+	return stats, nil
+}
+
+// hash turns the ID of a photo (or comment) of a dataset into one in the format of the API.
+func hash(ID string) string {
+	h := sha256.Sum256([]byte(ID))
+	return hex.EncodeToString(h[:])
+}
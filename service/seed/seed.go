@@ -0,0 +1,194 @@
+/*
+Package seed fills a database with users, follows, photos, comments and likes, to try the stream and the search at a
+realistic scale.
+
+The data come from a Dataset, either loaded from a JSON or YAML description (see Load) or generated (see Generate).
+Generated datasets are deterministic: the same Options, seed included, always give the same users, graph, texts and
+images. Import then writes a Dataset through database.AppDatabase, as the API would, so that every index (tags,
+mentions, full-text search) is filled in too.
+
+Example of a description:
+
+	users:
+	  - name: alice
+	    display_name: Alice
+	    follows: [bob]
+	    photos:
+	      - id: alice-sunset
+	        description: "Sunset #sea with @bob"
+	        image: images/sunset.png
+	        likes: [bob]
+	        comments:
+	          - author: bob
+	            body: "Wow!"
+	  - name: bob
+
+Photos without an image get a random one. Photo IDs are hashed into the API format, so any unique string will do.
+*/
+package seed
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/components"
+	"gopkg.in/yaml.v2"
+)
+
+// Dataset is the content to import, users are created in order
+type Dataset struct {
+	Users []User `yaml:"users"`
+}
+
+// User is a user to create, together with what they follow and publish
+type User struct {
+	Name        string   `yaml:"name"`
+	DisplayName string   `yaml:"display_name"`
+	Bio         string   `yaml:"bio"`
+	Follows     []string `yaml:"follows"`
+	Photos      []Photo  `yaml:"photos"`
+}
+
+// Photo is a post with a single image
+type Photo struct {
+	ID          string    `yaml:"id"`
+	Description string    `yaml:"description"`
+	AltText     string    `yaml:"alt_text"`
+	Comments    []Comment `yaml:"comments"`
+	Likes       []string  `yaml:"likes"`
+
+	// Image is the path of a PNG file, relative to the description; if empty, a random image is generated
+	Image string `yaml:"image"`
+
+	// png is the content of the image, if already known (e.g., generated)
+	png []byte
+}
+
+// Comment is a comment to a photo
+type Comment struct {
+	Author string `yaml:"author"`
+	Body   string `yaml:"body"`
+}
+
+// Load reads the dataset described in the JSON or YAML file at `path`, and reads the images it refers to.
+func Load(path string) (Dataset, error) {
+
+	var ds Dataset
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return ds, fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	// YAML is a superset of JSON
+	err = yaml.UnmarshalStrict(data, &ds)
+
+	if err != nil {
+		return ds, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	for i := range ds.Users {
+		for j := range ds.Users[i].Photos {
+
+			photo := &ds.Users[i].Photos[j]
+
+			if photo.Image == "" {
+				continue
+			}
+
+			image := photo.Image
+
+			if !filepath.IsAbs(image) {
+				image = filepath.Join(filepath.Dir(path), image)
+			}
+
+			photo.png, err = os.ReadFile(image)
+
+			if err != nil {
+				return ds, fmt.Errorf("error reading image of photo %s: %w", photo.ID, err)
+			}
+		}
+	}
+
+	return ds, ds.Validate()
+}
+
+// Validate checks that the names are valid and unique, and that every reference is to a user of the dataset.
+func (ds Dataset) Validate() error {
+
+	users := map[string]bool{}
+	photos := map[string]bool{}
+
+	for _, u := range ds.Users {
+
+		if !components.ValidUsername(u.Name) {
+			return fmt.Errorf("invalid username %q", u.Name)
+		}
+
+		if users[u.Name] {
+			return fmt.Errorf("duplicate user %s", u.Name)
+		}
+
+		users[u.Name] = true
+
+		err := components.ProfileUpdate{DisplayName: &u.DisplayName, Bio: &u.Bio}.Validate()
+
+		if err != nil {
+			return fmt.Errorf("invalid profile of %s: %w", u.Name, err)
+		}
+
+		for _, p := range u.Photos {
+
+			if p.ID == "" {
+				return fmt.Errorf("photo of %s without an ID", u.Name)
+			}
+
+			if photos[p.ID] {
+				return fmt.Errorf("duplicate photo %s", p.ID)
+			}
+
+			photos[p.ID] = true
+		}
+	}
+
+	check := func(name string, what string) error {
+		if !users[name] {
+			return fmt.Errorf("%s refers to unknown user %q", what, name)
+		}
+		return nil
+	}
+
+	for _, u := range ds.Users {
+
+		for _, f := range u.Follows {
+			if err := check(f, "a follow of "+u.Name); err != nil {
+				return err
+			}
+		}
+
+		for _, p := range u.Photos {
+
+			for _, l := range p.Likes {
+				if err := check(l, "a like of photo "+p.ID); err != nil {
+					return err
+				}
+			}
+
+			for _, c := range p.Comments {
+
+				if err := check(c.Author, "a comment of photo "+p.ID); err != nil {
+					return err
+				}
+
+				if c.Body == "" {
+					return errors.New("empty comment to photo " + p.ID)
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+/*
+Package tracing carries the identity of a request through the layers of the service: its request ID, for the logs, and
+its spans, for distributed tracing.
+
+The Tracer and Span interfaces follow those of OpenTelemetry (trace.Tracer and trace.Span), reduced to what the
+service uses, so that an adapter to an OpenTelemetry SDK is a thin wrapper. Spans are started with Start, which uses
+the Tracer set by SetTracer: by default a no-op one, or the one of NewWriterTracer, which prints the spans as JSON
+lines, for local testing.
+
+Trace IDs coming from other services are read from the W3C `traceparent` header (see ParseTraceparent).
+*/
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+)
+
+// TraceID is the ID of a trace, shared by all of its spans
+type TraceID [16]byte
+
+// SpanID is the ID of a span within its trace
+type SpanID [8]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid returns whether the ID is not all zeros, as the W3C Trace Context requires.
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid returns whether the ID is not all zeros, as the W3C Trace Context requires.
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// SpanContext identifies a span, local or remote
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid returns whether both IDs are valid.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Span is an operation of a trace. Its methods are safe for concurrent use, and do nothing after End.
+type Span interface {
+	// End completes the span
+	End()
+
+	// SetAttribute records `key`, of a simple type (string, bool, number)
+	SetAttribute(key string, value interface{})
+
+	// RecordError marks the span as failed by `err`, if not nil
+	RecordError(err error)
+
+	// SpanContext returns the identity of the span
+	SpanContext() SpanContext
+}
+
+// Tracer starts spans
+type Tracer interface {
+	// Start starts the span `name` as a child of the span in `ctx`, or of its remote parent, and returns `ctx` with
+	// the new span in it.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+var (
+	tracerMu sync.RWMutex
+	tracer   Tracer = noopTracer{}
+)
+
+// SetTracer sets the Tracer of Start, nil restores the no-op one, and returns the previous one, for Shutdown.
+func SetTracer(t Tracer) (previous Tracer) {
+	tracerMu.Lock()
+	defer tracerMu.Unlock()
+
+	if t == nil {
+		t = noopTracer{}
+	}
+	previous, tracer = tracer, t
+	return previous
+}
+
+// Shutdown flushes the spans of `t` and releases its resources, if it has any: that is, if it has a
+// `Shutdown(context.Context) error` method, as the TracerProvider of the OpenTelemetry SDK. The spans ended after
+// Shutdown are dropped, so `t` must not be in use anymore.
+func Shutdown(ctx context.Context, t Tracer) error {
+	if s, ok := t.(interface{ Shutdown(context.Context) error }); ok {
+		return s.Shutdown(ctx)
+	}
+	return nil
+}
+
+// Start starts the span `name` with the Tracer set by SetTracer.
+func Start(ctx context.Context, name string) (context.Context, Span) {
+	tracerMu.RLock()
+	t := tracer
+	tracerMu.RUnlock()
+
+	return t.Start(ctx, name)
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+type requestIDKey struct{}
+
+// ContextWithSpan returns `ctx` with `span` as the current span.
+func ContextWithSpan(ctx context.Context, span Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span of `ctx`, nil if none.
+func SpanFromContext(ctx context.Context) Span {
+	span, _ := ctx.Value(spanKey{}).(Span)
+	return span
+}
+
+// ContextWithRemoteParent returns `ctx` with `sc`, coming from another service, as the parent of the spans started
+// from it (unless they have a local one).
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// parentOf returns the span context of the parent of a span started from `ctx`, if any.
+func parentOf(ctx context.Context) (parent SpanContext, ok bool) {
+	if span := SpanFromContext(ctx); span != nil && span.SpanContext().IsValid() {
+		return span.SpanContext(), true
+	}
+
+	parent, ok = ctx.Value(remoteKey{}).(SpanContext)
+	return parent, ok && parent.IsValid()
+}
+
+// WithRequestID returns `ctx` carrying the ID of the request it serves, for the logs.
+func WithRequestID(ctx context.Context, reqID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, reqID)
+}
+
+// RequestID returns the ID of the request served by `ctx`, empty if none.
+func RequestID(ctx context.Context) string {
+	reqID, _ := ctx.Value(requestIDKey{}).(string)
+	return reqID
+}
+
+// ParseTraceparent parses the value of a W3C `traceparent` header (`00-<trace ID>-<parent ID>-<flags>`). Later
+// versions of the format are accepted, as the specification requires, as long as they start the same way.
+func ParseTraceparent(header string) (sc SpanContext, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || len(version) != 1 {
+		return sc, false
+	}
+
+	if !decodeHex(parts[1], sc.TraceID[:]) || !decodeHex(parts[2], sc.SpanID[:]) {
+		return sc, false
+	}
+
+	var flags [1]byte
+	if !decodeHex(parts[3], flags[:]) {
+		return sc, false
+	}
+
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// decodeHex decodes `s`, of lowercase hex digits, filling exactly `dst`.
+func decodeHex(s string, dst []byte) bool {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// newTraceID returns a random trace ID.
+func newTraceID() (id TraceID) {
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+// newSpanID returns a random span ID.
+func newSpanID() (id SpanID) {
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+// noopTracer starts spans that record nothing. They keep the identity of their parent, so that IDs coming from other
+// services are still available.
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := parentOf(ctx)
+	span := noopSpan{sc: parent}
+	return ContextWithSpan(ctx, span), span
+}
+
+type noopSpan struct {
+	sc SpanContext
+}
+
+func (noopSpan) End()                                       {}
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) RecordError(err error)                      {}
+func (s noopSpan) SpanContext() SpanContext                 { return s.sc }
@@ -0,0 +1,145 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const (
+	traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	spanID  = "00f067aa0ba902b7"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		ok      bool
+		sampled bool
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", true, true},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", true, false},
+		{"other flags", "00-" + traceID + "-" + spanID + "-02", true, false},
+		{"surrounding spaces", " 00-" + traceID + "-" + spanID + "-01 ", true, true},
+		{"later version", "01-" + traceID + "-" + spanID + "-01", true, true},
+		{"later version with more fields", "cc-" + traceID + "-" + spanID + "-01-what-ever", true, true},
+
+		{"empty", "", false, false},
+		{"too few fields", "00-" + traceID + "-" + spanID, false, false},
+		{"version 00 with more fields", "00-" + traceID + "-" + spanID + "-01-extra", false, false},
+		{"version ff", "ff-" + traceID + "-" + spanID + "-01", false, false},
+		{"version not hex", "0x-" + traceID + "-" + spanID + "-01", false, false},
+		{"version too long", "000-" + traceID + "-" + spanID + "-01", false, false},
+		{"trace ID all zeros", "00-" + strings.Repeat("0", 32) + "-" + spanID + "-01", false, false},
+		{"span ID all zeros", "00-" + traceID + "-" + strings.Repeat("0", 16) + "-01", false, false},
+		{"trace ID too short", "00-" + traceID[1:] + "-" + spanID + "-01", false, false},
+		{"span ID too long", "00-" + traceID + "-" + spanID + "0-01", false, false},
+		{"uppercase", "00-" + strings.ToUpper(traceID) + "-" + spanID + "-01", false, false},
+		{"not hex", "00-" + traceID[:31] + "g-" + spanID + "-01", false, false},
+		{"flags too long", "00-" + traceID + "-" + spanID + "-001", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.header)
+			if ok != tt.ok {
+				t.Fatalf("ParseTraceparent(%q) ok = %v, want %v", tt.header, ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if sc.TraceID.String() != traceID || sc.SpanID.String() != spanID || sc.Sampled != tt.sampled {
+				t.Errorf("ParseTraceparent(%q) = %s-%s sampled %v", tt.header, sc.TraceID, sc.SpanID, sc.Sampled)
+			}
+		})
+	}
+}
+
+func TestWriterTracer(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewWriterTracer(&buf)
+
+	parent, ok := ParseTraceparent("00-" + traceID + "-" + spanID + "-01")
+	if !ok {
+		t.Fatal("invalid traceparent")
+	}
+	ctx := ContextWithRemoteParent(context.Background(), parent)
+
+	ctx, outer := tracer.Start(ctx, "outer")
+	_, inner := tracer.Start(ctx, "inner")
+	inner.SetAttribute("key", "value")
+	inner.End()
+	inner.End()
+	outer.End()
+
+	var records []spanRecord
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var r spanRecord
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("decoding %s: %v", line, err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 2 {
+		t.Fatalf("%d spans written, want 2 (each once): %s", len(records), buf.String())
+	}
+
+	in, out := records[0], records[1]
+	if in.Name != "inner" || out.Name != "outer" {
+		t.Fatalf("spans written in the wrong order: %s, %s", in.Name, out.Name)
+	}
+	if in.TraceID != traceID || out.TraceID != traceID {
+		t.Errorf("the spans are not in the trace of the remote parent: %s, %s", in.TraceID, out.TraceID)
+	}
+	if out.ParentID != spanID || in.ParentID != out.SpanID {
+		t.Errorf("wrong parents: outer %s, inner %s", out.ParentID, in.ParentID)
+	}
+	if in.Attributes["key"] != "value" {
+		t.Errorf("attributes of inner: %v", in.Attributes)
+	}
+
+	// a root span starts a trace of its own
+	_, root := tracer.Start(context.Background(), "root")
+	if sc := root.SpanContext(); !sc.IsValid() || sc.TraceID.String() == traceID || !sc.Sampled {
+		t.Errorf("root span %s-%s, sampled %v", sc.TraceID, sc.SpanID, sc.Sampled)
+	}
+}
+
+func TestSetTracerAndShutdown(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriterTracer(&buf)
+
+	previous := SetTracer(writer)
+	t.Cleanup(func() { SetTracer(nil) })
+	if _, ok := previous.(noopTracer); !ok {
+		t.Fatalf("the default tracer is %T", previous)
+	}
+
+	_, running := Start(context.Background(), "running")
+
+	if previous := SetTracer(nil); previous != writer {
+		t.Fatalf("SetTracer returned %T, not the tracer it replaced", previous)
+	}
+	if err := Shutdown(context.Background(), writer); err != nil {
+		t.Fatalf("shutting down: %v", err)
+	}
+
+	// the spans of a tracer that was shut down are dropped
+	running.End()
+	if buf.Len() != 0 {
+		t.Errorf("a span was written after the shutdown: %s", buf.String())
+	}
+
+	// the no-op tracer has nothing to shut down, and keeps the remote parent
+	if err := Shutdown(context.Background(), noopTracer{}); err != nil {
+		t.Errorf("shutting down the no-op tracer: %v", err)
+	}
+	parent, _ := ParseTraceparent("00-" + traceID + "-" + spanID + "-01")
+	_, span := Start(ContextWithRemoteParent(context.Background(), parent), "noop")
+	if span.SpanContext() != parent {
+		t.Errorf("the no-op span has %v, want the remote parent", span.SpanContext())
+	}
+}
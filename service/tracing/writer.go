@@ -0,0 +1,143 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// NewWriterTracer returns a Tracer that writes each span to `w` when it ends, as a line of JSON: a stand-in for an
+// exporter, to follow the traces locally. After its Shutdown, nothing more is written (`w` is not closed).
+func NewWriterTracer(w io.Writer) Tracer {
+	return &writerTracer{w: w}
+}
+
+type writerTracer struct {
+	mu       sync.Mutex
+	w        io.Writer
+	shutdown bool
+}
+
+func (t *writerTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &writerSpan{
+		tracer: t,
+		name:   name,
+		start:  time.Now(),
+	}
+
+	if parent, ok := parentOf(ctx); ok {
+		span.sc.TraceID = parent.TraceID
+		span.sc.Sampled = parent.Sampled
+		span.parent = parent.SpanID
+	} else {
+		span.sc.TraceID = newTraceID()
+		span.sc.Sampled = true
+	}
+	span.sc.SpanID = newSpanID()
+
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *writerTracer) export(record spanRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.shutdown {
+		_, _ = t.w.Write(append(line, '\n'))
+	}
+}
+
+// Shutdown stops the writes: the spans are written as they end, so none is pending.
+func (t *writerTracer) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.shutdown = true
+	return nil
+}
+
+type writerSpan struct {
+	tracer *writerTracer
+	name   string
+	start  time.Time
+	sc     SpanContext
+	parent SpanID
+
+	mu         sync.Mutex
+	ended      bool
+	attributes map[string]interface{}
+	err        string
+}
+
+// spanRecord is the JSON of an ended span
+type spanRecord struct {
+	Name       string                 `json:"name"`
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Start      time.Time              `json:"start"`
+	DurationMS float64                `json:"duration_ms"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+func (s *writerSpan) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+
+	record := spanRecord{
+		Name:       s.name,
+		TraceID:    s.sc.TraceID.String(),
+		SpanID:     s.sc.SpanID.String(),
+		Start:      s.start.UTC(),
+		DurationMS: float64(time.Since(s.start).Microseconds()) / 1000,
+		Attributes: s.attributes,
+		Error:      s.err,
+	}
+	if s.parent.IsValid() {
+		record.ParentID = s.parent.String()
+	}
+	s.mu.Unlock()
+
+	s.tracer.export(record)
+}
+
+func (s *writerSpan) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return
+	}
+	if s.attributes == nil {
+		s.attributes = map[string]interface{}{}
+	}
+	s.attributes[key] = value
+}
+
+func (s *writerSpan) RecordError(err error) {
+	if err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.ended {
+		s.err = err.Error()
+	}
+}
+
+func (s *writerSpan) SpanContext() SpanContext {
+	return s.sc
+}
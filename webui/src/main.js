@@ -28,6 +28,7 @@ var state = {
         Authorization: null
     },
     username: null,
+    id: null,
     current_view: null
 
 }